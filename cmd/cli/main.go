@@ -3,10 +3,24 @@ package main
 import (
 	"database/sql"
 	"flag"
+	"fmt"
+	"io"
+	"ledger/pkg/audit"
+	"ledger/pkg/beancount"
 	"ledger/pkg/csvreader"
+	"ledger/pkg/journal"
 	"ledger/pkg/ledger"
+	"ledger/pkg/migrations"
+	"ledger/pkg/ofx"
+	"ledger/pkg/qifreader"
+	"ledger/pkg/usd"
 	"ledger/pkg/utils"
 	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 )
 
@@ -16,19 +30,80 @@ func main() {
 	// define flags for input from the command line
 	insertMode := flag.Bool("insert", false, "insert a transaction")
 	summaryMode := flag.Bool("summary", false, "get balances of all buckets")
+	updateMode := flag.Bool("update", false, "update the transaction given by -id")
+	deleteMode := flag.Bool("delete", false, "delete the transaction given by -id")
+	closePeriodMode := flag.Bool("close-period", false, "close every period through -entrydate into the equity bucket given by -bucket")
+	reopenPeriodMode := flag.Bool("reopen-period", false, "reopen the last closed period")
+	voidMode := flag.Bool("void", false, "void the transaction given by -id with a reversing entry on -entrydate, or on its own date")
+	registerMode := flag.Bool("register", false, "register the bucket given by -bucket")
+	closeBucketMode := flag.Bool("close-bucket", false, "close the bucket given by -bucket on the -closed date")
+	stopMode := flag.Bool("stop", false, "stop the repeating entry given by -id after the -until date")
+	schedulesMode := flag.Bool("schedules", false, "list all repeating entries")
+	assertMode := flag.Bool("assert", false, "assert that -bucket holds -amount at the end of -entrydate")
+	checkMode := flag.Bool("check", false, "check every balance assertion")
+	zeroMode := flag.Bool("zero", false, "find when -bucket drops below -threshold, looking ahead through -through")
+	migrateMode := flag.Bool("migrate", false, "bring the database schema up to date")
+	historyMode := flag.Bool("history", false, "list the latest -limit changes to ledger and budget entries")
+	undo := flag.Int("undo", 0, "undo the given number of latest change sets, each the changes one operation made to ledger and budget entries")
+	report := flag.String("report", "", "print a financial statement from -from through -through: "+strings.Join(ledger.Reports, ", "))
+	reconcileMode := flag.Bool("reconcile", false, "reconcile -bucket against a statement balance of -balance as of -through")
+	pricesMode := flag.Bool("prices", false, "list every recorded price, or with -csv load price history from -filepath")
+	fxGainsMode := flag.Bool("fx-gains", false, "list unrealized gains in -commodity on holdings of other commodities as of -through")
+	importJournalMode := flag.Bool("import-journal", false, "insert the entries, balance assertions and prices of the ledger-cli or hledger journal at -filepath")
+	exportJournalMode := flag.Bool("export-journal", false, "write every entry, balance assertion and price as a ledger-cli or hledger journal to -filepath, or to stdout")
+	importOFXMode := flag.Bool("import-ofx", false, "insert the transactions of the OFX or QFX statement at -filepath as ledger entries between -bucket and -counter, or with -category as budget entries")
+	importQIFMode := flag.Bool("import-qif", false, "insert the transactions of the QIF file at -filepath as ledger entries, or with -budget as budget entries, naming buckets by -map")
+	exportBeancountMode := flag.Bool("export-beancount", false, "write every bucket, entry, budget entry and price as a Beancount file to -filepath, or to stdout")
 
 	csvMode := flag.Bool("csv", false, "inert a transaction using a csv")
-	filepath := flag.String("filepath", "", "path to csv file to read")
-	repeat := flag.String("repeat", "", "how often an entry repeats: 'weekly' or 'monthly'")
+	filepath := flag.String("filepath", "", "path to csv, journal or Beancount file to read or write")
+	counter := flag.String("counter", "uncategorized", "bucket on the other side of each transaction -import-ofx reads")
+	category := flag.String("category", "", "budget category -import-ofx records transactions in, instead of the ledger")
+	qifMapping := flag.String("map", "", "comma-separated QIF account or category names and the buckets -import-qif records them in, e.g. 'Food:Groceries = expenses:groceries'")
+	qifBudget := flag.Bool("budget", false, "record the transactions -import-qif reads as budget entries instead of ledger entries")
+	balanceDates := flag.String("balance-dates", "", "comma-separated dates whose closing balances -export-beancount asserts")
+	repeat := flag.String("repeat", "", "how often an entry repeats: "+strings.Join(ledger.Frequencies, ", "))
+	every := flag.Int("every", 0, "repeat every n weeks or months, e.g. 2 with -repeat weekly is every other week")
+	until := flag.String("until", "", "last date a repeating entry may occur")
+	count := flag.Int("count", 0, "number of times an entry repeats")
+	shift := flag.String("shift", "", "move repeats that fall on a weekend to the 'friday' before or the 'monday' after")
+	schedule := flag.Bool("schedule", false, "apply -update or -delete to the repeating entry given by -id")
 
-	through := flag.String("through", "", "date through which to summarize")
+	through := flag.String("through", "", "date through which to summarize, forecast or report")
+	from := flag.String("from", "", "first date of the -report period, by default the start of the year")
+	compare := flag.String("compare", "", "comma-separated columns to compare a -report against: previous, prior-year")
+	cash := flag.String("cash", "", "comma-separated buckets a cashflow -report follows, by default every registered asset bucket")
+	threshold := flag.Int("threshold", 0, "balance in cents that -zero warns about dropping below")
+	depth := flag.Int("depth", 0, "number of bucket levels to summarize, e.g. 1 rolls assets:bank:checking up into assets; 0 shows every bucket")
 
 	source := flag.String("source", "", "bucket from which the amount is taken")
 	destination := flag.String("destination", "", "bucket into which the amount is deposited")
 	entrydate := flag.String("entrydate", "", "date of transaction")
 	amount := flag.Int("amount", 0, "amount in cents of the transaction")
+	commodity := flag.String("commodity", "", "commodity of the amount to insert, update or assert, or to convert -summary, -report and -fx-gains into; USD by default")
+	payee := flag.String("payee", "", "who the amount was paid to or received from")
+	memo := flag.String("memo", "", "note on why the amount moved")
+	tags := flag.String("tags", "", "comma-separated tags to insert with, or to filter the summary by")
+	status := flag.String("status", "", "status of an updated entry: pending or cleared")
+	id := flag.Int("id", 0, "id of the transaction or repeating entry to update, delete, void or stop")
+
+	bucket := flag.String("bucket", "", "name of the bucket to register, close, reconcile, assert, forecast, -import-ofx into or -import-qif into outside named accounts")
+	bucketType := flag.String("type", "", "type of the bucket: asset, liability, income, expense or equity")
+	displayName := flag.String("display", "", "display name of the bucket")
+	opened := flag.String("opened", "", "date the bucket was opened")
+	closed := flag.String("closed", "", "date the bucket was closed")
+
+	balance := flag.Int("balance", 0, "statement balance in cents to reconcile against")
+	clear := flag.String("clear", "", "comma-separated ids of entries to mark cleared while reconciling")
+	unclear := flag.String("unclear", "", "comma-separated ids of entries to mark pending while reconciling")
+	finish := flag.Bool("finish", false, "lock in the cleared entries once they match the statement balance")
+
+	actorName := flag.String("actor", os.Getenv("USER"), "name to record changes under in the history")
+	limit := flag.Int("limit", 20, "number of changes to list with -history")
+
+	dryRun := flag.Bool("dry-run", false, "with -migrate, show the migrations that would run without applying them; with -import-qif, show the entries without inserting them")
+	list := flag.Bool("list", false, "with -migrate, list every migration and whether it has been applied")
 
-	// zeroMode := flag.Bool("zero", false, "find when a bucket zeroes out")
 	flag.Parse()
 
 	// open connection to the db
@@ -38,53 +113,287 @@ func main() {
 	}
 	defer db.Close()
 
-	if *insertMode && *summaryMode {
+	// bring the schema up to date, unless asked to look before migrating
+	if !*migrateMode {
+		applied, err := migrations.Run(db)
+		if err != nil {
+			log.Fatalf("migrating database: %v", err)
+		}
+		for _, m := range applied {
+			log.Printf("applied migration %d: %s", m.Version, m.Name)
+		}
+	}
+
+	// changes are recorded in the history as made by who
+	who := audit.Actor{Name: *actorName, Source: "cli"}
+
+	modes := 0
+	for _, m := range []bool{*insertMode, *summaryMode, *updateMode, *deleteMode, *voidMode, *closePeriodMode, *reopenPeriodMode, *registerMode, *closeBucketMode, *stopMode, *schedulesMode, *reconcileMode, *assertMode, *checkMode, *zeroMode, *migrateMode, *historyMode, *undo > 0, *report != "", *pricesMode, *fxGainsMode, *importJournalMode, *exportJournalMode, *importOFXMode, *importQIFMode, *exportBeancountMode} {
+		if m {
+			modes++
+		}
+	}
+	if modes > 1 {
 		// instruct user to pick only one mode
-		log.Printf("only use one of -insert or -summary")
+		log.Printf("only use one of -insert, -summary, -update, -delete, -void, -close-period, -reopen-period, -register, -close-bucket, -stop, -schedules, -reconcile, -assert, -check, -zero, -migrate, -history, -undo, -report, -prices, -fx-gains, -import-journal, -export-journal, -import-ofx, -import-qif or -export-beancount")
 		return
-	} else if !*insertMode && !*summaryMode {
+	} else if modes == 0 {
 		// instruct user to pick a mode
-		log.Printf("specify one of -insert or -summary or --zero")
+		log.Printf("specify one of -insert, -summary, -update, -delete, -void, -close-period, -reopen-period, -register, -close-bucket, -stop, -schedules, -reconcile, -assert, -check, -zero, -migrate, -history, -undo, -report, -prices, -fx-gains, -import-journal, -export-journal, -import-ofx, -import-qif or -export-beancount")
 		return
 	} else if *insertMode && *csvMode {
 		// insert entries from a csv
 		entries, err := csvreader.CsvToLedgerEntries(*filepath)
 		if err != nil {
-			log.Fatalf("Reading csv")
+			log.Fatalf("reading csv: %v", err)
 		}
 		// begin the sql transaction
-		tx, err := db.Begin()
+		tx, err := audit.Begin(db, audit.Actor{Name: *actorName, Source: "csv"})
 		if err != nil {
 			log.Fatalf("beginning sql transaction: %v", err)
 		}
-		// insert all entries
-		for _, e := range entries {
-			if err := ledger.InsertEntry(tx, e); err != nil {
-				log.Fatalf("inserting single entry")
+		// insert all entries as one change set
+		err = audit.ChangeSet(tx, func() error {
+			for _, e := range entries {
+				if err := ledger.InsertEntry(tx, e); err != nil {
+					return err
+				}
 			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("inserting single entry")
 		}
 		// commit the sql transaction
 		if err := tx.Commit(); err != nil {
 			log.Fatalf("committing sql transaction: %v", err)
 		}
+	} else if *importJournalMode {
+		// insert everything a journal holds in one sql transaction
+		j, err := journal.ReadFile(*filepath)
+		if err != nil {
+			log.Fatalf("reading journal: %v", err)
+		}
+		tx, err := audit.Begin(db, audit.Actor{Name: *actorName, Source: "journal"})
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if err := journal.Import(tx, j); err != nil {
+			log.Fatalf("importing journal: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		log.Printf("imported %d entries, %d balance assertions and %d prices", len(j.Entries), len(j.Assertions), len(j.Prices))
+	} else if *exportJournalMode {
+		// write the ledger as a journal
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		j, err := journal.Export(tx)
+		if err != nil {
+			log.Fatalf("exporting journal: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		w := io.Writer(os.Stdout)
+		if *filepath != "" {
+			file, err := os.Create(*filepath)
+			if err != nil {
+				log.Fatalf("creating journal file: %v", err)
+			}
+			defer file.Close()
+			w = file
+		}
+		if err := journal.Write(w, j); err != nil {
+			log.Fatalf("writing journal: %v", err)
+		}
+	} else if *importOFXMode {
+		// insert the transactions of a bank statement, skipping those
+		// imported before
+		statement, err := ofx.ReadFile(*filepath)
+		if err != nil {
+			log.Fatalf("reading statement: %v", err)
+		}
+		tx, err := audit.Begin(db, audit.Actor{Name: *actorName, Source: "ofx"})
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		var skipped int
+		if *category != "" {
+			skipped, err = ofx.ImportBudget(tx, statement, *category)
+		} else {
+			skipped, err = ofx.Import(tx, statement, *bucket, *counter)
+		}
+		if err != nil {
+			log.Fatalf("importing statement: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		log.Printf("imported %d transactions, skipped %d already imported", len(statement.Transactions)-skipped, skipped)
+	} else if *importQIFMode {
+		// insert the transactions of a QIF file under the mapping given, or
+		// only show them
+		transactions, err := qifreader.ReadFile(*filepath)
+		if err != nil {
+			log.Fatalf("reading QIF file: %v", err)
+		}
+		m, err := qifreader.ParseMapping(*bucket, *qifMapping)
+		if err != nil {
+			log.Fatalf("parsing -map: %v", err)
+		}
+		batch := qifreader.ToBudget(transactions, m)
+		if !*qifBudget {
+			if batch, err = qifreader.ToLedger(transactions, m); err != nil {
+				log.Fatalf("mapping QIF file: %v", err)
+			}
+		}
+		if *dryRun {
+			printBatch(os.Stdout, batch)
+			return
+		}
+		tx, err := audit.Begin(db, audit.Actor{Name: *actorName, Source: "qif"})
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if err := qifreader.Commit(tx, batch); err != nil {
+			log.Fatalf("importing QIF file: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		log.Printf("imported %d ledger entries and %d budget entries", len(batch.Entries), len(batch.Budget))
+	} else if *exportBeancountMode {
+		// write the ledger and budget as a Beancount file
+		var dates []time.Time
+		for _, s := range strings.Split(*balanceDates, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			d, err := utils.ParseDate(s)
+			if err != nil {
+				log.Fatalf("parsing -balance-dates: %v", err)
+			}
+			dates = append(dates, d)
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		book, err := beancount.Export(tx)
+		if err != nil {
+			log.Fatalf("exporting beancount: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		w := io.Writer(os.Stdout)
+		if *filepath != "" {
+			file, err := os.Create(*filepath)
+			if err != nil {
+				log.Fatalf("creating beancount file: %v", err)
+			}
+			defer file.Close()
+			w = file
+		}
+		if err := beancount.Write(w, book, dates); err != nil {
+			log.Fatalf("writing beancount: %v", err)
+		}
+	} else if *pricesMode && *csvMode {
+		// load price history from a csv
+		prices, err := csvreader.CsvToPrices(*filepath)
+		if err != nil {
+			log.Fatalf("reading csv: %v", err)
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		for _, p := range prices {
+			if _, err := ledger.InsertPrice(tx, p); err != nil {
+				log.Fatalf("inserting price: %v", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		log.Printf("loaded %d prices", len(prices))
+	} else if *pricesMode {
+		// list every recorded price
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		prices, err := ledger.GetPrices(tx)
+		if err != nil {
+			log.Fatalf("getting prices: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		for _, p := range prices {
+			log.Printf("%d %s: 1 %s = %v %s", p.ID, p.Date.Format("2006-01-02"), p.Commodity, p.Rate, p.Quote)
+		}
+	} else if *fxGainsMode {
+		// measure unrealized gains as of today unless told otherwise
+		td := time.Now()
+		if *through != "" {
+			td, err = utils.ParseDate(*through)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		gains, err := ledger.UnrealizedGains(tx, *commodity, td)
+		if err != nil {
+			log.Fatalf("summarizing unrealized gains: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		for _, g := range gains {
+			log.Printf("%s: %s worth %s, cost %s, gain %s", g.Bucket, usd.Format(g.Holding, g.Commodity),
+				usd.Format(g.Value, *commodity), usd.Format(g.Cost, *commodity), usd.Format(g.Gain, *commodity))
+		}
 	} else if *insertMode && *repeat != "" {
-		// insert entry that repeats through 2 years from today
+		// insert an entry that repeats until -until, -count times, or forever
 		d, err := utils.ParseDate(*entrydate)
 		if err != nil {
 			log.Print(err)
 			return
 		}
-		e := ledger.Entry{
-			Source:      *source,
-			Destination: *destination,
-			EntryDate:   d,
-			Amount:      *amount,
+		sc := ledger.Schedule{
+			Source:       *source,
+			Destination:  *destination,
+			Amount:       *amount,
+			Commodity:    *commodity,
+			Payee:        *payee,
+			Memo:         *memo,
+			Tags:         utils.ParseTags(*tags),
+			StartDate:    d,
+			Frequency:    *repeat,
+			Interval:     *every,
+			WeekendShift: *shift,
+			Count:        *count,
 		}
-		tx, err := db.Begin()
+		if *until != "" {
+			if sc.EndDate, err = utils.ParseDate(*until); err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		tx, err := audit.Begin(db, who)
 		if err != nil {
 			log.Fatalf("beginning sql transaction: %v", err)
 		}
-		if err := ledger.InsertRepeatingEntry(tx, e, *repeat); err != nil {
+		if _, err := ledger.InsertSchedule(tx, sc); err != nil {
 			log.Fatalf("inserting a repeating entry: %v", err)
 		}
 		if err := tx.Commit(); err != nil {
@@ -102,8 +411,12 @@ func main() {
 			Destination: *destination,
 			EntryDate:   d,
 			Amount:      *amount,
+			Commodity:   *commodity,
+			Payee:       *payee,
+			Memo:        *memo,
+			Tags:        utils.ParseTags(*tags),
 		}
-		tx, err := db.Begin()
+		tx, err := audit.Begin(db, who)
 		if err != nil {
 			log.Fatalf("beginning sql transaction: %v", err)
 		}
@@ -113,6 +426,516 @@ func main() {
 		if err := tx.Commit(); err != nil {
 			log.Fatalf("committing sql transaction: %v", err)
 		}
+	} else if *updateMode && *schedule {
+		// overwrite the given fields of a repeating entry, changing every
+		// occurrence
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		sc, err := ledger.GetSchedule(tx, *id)
+		if err != nil {
+			log.Fatalf("getting repeating entry: %v", err)
+		}
+		if *source != "" {
+			sc.Source = *source
+		}
+		if *destination != "" {
+			sc.Destination = *destination
+		}
+		if *entrydate != "" {
+			sc.StartDate, err = utils.ParseDate(*entrydate)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		if *amount != 0 {
+			sc.Amount = *amount
+		}
+		if *commodity != "" {
+			sc.Commodity = *commodity
+		}
+		if *payee != "" {
+			sc.Payee = *payee
+		}
+		if *memo != "" {
+			sc.Memo = *memo
+		}
+		if *tags != "" {
+			sc.Tags = utils.ParseTags(*tags)
+		}
+		if *repeat != "" {
+			sc.Frequency = *repeat
+		}
+		if *every != 0 {
+			sc.Interval = *every
+		}
+		if *shift != "" {
+			sc.WeekendShift = *shift
+		}
+		if *until != "" {
+			sc.EndDate, err = utils.ParseDate(*until)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		if *count != 0 {
+			sc.Count = *count
+		}
+		if err := ledger.UpdateSchedule(tx, sc); err != nil {
+			log.Fatalf("updating repeating entry: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+	} else if *updateMode {
+		// overwrite the given fields of an existing transaction
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		e, err := ledger.GetEntry(tx, *id)
+		if err != nil {
+			log.Fatalf("getting entry: %v", err)
+		}
+		if *source != "" {
+			e.Source = *source
+		}
+		if *destination != "" {
+			e.Destination = *destination
+		}
+		if *entrydate != "" {
+			e.EntryDate, err = utils.ParseDate(*entrydate)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		if *amount != 0 {
+			e.Amount = *amount
+		}
+		if *commodity != "" {
+			e.Commodity = *commodity
+		}
+		if *payee != "" {
+			e.Payee = *payee
+		}
+		if *memo != "" {
+			e.Memo = *memo
+		}
+		if *tags != "" {
+			e.Tags = utils.ParseTags(*tags)
+		}
+		if *status != "" {
+			e.Status = ledger.Status(*status)
+		}
+		if err := ledger.UpdateEntry(tx, e); err != nil {
+			log.Fatalf("updating entry: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+	} else if *deleteMode && *schedule {
+		// delete a repeating entry along with every occurrence
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if err := ledger.DeleteSchedule(tx, *id); err != nil {
+			log.Fatalf("deleting repeating entry: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+	} else if *deleteMode {
+		// delete a transaction from the db
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if err := ledger.DeleteEntry(tx, *id); err != nil {
+			log.Fatalf("deleting entry: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+	} else if *voidMode {
+		// reverse a transaction, keeping both on the record
+		var d time.Time
+		if *entrydate != "" {
+			if d, err = utils.ParseDate(*entrydate); err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if err := ledger.VoidEntry(tx, *id, d); err != nil {
+			log.Fatalf("voiding entry: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+	} else if *closePeriodMode {
+		// post closing entries and lock the period
+		d, err := utils.ParseDate(*entrydate)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		c, err := ledger.ClosePeriod(tx, d, *bucket)
+		if err != nil {
+			log.Fatalf("closing period: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		names := []string{}
+		for b := range c.OpeningBalances {
+			names = append(names, b)
+		}
+		sort.Strings(names)
+		for _, b := range names {
+			h := c.OpeningBalances[b]
+			commodities := []string{}
+			for commodity := range h {
+				commodities = append(commodities, commodity)
+			}
+			sort.Strings(commodities)
+			for _, commodity := range commodities {
+				log.Printf("%s: opening balance %s", b, usd.Format(h[commodity], commodity))
+			}
+		}
+	} else if *reopenPeriodMode {
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		c, err := ledger.ReopenPeriod(tx)
+		if err != nil {
+			log.Fatalf("reopening period: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		log.Printf("reopened the period through %s", c.ClosedThrough.Format("2006-01-02"))
+	} else if *registerMode {
+		// add a bucket to the registry
+		b := ledger.Bucket{
+			Name:        *bucket,
+			Type:        ledger.BucketType(*bucketType),
+			DisplayName: *displayName,
+		}
+		if b.OpenedAt, err = utils.ParseDate(*opened); err != nil {
+			log.Print(err)
+			return
+		}
+		if *closed != "" {
+			if b.ClosedAt, err = utils.ParseDate(*closed); err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if err := ledger.RegisterBucket(tx, b); err != nil {
+			log.Fatalf("registering bucket: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+	} else if *closeBucketMode {
+		// stop accepting entries into a bucket after a date
+		d, err := utils.ParseDate(*closed)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if err := ledger.CloseBucket(tx, *bucket, d); err != nil {
+			log.Fatalf("closing bucket: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+	} else if *stopMode {
+		// end a repeating entry, keeping its occurrences through -until
+		d, err := utils.ParseDate(*until)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if err := ledger.StopSchedule(tx, *id, d); err != nil {
+			log.Fatalf("stopping repeating entry: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+	} else if *schedulesMode {
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		schedules, err := ledger.GetSchedules(tx, utils.ParseTags(*tags)...)
+		if err != nil {
+			log.Fatalf("getting repeating entries: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		for _, sc := range schedules {
+			end := "forever"
+			if !sc.EndDate.IsZero() {
+				end = "until " + sc.EndDate.Format("2006-01-02")
+			}
+			if sc.Count != 0 {
+				end = fmt.Sprintf("%d times, %s", sc.Count, end)
+			}
+			if sc.WeekendShift != "" {
+				end += ", weekends move to " + sc.WeekendShift
+			}
+			log.Printf("%d: %s -> %s %d every %d %s from %s, %s",
+				sc.ID, sc.Source, sc.Destination, sc.Amount, sc.Interval, sc.Frequency,
+				sc.StartDate.Format("2006-01-02"), end)
+		}
+	} else if *assertMode {
+		// record a balance assertion
+		d, err := utils.ParseDate(*entrydate)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if _, err := ledger.InsertAssertion(tx, ledger.Assertion{Bucket: *bucket, Date: d, Amount: *amount, Commodity: *commodity}); err != nil {
+			log.Fatalf("inserting assertion: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+	} else if *checkMode {
+		// report every failed balance assertion
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		failures, err := ledger.CheckAssertions(tx)
+		if err != nil {
+			log.Fatalf("checking assertions: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		for _, f := range failures {
+			log.Printf("failed: %s", f)
+		}
+		if len(failures) > 0 {
+			log.Fatalf("%d balance assertions failed", len(failures))
+		}
+		log.Printf("all balance assertions passed")
+	} else if *historyMode {
+		// list the latest changes, newest first
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		changes, err := audit.GetChanges(tx, *limit)
+		if err != nil {
+			log.Fatalf("getting history: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		for _, c := range changes {
+			fmt.Println(describeChange(c))
+		}
+	} else if *undo > 0 {
+		// undo the latest change sets, all or none
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		undone, err := ledger.Undo(tx, *undo)
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("undoing changes: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		for _, c := range undone {
+			fmt.Println(describeChange(c))
+		}
+	} else if *migrateMode {
+		// apply pending migrations; a dry run or listing rolls back
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if *list {
+			status, err := migrations.GetStatus(tx)
+			if err != nil {
+				log.Fatalf("getting migration status: %v", err)
+			}
+			tx.Rollback()
+			for _, m := range status {
+				at := "pending"
+				if m.AppliedAt != "" {
+					at = "applied " + m.AppliedAt
+				}
+				fmt.Printf("%3d  %-45s %s\n", m.Version, m.Name, at)
+			}
+			return
+		}
+		applied, err := migrations.Migrate(tx)
+		if err != nil {
+			tx.Rollback()
+			log.Fatalf("migrating database: %v", err)
+		}
+		verb := "applied"
+		if *dryRun {
+			verb = "would apply"
+			tx.Rollback()
+		} else if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		for _, m := range applied {
+			log.Printf("%s migration %d: %s", verb, m.Version, m.Name)
+		}
+		if len(applied) == 0 {
+			log.Printf("schema is up to date at version %d", migrations.Latest())
+		}
+	} else if *zeroMode {
+		// find when a bucket zeroes out, looking a year ahead by default
+		td := time.Now().AddDate(1, 0, 0)
+		if *through != "" {
+			td, err = utils.ParseDate(*through)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		f, err := ledger.ForecastBucket(tx, *bucket, *threshold, time.Now(), td)
+		if err != nil {
+			log.Fatalf("forecasting bucket: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		if !f.Found {
+			log.Printf("%s stays at or above %d through %s", f.Bucket, f.Threshold, td.Format("2006-01-02"))
+			return
+		}
+		log.Printf("%s drops to %d on %s", f.Bucket, f.Balance, f.Date.Format("2006-01-02"))
+		for _, e := range f.Entries {
+			log.Printf("  %s -> %s %d %s", e.Source, e.Destination, e.Amount, e.Payee)
+		}
+	} else if *report != "" {
+		// print a statement for the year to date unless told otherwise
+		now := time.Now()
+		o := ledger.StatementOptions{
+			Report:    *report,
+			Start:     time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC),
+			End:       time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+			Cash:      utils.ParseTags(*cash),
+			Commodity: *commodity,
+		}
+		if *from != "" {
+			if o.Start, err = utils.ParseDate(*from); err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		if *through != "" {
+			if o.End, err = utils.ParseDate(*through); err != nil {
+				log.Print(err)
+				return
+			}
+		}
+		if o.Compare, err = ledger.ParseComparisons(*compare); err != nil {
+			log.Print(err)
+			return
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		statement, err := ledger.GenerateStatement(tx, o)
+		if err != nil {
+			log.Fatalf("generating statement: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		printStatement(os.Stdout, statement, *commodity)
+	} else if *reconcileMode {
+		// mark entries cleared or pending, then compare the cleared entries
+		// against the statement
+		d, err := utils.ParseDate(*through)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		cleared, err := parseIDs(*clear)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		pending, err := parseIDs(*unclear)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		tx, err := audit.Begin(db, who)
+		if err != nil {
+			log.Fatalf("beginning sql transaction: %v", err)
+		}
+		if err := ledger.SetStatus(tx, ledger.Cleared, cleared...); err != nil {
+			log.Fatalf("clearing entries: %v", err)
+		}
+		if err := ledger.SetStatus(tx, ledger.Pending, pending...); err != nil {
+			log.Fatalf("unclearing entries: %v", err)
+		}
+		var rec ledger.Reconciliation
+		if *finish {
+			rec, err = ledger.FinishReconciliation(tx, *bucket, d, *balance)
+		} else {
+			rec, err = ledger.Reconcile(tx, *bucket, d, *balance)
+		}
+		if err != nil {
+			log.Fatalf("reconciling: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatalf("committing sql transaction: %v", err)
+		}
+		for _, e := range rec.Uncleared {
+			log.Printf("%d %s: %s -> %s %d %s [%s]", e.ID, e.EntryDate.Format("2006-01-02"), e.Source, e.Destination, e.Amount, e.Payee, e.Status)
+		}
+		log.Printf("reconciled %d, cleared %d, statement %d, difference %d, in %s",
+			rec.ReconciledBalance, rec.ClearedBalance, rec.StatementBalance, rec.Difference, rec.Commodity)
 	} else if *summaryMode {
 		bigBang := time.Date(1996, 04, 11, 0, 0, 0, 0, time.Local)
 		// summarize all buckets through a given date
@@ -125,25 +948,114 @@ func main() {
 			}
 		}
 		// begin sql transaction
-		tx, err := db.Begin()
+		tx, err := audit.Begin(db, who)
 		if err != nil {
 			log.Fatalf("beginning sql transaction: %v", err)
 		}
 		// get list of bucket names
-		bucketList, err := ledger.GetBuckets(tx)
+		bucketList, err := ledger.GetBuckets(tx, utils.ParseTags(*tags)...)
 		if err != nil {
 			log.Fatalf("summarizing buckets: %v", err)
 		}
+		bucketList = ledger.BucketsAtDepth(bucketList, *depth)
 		// get ledger summary
-		ledgerMap, err := ledger.SummarizeBalance(tx, bucketList, bigBang, td)
+		ledgerMap, err := ledger.SummarizeBalanceIn(tx, *commodity, bucketList, bigBang, td, utils.ParseTags(*tags)...)
 		if err != nil {
 			log.Fatalf("summarizing buckets: %v", err)
 		}
+		// get registered bucket types to show normal balances
+		registry, err := ledger.GetRegistry(tx)
+		if err != nil {
+			log.Fatalf("getting bucket registry: %v", err)
+		}
 		if err := tx.Commit(); err != nil {
 			log.Fatalf("committing sql transaction: %v", err)
 		}
-		for b, v := range ledgerMap {
-			log.Printf("%s: %v", b, v)
+		ledgerMap = registry.Normalize(ledgerMap)
+		for _, b := range bucketList {
+			log.Printf("%s: %s", b, registry.DescribeIn(b, ledgerMap[b], *commodity))
+		}
+	}
+}
+
+// print a statement as a table, one column per period, with amounts in
+// commodity
+func printStatement(w io.Writer, s ledger.Statement, commodity string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(label string, amounts []int) {
+		cells := []string{label}
+		for _, v := range amounts {
+			cells = append(cells, usd.Format(v, commodity))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t")+"\t")
+	}
+	fmt.Fprintln(w, s.Title)
+	header := []string{""}
+	for _, c := range s.Columns {
+		header = append(header, c.Label)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+	for _, section := range s.Sections {
+		fmt.Fprintln(tw, section.Title+"\t")
+		for _, l := range section.Lines {
+			row(strings.Repeat("  ", l.Depth)+l.Label, l.Amounts)
+		}
+		row(section.Total.Label, section.Total.Amounts)
+	}
+	for _, l := range s.Totals {
+		row(l.Label, l.Amounts)
+	}
+	tw.Flush()
+}
+
+// print the entries a QIF import would insert, one per line
+func printBatch(w io.Writer, b qifreader.Batch) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, e := range b.Entries {
+		split := ""
+		if e.TransactionID != 0 {
+			split = fmt.Sprintf("split %d", e.TransactionID)
+		}
+		fmt.Fprintf(tw, "%s\t%s -> %s\t%s\t%s\t%s\t%s\t%s\t\n", utils.FormatDate(e.EntryDate), e.Source, e.Destination,
+			usd.Format(e.Amount, e.Commodity), e.Status, e.Payee, e.Memo, split)
+	}
+	for _, e := range b.Budget {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", utils.FormatDate(e.EntryDate), e.Category, e.Amount.String(), e.Description)
+	}
+	tw.Flush()
+}
+
+// describe a change on one line, e.g.
+// "12  2021-04-01 10:00  ana (cli)  update entries 3  amount: 100 -> 120"
+func describeChange(c audit.Change) string {
+	var values []string
+	for _, col := range audit.ChangedColumns(c) {
+		switch c.Action {
+		case audit.Insert:
+			values = append(values, fmt.Sprintf("%s: %v", col, c.After[col]))
+		case audit.Delete:
+			values = append(values, fmt.Sprintf("%s: %v", col, c.Before[col]))
+		default:
+			values = append(values, fmt.Sprintf("%s: %v -> %v", col, c.Before[col], c.After[col]))
+		}
+	}
+	action := string(c.Action)
+	if c.Undoes != 0 {
+		action = fmt.Sprintf("%s (undoes %d)", c.Action, c.Undoes)
+	}
+	return fmt.Sprintf("%d (set %d)  %s  %s (%s)  %s %s %d  %s", c.ID, c.ChangeSet, c.At.Local().Format("2006-01-02 15:04"),
+		c.Actor.Name, c.Actor.Source, action, c.Table, c.RowID, strings.Join(values, ", "))
+}
+
+// parse a comma-separated list of entry ids
+func parseIDs(s string) ([]int, error) {
+	var ids []int
+	for _, v := range utils.ParseTags(s) {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing id %q: %w", v, err)
 		}
+		ids = append(ids, id)
 	}
+	return ids, nil
 }
@@ -4,19 +4,29 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"ledger/pkg/audit"
+	"ledger/pkg/beancount"
 	"ledger/pkg/budget"
 	"ledger/pkg/csvreader"
+	"ledger/pkg/journal"
 	"ledger/pkg/ledger"
+	"ledger/pkg/migrations"
 	"ledger/pkg/myhttp"
 	"ledger/pkg/mytemplate"
+	"ledger/pkg/ofx"
+	"ledger/pkg/period"
+	"ledger/pkg/qifreader"
+	"ledger/pkg/tag"
 	"ledger/pkg/usd"
 	"ledger/pkg/utils"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"text/template"
 	"time"
 
@@ -55,6 +65,549 @@ func (s *server) insertLedgerEntryHandler(w http.ResponseWriter, r *http.Request
 	s.ledgerHandler(w, r)
 }
 
+func (s *server) insertTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	transaction, err := ledger.PrepareTransactionForInsert(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareTransactionForInsert() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if _, err := ledger.InsertTransaction(tx, transaction); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.InsertTransaction() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.ledgerHandler(w, r)
+}
+
+func (s *server) deleteTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := ledger.PrepareEntryID(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareEntryID() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.DeleteTransaction(tx, id); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.DeleteTransaction() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.ledgerHandler(w, r)
+}
+
+func (s *server) editLedgerEntryHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := mytemplate.EditLedgerEntry(tx, w, r); err != nil {
+			http.Error(w, fmt.Sprintf("Calling mytemplate.EditLedgerEntry (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *server) updateLedgerEntryHandler(w http.ResponseWriter, r *http.Request) {
+	entry, err := ledger.PrepareEntryForUpdate(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareEntryForUpdate() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.UpdateEntry(tx, entry); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.UpdateEntry() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.ledgerHandler(w, r)
+}
+
+func (s *server) deleteLedgerEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := ledger.PrepareEntryID(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareEntryID() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.DeleteEntry(tx, id); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.DeleteEntry() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.ledgerHandler(w, r)
+}
+
+func (s *server) voidLedgerEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id, voidedAt, err := ledger.PrepareVoid(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareVoid() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.VoidEntry(tx, id, voidedAt); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.VoidEntry() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.ledgerHandler(w, r)
+}
+
+func (s *server) insertScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, err := ledger.PrepareScheduleForInsert(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareScheduleForInsert() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if _, err := ledger.InsertSchedule(tx, schedule); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.InsertSchedule() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.ledgerHandler(w, r)
+}
+
+func (s *server) editScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := mytemplate.EditSchedule(tx, w, r); err != nil {
+			http.Error(w, fmt.Sprintf("Calling mytemplate.EditSchedule (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *server) updateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule, err := ledger.PrepareScheduleForUpdate(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareScheduleForUpdate() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.UpdateSchedule(tx, schedule); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.UpdateSchedule() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.ledgerHandler(w, r)
+}
+
+func (s *server) stopScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := ledger.PrepareEntryID(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareEntryID() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+	last, err := utils.ParseDate(r.PostForm.Get("ended_at"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling utils.ParseDate() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.StopSchedule(tx, id, last); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.StopSchedule() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.ledgerHandler(w, r)
+}
+
+func (s *server) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := ledger.PrepareEntryID(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareEntryID() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.DeleteSchedule(tx, id); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.DeleteSchedule() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.ledgerHandler(w, r)
+}
+
+func (s *server) reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := mytemplate.Reconcile(tx, w, r); err != nil {
+			http.Error(w, fmt.Sprintf("Calling mytemplate.Reconcile (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *server) markClearedHandler(w http.ResponseWriter, r *http.Request) {
+	cleared, pending, err := ledger.PrepareClearedEntries(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareClearedEntries() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.SetStatus(tx, ledger.Cleared, cleared...); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.SetStatus() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		if err := ledger.SetStatus(tx, ledger.Pending, pending...); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.SetStatus() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.reconcileHandler(w, r)
+}
+
+func (s *server) finishReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	bucket, date, balance, err := ledger.PrepareReconciliation(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareReconciliation() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if _, err := ledger.FinishReconciliation(tx, bucket, date, balance); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.FinishReconciliation() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.reconcileHandler(w, r)
+}
+
+func (s *server) insertAssertionHandler(w http.ResponseWriter, r *http.Request) {
+	assertion, err := ledger.PrepareAssertionForInsert(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareAssertionForInsert() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if _, err := ledger.InsertAssertion(tx, assertion); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.InsertAssertion() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.bucketsHandler(w, r)
+}
+
+func (s *server) deleteAssertionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := ledger.PrepareEntryID(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareEntryID() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.DeleteAssertion(tx, id); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.DeleteAssertion() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.bucketsHandler(w, r)
+}
+
+func (s *server) bucketsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := mytemplate.Buckets(tx, w, r); err != nil {
+			http.Error(w, fmt.Sprintf("Calling mytemplate.Buckets (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *server) registerBucketHandler(w http.ResponseWriter, r *http.Request) {
+	bucket, err := ledger.PrepareBucketForInsert(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareBucketForInsert() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.RegisterBucket(tx, bucket); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.RegisterBucket() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.bucketsHandler(w, r)
+}
+
+func (s *server) closeBucketHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	closedAt, err := utils.ParseDate(r.PostForm.Get("closed_at"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling utils.ParseDate() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.CloseBucket(tx, r.PostForm.Get("name"), closedAt); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.CloseBucket() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.bucketsHandler(w, r)
+}
+
+func (s *server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := mytemplate.History(tx, w, r); err != nil {
+			http.Error(w, fmt.Sprintf("Calling mytemplate.History (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *server) undoHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	n, err := strconv.Atoi(r.PostForm.Get("n"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not convert n field to int (%v)", err), http.StatusBadRequest)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if _, err := ledger.Undo(tx, n); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.Undo() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.historyHandler(w, r)
+}
+
+func (s *server) statementHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := mytemplate.Statement(tx, w, r); err != nil {
+			http.Error(w, fmt.Sprintf("Calling mytemplate.Statement (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *server) handleStatementJson(w http.ResponseWriter, r *http.Request) {
+	options, err := ledger.PrepareStatement(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareStatement() (%v)", err), http.StatusBadRequest)
+		return
+	}
+	var statement ledger.Statement
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		statement, err = ledger.GenerateStatement(tx, options)
+		return err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Generating statement (%v)", err), http.StatusInternalServerError)
+		return
+	}
+	//
+	output, err := json.Marshal(statement)
+	if err != nil {
+		log.Printf("marshaling statement: %v", err)
+	}
+	//
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	//
+	if _, err := io.Copy(w, bytes.NewBuffer(output)); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+func (s *server) netWorthHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := mytemplate.NetWorth(tx, w, r); err != nil {
+			http.Error(w, fmt.Sprintf("Calling mytemplate.NetWorth (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *server) handleNetWorthJson(w http.ResponseWriter, r *http.Request) {
+	start, end, interval, base, err := ledger.PrepareNetWorth(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareNetWorth() (%v)", err), http.StatusBadRequest)
+		return
+	}
+	var worth ledger.NetWorth
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		worth, err = ledger.SummarizeNetWorthIn(tx, base, start, end, time.Now(), interval)
+		return err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Summarizing net worth (%v)", err), http.StatusInternalServerError)
+		return
+	}
+	//
+	output, err := json.Marshal(worth)
+	if err != nil {
+		log.Printf("marshaling net worth: %v", err)
+	}
+	//
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	//
+	if _, err := io.Copy(w, bytes.NewBuffer(output)); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+func (s *server) pricesHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := mytemplate.Prices(tx, w, r); err != nil {
+			http.Error(w, fmt.Sprintf("Calling mytemplate.Prices (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *server) insertPriceHandler(w http.ResponseWriter, r *http.Request) {
+	price, err := ledger.PreparePriceForInsert(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PreparePriceForInsert() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if _, err := ledger.InsertPrice(tx, price); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.InsertPrice() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.pricesHandler(w, r)
+}
+
+func (s *server) deletePriceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := ledger.PrepareEntryID(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareEntryID() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := ledger.DeletePrice(tx, id); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.DeletePrice() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.pricesHandler(w, r)
+}
+
+// get the unrealized gain or loss on every holding in another commodity than
+// the base
+func (s *server) handleFXGainsJson(w http.ResponseWriter, r *http.Request) {
+	base, through, err := ledger.PrepareFXGains(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareFXGains() (%v)", err), http.StatusBadRequest)
+		return
+	}
+	var gains []ledger.FXGain
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		gains, err = ledger.UnrealizedGains(tx, base, through)
+		return err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Summarizing unrealized gains (%v)", err), http.StatusInternalServerError)
+		return
+	}
+	//
+	output, err := json.Marshal(gains)
+	if err != nil {
+		log.Printf("marshaling unrealized gains: %v", err)
+	}
+	//
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	//
+	if _, err := io.Copy(w, bytes.NewBuffer(output)); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+func (s *server) beancountHandler(w http.ResponseWriter, r *http.Request) {
+	dates, err := beancount.PrepareExport(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling beancount.PrepareExport() (%v)", err), http.StatusBadRequest)
+		return
+	}
+	var book beancount.Book
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		book, err = beancount.Export(tx)
+		return err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling beancount.Export() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+	var output bytes.Buffer
+	if err := beancount.Write(&output, book, dates); err != nil {
+		http.Error(w, fmt.Sprintf("Calling beancount.Write() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+	//
+	w.Header().Add("content-type", "text/plain; charset=utf-8")
+	w.Header().Add("Content-Disposition", `attachment; filename="ledger.beancount"`)
+	//
+	if _, err := io.Copy(w, &output); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+func (s *server) closePeriodHandler(w http.ResponseWriter, r *http.Request) {
+	through, equity, err := ledger.PrepareClosing(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling ledger.PrepareClosing() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if _, err := ledger.ClosePeriod(tx, through, equity); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.ClosePeriod() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.bucketsHandler(w, r)
+}
+
+func (s *server) reopenPeriodHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if _, err := ledger.ReopenPeriod(tx); err != nil {
+			http.Error(w, fmt.Sprintf("Calling ledger.ReopenPeriod() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	s.bucketsHandler(w, r)
+}
+
 func (s *server) balanceOverTimeHandler(w http.ResponseWriter, r *http.Request) {
 	utils.Tx(s.db, r, func(tx *sql.Tx) error {
 		if err := mytemplate.BalanceOverTime(tx, w, r); err != nil {
@@ -95,10 +648,123 @@ func (s *server) uploadCsvHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		// insert entries
 		utils.Tx(s.db, r, func(tx *sql.Tx) error {
-			for _, e := range entries {
-				err := ledger.InsertEntry(tx, e)
-				if err != nil {
-					http.Error(w, fmt.Sprintf("Calling ledger.InsertEntry (%v)", err), http.StatusInternalServerError)
+			if err := audit.SetActor(tx, audit.FromRequest(r, "csv")); err != nil {
+				return err
+			}
+			// the whole upload is undone together
+			return audit.ChangeSet(tx, func() error {
+				for _, e := range entries {
+					err := ledger.InsertEntry(tx, e)
+					if err != nil {
+						http.Error(w, fmt.Sprintf("Calling ledger.InsertEntry (%v)", err), http.StatusInternalServerError)
+						return err
+					}
+				}
+				return nil
+			})
+		})
+		fmt.Println("success")
+		// check the balance assertions against the new entries
+		var failures []ledger.AssertionFailure
+		utils.Tx(s.db, r, func(tx *sql.Tx) (err error) {
+			failures, err = ledger.CheckAssertions(tx)
+			return err
+		})
+		for _, f := range failures {
+			fmt.Printf("failed balance assertion: %s\n", f)
+		}
+		mytemplate.InsertAfterUpload(w, r, "", failures)
+		return
+	} else if len(r.PostForm["entry_type"]) > 0 && r.PostForm["entry_type"][0] == "journal" {
+		fmt.Println("uploading journal...")
+		j, err := journal.ReadFile(filepath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Calling journal.ReadFile() (%v)", err), http.StatusInternalServerError)
+			return
+		}
+		// insert entries, balance assertions and prices
+		utils.Tx(s.db, r, func(tx *sql.Tx) error {
+			if err := audit.SetActor(tx, audit.FromRequest(r, "journal")); err != nil {
+				return err
+			}
+			if err := journal.Import(tx, j); err != nil {
+				http.Error(w, fmt.Sprintf("Calling journal.Import (%v)", err), http.StatusInternalServerError)
+				return err
+			}
+			return nil
+		})
+		fmt.Println("success")
+		// check the balance assertions against the new entries
+		var failures []ledger.AssertionFailure
+		utils.Tx(s.db, r, func(tx *sql.Tx) (err error) {
+			failures, err = ledger.CheckAssertions(tx)
+			return err
+		})
+		mytemplate.InsertAfterUpload(w, r, "", failures)
+		return
+	} else if len(r.PostForm["entry_type"]) > 0 && (r.PostForm["entry_type"][0] == "ofx" || r.PostForm["entry_type"][0] == "ofx_budget") {
+		fmt.Println("uploading bank statement...")
+		statement, err := ofx.ReadFile(filepath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Calling ofx.ReadFile() (%v)", err), http.StatusInternalServerError)
+			return
+		}
+		// insert the transactions not imported before
+		var skipped int
+		utils.Tx(s.db, r, func(tx *sql.Tx) error {
+			if err := audit.SetActor(tx, audit.FromRequest(r, "ofx")); err != nil {
+				return err
+			}
+			if r.PostForm["entry_type"][0] == "ofx_budget" {
+				skipped, err = ofx.ImportBudget(tx, statement, r.PostForm.Get("category"))
+			} else {
+				skipped, err = ofx.Import(tx, statement, r.PostForm.Get("bucket"), r.PostForm.Get("counter"))
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Calling ofx.Import (%v)", err), http.StatusInternalServerError)
+				return err
+			}
+			return nil
+		})
+		fmt.Printf("success, skipped %d transactions imported before\n", skipped)
+		// check the balance assertions against the new entries
+		var failures []ledger.AssertionFailure
+		utils.Tx(s.db, r, func(tx *sql.Tx) (err error) {
+			failures, err = ledger.CheckAssertions(tx)
+			return err
+		})
+		notice := fmt.Sprintf("Imported %d transactions, skipped %d already imported.", len(statement.Transactions)-skipped, skipped)
+		mytemplate.InsertAfterUpload(w, r, notice, failures)
+		return
+	} else if len(r.PostForm["entry_type"]) > 0 && (r.PostForm["entry_type"][0] == "qif" || r.PostForm["entry_type"][0] == "qif_budget") {
+		fmt.Println("previewing QIF file...")
+		content, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Calling ioutil.ReadFile() (%v)", err), http.StatusInternalServerError)
+			return
+		}
+		batch, err := qifreader.PrepareBatch(r, string(content))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Calling qifreader.PrepareBatch() (%v)", err), http.StatusBadRequest)
+			return
+		}
+		// nothing is recorded until the preview is committed
+		if err := mytemplate.QIFPreview(w, r, string(content), batch); err != nil {
+			http.Error(w, fmt.Sprintf("Calling mytemplate.QIFPreview() (%v)", err), http.StatusInternalServerError)
+		}
+		return
+	} else if len(r.PostForm["entry_type"]) > 0 && r.PostForm["entry_type"][0] == "prices" {
+		fmt.Println("uploading prices...")
+		prices, err := csvreader.CsvToPrices(filepath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Calling csvreader.CsvToPrices() (%v)", err), http.StatusInternalServerError)
+			return
+		}
+		// insert prices
+		utils.Tx(s.db, r, func(tx *sql.Tx) error {
+			for _, p := range prices {
+				if _, err := ledger.InsertPrice(tx, p); err != nil {
+					http.Error(w, fmt.Sprintf("Calling ledger.InsertPrice (%v)", err), http.StatusInternalServerError)
 					return err
 				}
 			}
@@ -114,20 +780,52 @@ func (s *server) uploadCsvHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		// insert entries
 		utils.Tx(s.db, r, func(tx *sql.Tx) error {
-			for _, e := range entries {
-				err := budget.InsertEntry(tx, e)
-				if err != nil {
-					http.Error(w, fmt.Sprintf("Calling budget.InsertEntry (%v)", err), http.StatusInternalServerError)
-					return err
-				}
+			if err := audit.SetActor(tx, audit.FromRequest(r, "csv")); err != nil {
+				return err
 			}
-			return nil
+			// the whole upload is undone together
+			return audit.ChangeSet(tx, func() error {
+				for _, e := range entries {
+					err := budget.InsertEntry(tx, e)
+					if err != nil {
+						http.Error(w, fmt.Sprintf("Calling budget.InsertEntry (%v)", err), http.StatusInternalServerError)
+						return err
+					}
+				}
+				return nil
+			})
 		})
 		fmt.Println("success")
 	}
 	mytemplate.Insert(w, r)
 }
 
+func (s *server) importQIFHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	batch, err := qifreader.PrepareBatch(r, r.PostForm.Get("content"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling qifreader.PrepareBatch() (%v)", err), http.StatusBadRequest)
+		return
+	}
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if err := audit.SetActor(tx, audit.FromRequest(r, "qif")); err != nil {
+			return err
+		}
+		if err := qifreader.Commit(tx, batch); err != nil {
+			http.Error(w, fmt.Sprintf("Calling qifreader.Commit() (%v)", err), http.StatusInternalServerError)
+			return err
+		}
+		return nil
+	})
+	// check the balance assertions against the new entries
+	var failures []ledger.AssertionFailure
+	utils.Tx(s.db, r, func(tx *sql.Tx) (err error) {
+		failures, err = ledger.CheckAssertions(tx)
+		return err
+	})
+	mytemplate.InsertAfterUpload(w, r, "", failures)
+}
+
 // begin react handlers
 func (s *server) handleBudgetTrends(w http.ResponseWriter, r *http.Request) {
 	err := func() error {
@@ -210,7 +908,9 @@ func (s *server) handleBudgetTrendsJson(w http.ResponseWriter, r *http.Request)
 	var endDate time.Time
 	var allCategories []string
 	var filterCategories []string
-	var timeInterval int
+	var timeInterval period.Interval
+	var tags []string
+	var allTags []string
 	var spendSummary []map[string]usd.USD
 	//
 	utils.Tx(s.db, r, func(tx *sql.Tx) (err error) {
@@ -219,7 +919,9 @@ func (s *server) handleBudgetTrendsJson(w http.ResponseWriter, r *http.Request)
 		endDate, err = myhttp.SetEndDate(tx, q)
 		timeInterval, err = myhttp.SetTimeInterval(q)
 		filterCategories, allCategories, err = myhttp.SetBudgetCategories(tx, q)
-		spendSummary, err = budget.SummarizeSpendsOverTime(tx, filterCategories, startDate, endDate, timeInterval)
+		tags = myhttp.SetTags(q)
+		allTags, err = utils.GetTags(tx)
+		spendSummary, err = budget.SummarizeSpendsOverTime(tx, filterCategories, startDate, endDate, timeInterval, tags...)
 		return err
 	})
 	budgetOverTimeTable := budget.MakePlot(spendSummary, startDate, timeInterval)
@@ -228,14 +930,18 @@ func (s *server) handleBudgetTrendsJson(w http.ResponseWriter, r *http.Request)
 	group := struct {
 		StartDate     time.Time
 		EndDate       time.Time
-		TimeInterval  int
+		TimeInterval  period.Interval
 		AllCategories []string
+		Tags          []string
+		AllTags       []string
 		Table         budget.PlotData
 	}{
 		StartDate:     startDate,
 		EndDate:       endDate,
 		TimeInterval:  timeInterval,
 		AllCategories: allCategories,
+		Tags:          tags,
+		AllTags:       allTags,
 		Table:         *budgetOverTimeTable,
 	}
 	//
@@ -256,12 +962,107 @@ func (s *server) handleBudgetTrendsJson(w http.ResponseWriter, r *http.Request)
 
 }
 
+// get what a single tag cost across all buckets and categories
+func (s *server) handleTagReportJson(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := q.Get("tag")
+	if name == "" {
+		http.Error(w, "Missing tag query parameter", http.StatusBadRequest)
+		return
+	}
+	var startDate time.Time
+	var endDate time.Time
+	var err error
+	if v := q.Get("startDate"); v != "" && v != "undefined" {
+		if startDate, err = utils.ParseDate(v); err != nil {
+			http.Error(w, fmt.Sprintf("Parsing startDate (%v)", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("endDate"); v != "" && v != "undefined" {
+		if endDate, err = utils.ParseDate(v); err != nil {
+			http.Error(w, fmt.Sprintf("Parsing endDate (%v)", err), http.StatusBadRequest)
+			return
+		}
+	}
+	var report tag.Report
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		report, err = tag.Summarize(tx, name, startDate, endDate)
+		return err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Calling tag.Summarize() (%v)", err), http.StatusInternalServerError)
+		return
+	}
+	//
+	output, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("marshaling report: %v", err)
+	}
+	//
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	//
+	if _, err := io.Copy(w, bytes.NewBuffer(output)); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+// get the first day a bucket is projected to drop below a threshold, or every
+// asset bucket projected to run dry if no bucket is given
+func (s *server) handleForecastJson(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	through := time.Now().AddDate(1, 0, 0)
+	threshold := 0
+	var err error
+	if v := q.Get("through"); v != "" {
+		if through, err = utils.ParseDate(v); err != nil {
+			http.Error(w, fmt.Sprintf("Parsing through (%v)", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("threshold"); v != "" {
+		if threshold, err = strconv.Atoi(v); err != nil {
+			http.Error(w, fmt.Sprintf("Parsing threshold (%v)", err), http.StatusBadRequest)
+			return
+		}
+	}
+	var forecasts []ledger.Forecast
+	utils.Tx(s.db, r, func(tx *sql.Tx) error {
+		if bucket := q.Get("bucket"); bucket != "" {
+			var f ledger.Forecast
+			f, err = ledger.ForecastBucket(tx, bucket, threshold, time.Now(), through)
+			forecasts = []ledger.Forecast{f}
+		} else {
+			forecasts, err = ledger.ForecastAssets(tx, threshold, time.Now(), through)
+		}
+		return err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Forecasting balances (%v)", err), http.StatusInternalServerError)
+		return
+	}
+	//
+	output, err := json.Marshal(forecasts)
+	if err != nil {
+		log.Printf("marshaling forecasts: %v", err)
+	}
+	//
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	//
+	if _, err := io.Copy(w, bytes.NewBuffer(output)); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
 func (s *server) insertBudgetViaJson(w http.ResponseWriter, r *http.Request) {
 	type StringEntry struct {
 		EntryDate   string
 		Amount      string
 		Category    string
 		Description string
+		Tags        string
 	}
 	//
 	body, err := ioutil.ReadAll(r.Body)
@@ -287,6 +1088,7 @@ func (s *server) insertBudgetViaJson(w http.ResponseWriter, r *http.Request) {
 	entry.Amount = usd.USD(amountInt)
 	entry.Category = stringEntry.Category
 	entry.Description = stringEntry.Description
+	entry.Tags = utils.ParseTags(stringEntry.Tags)
 	//
 	utils.Tx(s.db, r, func(tx *sql.Tx) error {
 		if err := budget.InsertEntry(tx, entry); err != nil {
@@ -307,10 +1109,23 @@ func (s *server) insertBudgetViaJson(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	proxies := flag.String("trusted-proxies", "", "comma separated addresses of proxies trusted to name the user in X-Forwarded-User")
+	flag.Parse()
+	if *proxies != "" {
+		audit.TrustedProxies = strings.Split(*proxies, ",")
+	}
 	db, err := sql.Open("sqlite3", "./db.sqlite3")
 	if err != nil {
 		log.Fatalf("opening database: %v", err)
 	}
+	// bring the schema up to date
+	applied, err := migrations.Run(db)
+	if err != nil {
+		log.Fatalf("migrating database: %v", err)
+	}
+	for _, m := range applied {
+		log.Printf("applied migration %d: %s", m.Version, m.Name)
+	}
 	//
 	s := &server{db: db}
 	//
@@ -319,6 +1134,8 @@ func main() {
 	http.HandleFunc("/budget-trends", s.handleBudgetTrends)
 	http.HandleFunc("/budget-trends.json", s.handleBudgetTrendsJson)
 	http.HandleFunc("/insert.json", s.insertBudgetViaJson)
+	http.HandleFunc("/tag-report.json", s.handleTagReportJson)
+	http.HandleFunc("/forecast.json", s.handleForecastJson)
 
 	//
 	// http.HandleFunc("/budgetseries", s.handleBudgetOverTime)
@@ -326,10 +1143,43 @@ func main() {
 	http.HandleFunc("/ledger", s.ledgerHandler)
 	http.HandleFunc("/balance", s.balanceOverTimeHandler)
 	http.HandleFunc("/ledgerseries", s.ledgerOverTimeHandler)
+	http.HandleFunc("/statement", s.statementHandler)
+	http.HandleFunc("/statement.json", s.handleStatementJson)
+	http.HandleFunc("/networth", s.netWorthHandler)
+	http.HandleFunc("/networth.json", s.handleNetWorthJson)
+	http.HandleFunc("/prices", s.pricesHandler)
+	http.HandleFunc("/insert_price", s.insertPriceHandler)
+	http.HandleFunc("/delete_price", s.deletePriceHandler)
+	http.HandleFunc("/fx-gains.json", s.handleFXGainsJson)
+	http.HandleFunc("/beancount", s.beancountHandler)
+	http.HandleFunc("/buckets", s.bucketsHandler)
+	http.HandleFunc("/register_bucket", s.registerBucketHandler)
+	http.HandleFunc("/close_bucket", s.closeBucketHandler)
+	http.HandleFunc("/close_period", s.closePeriodHandler)
+	http.HandleFunc("/reopen_period", s.reopenPeriodHandler)
+	http.HandleFunc("/insert_assertion", s.insertAssertionHandler)
+	http.HandleFunc("/delete_assertion", s.deleteAssertionHandler)
+	http.HandleFunc("/reconcile", s.reconcileHandler)
+	http.HandleFunc("/mark_cleared", s.markClearedHandler)
+	http.HandleFunc("/finish_reconciliation", s.finishReconciliationHandler)
+	http.HandleFunc("/history", s.historyHandler)
+	http.HandleFunc("/undo", s.undoHandler)
 	//
 	http.HandleFunc("/insert", mytemplate.Insert)
 	http.HandleFunc("/upload_csv", s.uploadCsvHandler)
+	http.HandleFunc("/import_qif", s.importQIFHandler)
 	http.HandleFunc("/insert_ledger_entry", s.insertLedgerEntryHandler)
+	http.HandleFunc("/edit_ledger_entry", s.editLedgerEntryHandler)
+	http.HandleFunc("/update_ledger_entry", s.updateLedgerEntryHandler)
+	http.HandleFunc("/delete_ledger_entry", s.deleteLedgerEntryHandler)
+	http.HandleFunc("/void_ledger_entry", s.voidLedgerEntryHandler)
+	http.HandleFunc("/insert_transaction", s.insertTransactionHandler)
+	http.HandleFunc("/insert_schedule", s.insertScheduleHandler)
+	http.HandleFunc("/edit_schedule", s.editScheduleHandler)
+	http.HandleFunc("/update_schedule", s.updateScheduleHandler)
+	http.HandleFunc("/stop_schedule", s.stopScheduleHandler)
+	http.HandleFunc("/delete_schedule", s.deleteScheduleHandler)
+	http.HandleFunc("/delete_transaction", s.deleteTransactionHandler)
 	//
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	//
@@ -0,0 +1,543 @@
+package audit
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Action is what a change did to a row
+type Action string
+
+const (
+	Insert Action = "insert"
+	Update Action = "update"
+	Delete Action = "delete"
+)
+
+// Actor is who made a change, and through what: "cli", "web" or "csv"
+type Actor struct {
+	Name   string
+	Source string
+}
+
+// Row is a snapshot of one row, column by column, with its tags under "tags"
+// if the row can be tagged
+type Row map[string]interface{}
+
+// Change is one record of the audit log. Before is empty for inserts and
+// After is empty for deletes.
+type Change struct {
+	ID        int
+	At        time.Time
+	Actor     Actor
+	Table     string
+	RowID     int
+	Action    Action
+	Before    Row
+	After     Row
+	Undoes    int // change this one undid, or 0
+	ChangeSet int // first change of the operation this one was part of
+}
+
+// tables whose changes are recorded, and the tags column linking to each,
+// if its rows can be tagged
+var tagLinks = map[string]string{
+	"entries":        "entry_id",
+	"budget_entries": "budget_entry_id",
+	"transactions":   "",
+}
+
+// columns of audited rows that point at a row of another table, which must
+// still exist for the row to be put back, e.g. the closing a closing entry
+// belongs to
+var references = map[string]map[string]string{
+	"entries": {"closing_id": "closings", "transaction_id": "transactions"},
+}
+
+// begin a transaction whose changes are recorded as made by a
+func Begin(db *sql.DB, a Actor) (*sql.Tx, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Begin() - %w", err)
+	}
+	if err := SetActor(tx, a); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Begin() - %w", err)
+	}
+	return tx, nil
+}
+
+// record the rest of the changes in tx as made by a. The actor lives in a
+// temporary table, so it is only seen by tx's connection.
+func SetActor(tx *sql.Tx, a Actor) error {
+	q := `CREATE TEMP TABLE IF NOT EXISTS audit_actor (name TEXT, source TEXT);
+		DELETE FROM temp.audit_actor;`
+	if _, err := tx.Exec(q); err != nil {
+		return fmt.Errorf("SetActor() - clearing actor: %w", err)
+	}
+	q = `INSERT INTO temp.audit_actor (name, source) VALUES ($1, $2);`
+	if _, err := tx.Exec(q, a.Name, a.Source); err != nil {
+		return fmt.Errorf("SetActor() - setting actor: %w", err)
+	}
+	return nil
+}
+
+// get the actor set on tx's connection, if any
+func actor(tx *sql.Tx) Actor {
+	a := Actor{Source: "unknown"}
+	tx.QueryRow(`SELECT name, source FROM temp.audit_actor;`).Scan(&a.Name, &a.Source)
+	return a
+}
+
+// run work as one change set, so that everything it records is undone
+// together. Change sets nest: work run inside another change set joins it, so
+// an operation built from others stays whole. Changes recorded outside any
+// change set each make up their own.
+func ChangeSet(tx *sql.Tx, work func() error) error {
+	q := `CREATE TEMP TABLE IF NOT EXISTS audit_change_set (id INTEGER NOT NULL);`
+	if _, err := tx.Exec(q); err != nil {
+		return fmt.Errorf("ChangeSet() - creating change set: %w", err)
+	}
+	if _, open := changeSet(tx); open {
+		return work()
+	}
+	// the id is filled in by the first change recorded
+	if _, err := tx.Exec(`INSERT INTO temp.audit_change_set (id) VALUES (0);`); err != nil {
+		return fmt.Errorf("ChangeSet() - opening change set: %w", err)
+	}
+	workErr := work()
+	if _, err := tx.Exec(`DELETE FROM temp.audit_change_set;`); err != nil && workErr == nil {
+		return fmt.Errorf("ChangeSet() - closing change set: %w", err)
+	}
+	return workErr
+}
+
+// get the id of the change set open on tx's connection, which is 0 until
+// its first change is recorded, and whether one is open at all
+func changeSet(tx *sql.Tx) (int, bool) {
+	var id int
+	err := tx.QueryRow(`SELECT id FROM temp.audit_change_set;`).Scan(&id)
+	return id, err == nil
+}
+
+// TrustedProxies lists the addresses of the proxies whose X-Forwarded-User
+// header names the user behind a request. It is empty unless the server is
+// configured to sit behind one, since any client can send the header.
+var TrustedProxies []string
+
+// get the actor behind a web request: the basic auth user or a user named by
+// a trusted proxy in X-Forwarded-User, falling back to the remote address
+func FromRequest(r *http.Request, source string) Actor {
+	if name, _, ok := r.BasicAuth(); ok {
+		return Actor{Name: name, Source: source}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if name := r.Header.Get("X-Forwarded-User"); name != "" {
+		for _, proxy := range TrustedProxies {
+			if proxy == host {
+				return Actor{Name: name, Source: source}
+			}
+		}
+	}
+	return Actor{Name: host, Source: source}
+}
+
+// get a row as it is now, or nil if it does not exist
+func Snapshot(tx *sql.Tx, table string, id int) (Row, error) {
+	link, ok := tagLinks[table]
+	if !ok {
+		return nil, fmt.Errorf("Snapshot() - %s is not audited", table)
+	}
+	rows, err := tx.Query(`SELECT * FROM `+table+` WHERE id = $1;`, id)
+	if err != nil {
+		return nil, fmt.Errorf("Snapshot() - querying %s: %w", table, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("Snapshot() - reading columns: %w", err)
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, fmt.Errorf("Snapshot() - scanning %s: %w", table, err)
+	}
+	rows.Close()
+	row := Row{}
+	for i, c := range columns {
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		row[c] = values[i]
+	}
+	if link == "" {
+		return row, nil
+	}
+	tags := []string{}
+	tagRows, err := tx.Query(`SELECT name FROM tags WHERE `+link+` = $1 ORDER BY name;`, id)
+	if err != nil {
+		return nil, fmt.Errorf("Snapshot() - querying tags: %w", err)
+	}
+	defer tagRows.Close()
+	for tagRows.Next() {
+		var t string
+		if err := tagRows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("Snapshot() - scanning tag: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	row["tags"] = tags
+	return row, tagRows.Err()
+}
+
+// record that a row was just inserted
+func Inserted(tx *sql.Tx, table string, id int) error {
+	after, err := Snapshot(tx, table, id)
+	if err != nil {
+		return fmt.Errorf("Inserted() - %w", err)
+	}
+	_, err = record(tx, Change{Table: table, RowID: id, Action: Insert, After: after})
+	return err
+}
+
+// record that a row was just updated from before
+func Updated(tx *sql.Tx, table string, id int, before Row) error {
+	after, err := Snapshot(tx, table, id)
+	if err != nil {
+		return fmt.Errorf("Updated() - %w", err)
+	}
+	_, err = record(tx, Change{Table: table, RowID: id, Action: Update, Before: before, After: after})
+	return err
+}
+
+// record that a row holding before was just deleted
+func Deleted(tx *sql.Tx, table string, id int, before Row) error {
+	_, err := record(tx, Change{Table: table, RowID: id, Action: Delete, Before: before})
+	return err
+}
+
+// add c to the log, and get it back as stored
+func record(tx *sql.Tx, c Change) (Change, error) {
+	before, err := encode(c.Before)
+	if err != nil {
+		return Change{}, fmt.Errorf("record() - %w", err)
+	}
+	after, err := encode(c.After)
+	if err != nil {
+		return Change{}, fmt.Errorf("record() - %w", err)
+	}
+	c.Actor = actor(tx)
+	c.At = time.Now().UTC().Truncate(time.Second)
+	set, open := changeSet(tx)
+	// the first change of a change set is stored without one, and its id
+	// stands for the set
+	q := `INSERT INTO audit_log
+		(changed_at, actor, source, table_name, row_id, action, before, after, undoes, change_set)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), NULLIF($10, 0));`
+	res, err := tx.Exec(q, c.At.Format(time.RFC3339), c.Actor.Name, c.Actor.Source, c.Table, c.RowID, c.Action, before, after, c.Undoes, set)
+	if err != nil {
+		return Change{}, fmt.Errorf("record() - inserting change: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Change{}, fmt.Errorf("record() - getting change id: %w", err)
+	}
+	c.ID = int(id)
+	c.ChangeSet = set
+	if set == 0 {
+		c.ChangeSet = c.ID
+	}
+	if open && set == 0 {
+		if _, err := tx.Exec(`UPDATE temp.audit_change_set SET id = $1;`, c.ID); err != nil {
+			return Change{}, fmt.Errorf("record() - starting change set: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// encode a row as JSON, with columns in order; a nil row is NULL
+func encode(r Row) (interface{}, error) {
+	if r == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("encoding row: %w", err)
+	}
+	return string(b), nil
+}
+
+func decode(s sql.NullString) (Row, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	d := json.NewDecoder(strings.NewReader(s.String))
+	d.UseNumber()
+	r := Row{}
+	if err := d.Decode(&r); err != nil {
+		return nil, fmt.Errorf("decoding row: %w", err)
+	}
+	return r, nil
+}
+
+var changeColumns = `id, changed_at, actor, source, table_name, row_id, action, before, after, COALESCE(undoes, 0),
+	COALESCE(change_set, id)`
+
+func scanChange(rows *sql.Rows) (Change, error) {
+	c := Change{}
+	var at string
+	var before, after sql.NullString
+	if err := rows.Scan(&c.ID, &at, &c.Actor.Name, &c.Actor.Source, &c.Table, &c.RowID, &c.Action, &before, &after, &c.Undoes, &c.ChangeSet); err != nil {
+		return Change{}, fmt.Errorf("scanning change: %w", err)
+	}
+	var err error
+	if c.At, err = time.Parse(time.RFC3339, at); err != nil {
+		return Change{}, fmt.Errorf("change %d has unparseable time %q", c.ID, at)
+	}
+	if c.Before, err = decode(before); err != nil {
+		return Change{}, fmt.Errorf("change %d: %w", c.ID, err)
+	}
+	if c.After, err = decode(after); err != nil {
+		return Change{}, fmt.Errorf("change %d: %w", c.ID, err)
+	}
+	return c, nil
+}
+
+func queryChanges(tx *sql.Tx, q string, args ...interface{}) ([]Change, error) {
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying changes: %w", err)
+	}
+	defer rows.Close()
+	changes := []Change{}
+	for rows.Next() {
+		c, err := scanChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// get the latest limit changes, newest first
+func GetChanges(tx *sql.Tx, limit int) ([]Change, error) {
+	q := `SELECT ` + changeColumns + ` FROM audit_log ORDER BY id DESC LIMIT $1;`
+	changes, err := queryChanges(tx, q, limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetChanges() - %w", err)
+	}
+	return changes, nil
+}
+
+// get the changes of the latest n change sets that are neither undos nor
+// already undone, newest first; these are what Undo(tx, n) would undo
+func Undoable(tx *sql.Tx, n int) ([]Change, error) {
+	pending := `undoes IS NULL
+		AND id NOT IN (SELECT undoes FROM audit_log WHERE undoes IS NOT NULL)`
+	q := `SELECT ` + changeColumns + ` FROM audit_log
+		WHERE ` + pending + `
+		AND COALESCE(change_set, id) IN (
+			SELECT DISTINCT COALESCE(change_set, id) AS s FROM audit_log
+			WHERE ` + pending + `
+			ORDER BY s DESC LIMIT $1)
+		ORDER BY id DESC;`
+	changes, err := queryChanges(tx, q, n)
+	if err != nil {
+		return nil, fmt.Errorf("Undoable() - %w", err)
+	}
+	return changes, nil
+}
+
+// undo the latest n change sets that are neither undos nor already undone,
+// newest first, and get the changes made to undo them. Each change set is
+// undone whole, and its undo recorded as a change set of its own. It fails if
+// a row was changed outside the log since; ledger.Undo also holds the result
+// to the ledger's rules.
+func Undo(tx *sql.Tx, n int) ([]Change, error) {
+	changes, err := Undoable(tx, n)
+	if err != nil {
+		return nil, fmt.Errorf("Undo() - %w", err)
+	}
+	var undone []Change
+	for len(changes) > 0 {
+		set := changes[0].ChangeSet
+		err := ChangeSet(tx, func() error {
+			for len(changes) > 0 && changes[0].ChangeSet == set {
+				u, err := undo(tx, changes[0])
+				if err != nil {
+					return fmt.Errorf("undoing change %d: %w", changes[0].ID, err)
+				}
+				undone = append(undone, u)
+				changes = changes[1:]
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Undo() - %w", err)
+		}
+	}
+	return undone, nil
+}
+
+func undo(tx *sql.Tx, c Change) (Change, error) {
+	current, err := Snapshot(tx, c.Table, c.RowID)
+	if err != nil {
+		return Change{}, err
+	}
+	if !same(current, c.After) {
+		return Change{}, fmt.Errorf("%s row %d has changed since", c.Table, c.RowID)
+	}
+	u := Change{Table: c.Table, RowID: c.RowID, Before: current, After: c.Before, Undoes: c.ID}
+	switch c.Action {
+	case Insert:
+		u.Action = Delete
+		err = remove(tx, c.Table, c.RowID)
+	case Update:
+		u.Action = Update
+		err = restore(tx, c.Table, c.Before, true)
+	case Delete:
+		u.Action = Insert
+		err = restore(tx, c.Table, c.Before, false)
+	default:
+		err = fmt.Errorf("unknown action %q", c.Action)
+	}
+	if err != nil {
+		return Change{}, err
+	}
+	return record(tx, u)
+}
+
+// compare rows by their JSON, which evens out the types of numbers and tags
+func same(a, b Row) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(x, y)
+}
+
+// get the columns a change set, changed or cleared, in order
+func ChangedColumns(c Change) []string {
+	seen := map[string]bool{}
+	var columns []string
+	for _, r := range []Row{c.Before, c.After} {
+		for col := range r {
+			if seen[col] {
+				continue
+			}
+			seen[col] = true
+			if !same(Row{"v": c.Before[col]}, Row{"v": c.After[col]}) {
+				columns = append(columns, col)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func remove(tx *sql.Tx, table string, id int) error {
+	if link := tagLinks[table]; link != "" {
+		if _, err := tx.Exec(`DELETE FROM tags WHERE `+link+` = $1;`, id); err != nil {
+			return fmt.Errorf("deleting tags: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM `+table+` WHERE id = $1;`, id); err != nil {
+		return fmt.Errorf("deleting row: %w", err)
+	}
+	return nil
+}
+
+// put a row back as it was, tags and all, either over the row as it is now
+// or, if it was deleted, as a new row under its old id
+func restore(tx *sql.Tx, table string, r Row, update bool) error {
+	for c, target := range references[table] {
+		id := value(r[c])
+		if id == nil {
+			continue
+		}
+		var count int
+		if err := tx.QueryRow(`SELECT count(id) FROM `+target+` WHERE id = $1;`, id).Scan(&count); err != nil {
+			return fmt.Errorf("checking %s: %w", c, err)
+		}
+		if count == 0 {
+			return fmt.Errorf("%s row %v belongs to %s row %v, which no longer exists", table, r["id"], target, id)
+		}
+	}
+	var columns, placeholders, assignments []string
+	var values []interface{}
+	for c := range r {
+		if c != "tags" {
+			columns = append(columns, c)
+		}
+	}
+	sort.Strings(columns)
+	for i, c := range columns {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", c, i+1))
+		values = append(values, value(r[c]))
+	}
+	id := value(r["id"])
+	q := `INSERT INTO ` + table + ` (` + strings.Join(columns, ", ") + `)
+		VALUES (` + strings.Join(placeholders, ", ") + `);`
+	if update {
+		q = `UPDATE ` + table + ` SET ` + strings.Join(assignments, ", ") + `
+			WHERE id = $` + strconv.Itoa(len(columns)+1) + `;`
+		values = append(values, id)
+	}
+	if _, err := tx.Exec(q, values...); err != nil {
+		return fmt.Errorf("restoring row: %w", err)
+	}
+	link := tagLinks[table]
+	if link == "" {
+		return nil
+	}
+	if _, err := tx.Exec(`DELETE FROM tags WHERE `+link+` = $1;`, id); err != nil {
+		return fmt.Errorf("clearing tags: %w", err)
+	}
+	tags, _ := r["tags"].([]interface{})
+	for _, t := range tags {
+		if _, err := tx.Exec(`INSERT INTO tags (name, `+link+`) VALUES ($1, $2);`, t, id); err != nil {
+			return fmt.Errorf("restoring tags: %w", err)
+		}
+	}
+	return nil
+}
+
+// turn a decoded JSON number back into an integer where it is one
+func value(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	return n.String()
+}
@@ -0,0 +1,217 @@
+package audit_test
+
+import (
+	"database/sql"
+	"ledger/pkg/audit"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"net/http/httptest"
+	"testing"
+)
+
+// run work in a transaction recorded as made by ana from the cli
+func asAna(t *testing.T, db *sql.DB, work func(tx *sql.Tx) error) {
+	t.Helper()
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		if err := audit.SetActor(tx, audit.Actor{Name: "ana", Source: "cli"}); err != nil {
+			return err
+		}
+		return work(tx)
+	})
+}
+
+func changes(t *testing.T, db *sql.DB) []audit.Change {
+	t.Helper()
+	var got []audit.Change
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		got, err = audit.GetChanges(tx, 100)
+		return err
+	})
+	return got
+}
+
+func entry(t *testing.T, db *sql.DB, id int) (ledger.Entry, bool) {
+	t.Helper()
+	var got ledger.Entry
+	var err error
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		got, err = ledger.GetEntry(tx, id)
+		return nil
+	})
+	return got, err == nil
+}
+
+func TestAudit(t *testing.T) {
+	db := testutils.Db(t)
+	e := ledger.Entry{Source: "income", Destination: "checking", EntryDate: testutils.JanOne, Amount: 100, Tags: []string{"pay"}}
+	asAna(t, db, func(tx *sql.Tx) error {
+		return ledger.InsertEntry(tx, e)
+	})
+	inserted, _ := entry(t, db, 1)
+
+	t.Run("inserts are recorded with the actor and the new row", func(t *testing.T) {
+		got := changes(t, db)
+		testutils.AssertEqual(t, 1, len(got))
+		testutils.AssertEqual(t, audit.Actor{Name: "ana", Source: "cli"}, got[0].Actor)
+		testutils.AssertEqual(t, audit.Insert, got[0].Action)
+		testutils.AssertEqual(t, "entries", got[0].Table)
+		testutils.AssertEqual(t, 1, got[0].RowID)
+		testutils.AssertEqual(t, true, got[0].Before == nil)
+		testutils.AssertEqual(t, "2021-01-01", got[0].After["happened_at"])
+	})
+	t.Run("updates and deletes are recorded with before and after", func(t *testing.T) {
+		updated := inserted
+		updated.Amount = 120
+		asAna(t, db, func(tx *sql.Tx) error {
+			return ledger.UpdateEntry(tx, updated)
+		})
+		asAna(t, db, func(tx *sql.Tx) error {
+			return ledger.DeleteEntry(tx, 1)
+		})
+		got := changes(t, db)
+		testutils.AssertEqual(t, 3, len(got))
+		testutils.AssertEqual(t, audit.Update, got[1].Action)
+		testutils.AssertEqual(t, []string{"amount"}, audit.ChangedColumns(got[1]))
+		testutils.AssertEqual(t, audit.Delete, got[0].Action)
+		testutils.AssertEqual(t, true, got[0].After == nil)
+	})
+	t.Run("undoing puts rows back as they were, tags and all", func(t *testing.T) {
+		asAna(t, db, func(tx *sql.Tx) error {
+			_, err := audit.Undo(tx, 2)
+			return err
+		})
+		got, ok := entry(t, db, 1)
+		testutils.AssertEqual(t, true, ok)
+		testutils.AssertEqual(t, inserted, got)
+		all := changes(t, db)
+		testutils.AssertEqual(t, 5, len(all))
+		testutils.AssertEqual(t, 2, all[0].Undoes)
+		// the remaining change is the insert, so undoing it removes the entry
+		asAna(t, db, func(tx *sql.Tx) error {
+			_, err := audit.Undo(tx, 5)
+			return err
+		})
+		_, ok = entry(t, db, 1)
+		testutils.AssertEqual(t, false, ok)
+	})
+	t.Run("undoing a row changed since is refused", func(t *testing.T) {
+		asAna(t, db, func(tx *sql.Tx) error {
+			return ledger.InsertEntry(tx, e)
+		})
+		id := changes(t, db)[0].RowID
+		if _, err := db.Exec(`UPDATE entries SET amount = 1 WHERE id = $1;`, id); err != nil {
+			t.Fatalf("updating behind the log's back: %v", err)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("starting tx: %v", err)
+		}
+		defer tx.Rollback()
+		if _, err := audit.Undo(tx, 1); err == nil {
+			t.Fatalf("want error undoing a changed row, got nil")
+		}
+	})
+	t.Run("the log cannot be changed", func(t *testing.T) {
+		if _, err := db.Exec(`UPDATE audit_log SET actor = 'mallory';`); err == nil {
+			t.Errorf("want error updating the log, got nil")
+		}
+		if _, err := db.Exec(`DELETE FROM audit_log;`); err == nil {
+			t.Errorf("want error deleting from the log, got nil")
+		}
+	})
+}
+
+func TestUndoTransaction(t *testing.T) {
+	db := testutils.Db(t)
+	split := ledger.Transaction{EntryDate: testutils.JanOne, Payee: "acme", Postings: []ledger.Posting{
+		{Bucket: "income", Amount: -100},
+		{Bucket: "taxes", Amount: 30},
+		{Bucket: "checking", Amount: 70},
+	}}
+	var id int
+	asAna(t, db, func(tx *sql.Tx) (err error) {
+		id, err = ledger.InsertTransaction(tx, split)
+		return err
+	})
+	asAna(t, db, func(tx *sql.Tx) error {
+		return ledger.DeleteTransaction(tx, id)
+	})
+	// the delete is one change set, so the transaction and both of its
+	// entries come back together
+	deleted := changes(t, db)[:3]
+	for _, c := range deleted {
+		testutils.AssertEqual(t, deleted[2].ID, c.ChangeSet)
+	}
+	asAna(t, db, func(tx *sql.Tx) error {
+		_, err := audit.Undo(tx, 1)
+		return err
+	})
+	var got ledger.Transaction
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		got, err = ledger.GetTransaction(tx, id)
+		return err
+	})
+	testutils.AssertEqual(t, split.Postings, got.Postings)
+	asAna(t, db, func(tx *sql.Tx) error {
+		return ledger.DeleteTransaction(tx, id)
+	})
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		if _, err := ledger.GetTransaction(tx, id); err == nil {
+			t.Errorf("want the transaction deleted again")
+		}
+		return nil
+	})
+}
+
+func TestChangeSet(t *testing.T) {
+	db := testutils.Db(t)
+	e := ledger.Entry{Source: "income", Destination: "checking", EntryDate: testutils.JanOne, Amount: 100}
+	asAna(t, db, func(tx *sql.Tx) error {
+		return ledger.InsertEntry(tx, e)
+	})
+	// a change set opened inside another joins it
+	asAna(t, db, func(tx *sql.Tx) error {
+		return audit.ChangeSet(tx, func() error {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+			return audit.ChangeSet(tx, func() error {
+				return ledger.InsertEntry(tx, e)
+			})
+		})
+	})
+	got := changes(t, db)
+	testutils.AssertEqual(t, []int{2, 2, 1}, []int{got[0].ChangeSet, got[1].ChangeSet, got[2].ChangeSet})
+	var undoable []audit.Change
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		undoable, err = audit.Undoable(tx, 1)
+		return err
+	})
+	testutils.AssertEqual(t, 2, len(undoable))
+	// undoing the set removes both of its entries, and is a set of its own
+	asAna(t, db, func(tx *sql.Tx) error {
+		_, err := audit.Undo(tx, 1)
+		return err
+	})
+	_, ok := entry(t, db, 1)
+	testutils.AssertEqual(t, true, ok)
+	for _, id := range []int{2, 3} {
+		_, ok := entry(t, db, id)
+		testutils.AssertEqual(t, false, ok)
+	}
+	got = changes(t, db)
+	testutils.AssertEqual(t, []int{4, 4}, []int{got[0].ChangeSet, got[1].ChangeSet})
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/insert", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-User", "ana")
+	// without a trusted proxy in front, anyone could send the header
+	testutils.AssertEqual(t, audit.Actor{Name: "10.0.0.2", Source: "web"}, audit.FromRequest(r, "web"))
+	audit.TrustedProxies = []string{"10.0.0.2"}
+	defer func() { audit.TrustedProxies = nil }()
+	testutils.AssertEqual(t, audit.Actor{Name: "ana", Source: "web"}, audit.FromRequest(r, "web"))
+	r.SetBasicAuth("bo", "secret")
+	testutils.AssertEqual(t, audit.Actor{Name: "bo", Source: "web"}, audit.FromRequest(r, "web"))
+}
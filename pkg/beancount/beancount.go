@@ -0,0 +1,85 @@
+// Package beancount writes the ledger and the budget as a Beancount file, so
+// that Fava and bean-query can read the same data this app keeps.
+//
+// Every bucket is opened as an account under the Beancount root of its type:
+// assets:checking becomes Assets:Checking and an unregistered groceries
+// bucket Assets:Groceries. A bucket named after its root alone, e.g. income,
+// becomes Income:Income, since Beancount never posts to a bare root. Pending
+// entries are flagged ! and cleared or reconciled entries *. Each budget
+// entry becomes a transaction from Equity:Budget into an Expenses:Budget
+// account for its category, narrated by its description. Occurrences of
+// repeating entries are left out, as are ids, voids and period closes.
+package beancount
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"ledger/pkg/budget"
+	"ledger/pkg/ledger"
+	"ledger/pkg/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Book is everything written to a Beancount file
+type Book struct {
+	Registry ledger.Registry
+	Buckets  []string
+	Entries  []ledger.Entry
+	Budget   []budget.Entry
+	Prices   []ledger.Price
+}
+
+// get every bucket, stored entry, budget entry and price
+func Export(tx *sql.Tx) (Book, error) {
+	registry, err := ledger.GetRegistry(tx)
+	if err != nil {
+		return Book{}, fmt.Errorf("Export() - %w", err)
+	}
+	buckets, err := ledger.GetBuckets(tx)
+	if err != nil {
+		return Book{}, fmt.Errorf("Export() - getting buckets: %w", err)
+	}
+	entries, err := ledger.GetEntries(tx)
+	if err != nil {
+		return Book{}, fmt.Errorf("Export() - %w", err)
+	}
+	prices, err := ledger.GetPrices(tx)
+	if err != nil {
+		return Book{}, fmt.Errorf("Export() - %w", err)
+	}
+	b := Book{Registry: registry, Buckets: buckets, Entries: entries, Prices: prices}
+	first, err := budget.GetEarliestBudgetDate(tx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return b, nil
+	} else if err != nil {
+		return Book{}, fmt.Errorf("Export() - %w", err)
+	}
+	last, err := budget.GetLatestBudgetDate(tx)
+	if err != nil {
+		return Book{}, fmt.Errorf("Export() - %w", err)
+	}
+	if b.Budget, err = budget.GetBudgetEntries(tx, first, last); err != nil {
+		return Book{}, fmt.Errorf("Export() - %w", err)
+	}
+	return b, nil
+}
+
+// parse the comma-separated dates to write balances for from a form
+func PrepareExport(r *http.Request) ([]time.Time, error) {
+	r.ParseForm()
+	var dates []time.Time
+	for _, s := range strings.Split(r.Form.Get("balance_dates"), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		d, err := utils.ParseDate(s)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse balance date (%v)", err)
+		}
+		dates = append(dates, d)
+	}
+	return dates, nil
+}
@@ -0,0 +1,139 @@
+package beancount_test
+
+import (
+	"bytes"
+	"database/sql"
+	"ledger/pkg/beancount"
+	"ledger/pkg/budget"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	db := testutils.Db(t)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		for _, b := range []ledger.Bucket{
+			{Name: "assets", Type: ledger.Asset, OpenedAt: testutils.Date(1, 1)},
+			{Name: "credit card", Type: ledger.Liability, OpenedAt: testutils.Date(1, 1), ClosedAt: testutils.Date(4, 30)},
+			{Name: "equity", Type: ledger.Equity, OpenedAt: testutils.Date(1, 1)},
+			{Name: "expenses", Type: ledger.Expense, OpenedAt: testutils.Date(1, 1)},
+			{Name: "income", Type: ledger.Income, OpenedAt: testutils.Date(1, 1)},
+		} {
+			if err := ledger.RegisterBucket(tx, b); err != nil {
+				return err
+			}
+		}
+		if _, err := ledger.InsertPrice(tx, ledger.Price{Commodity: "EUR", Quote: "USD", Date: testutils.Date(4, 1), Rate: 1.08}); err != nil {
+			return err
+		}
+		for _, e := range []ledger.Entry{
+			{Source: "credit card", Destination: "expenses:food", EntryDate: testutils.Date(4, 1), Amount: 1234,
+				Payee: "Corner shop", Memo: `a "weekly" shop`, Tags: []string{"food", "trip:paris"}, Status: ledger.Cleared},
+			{Source: "equity", Destination: "assets:euro", EntryDate: testutils.Date(4, 3), Amount: 10000, Commodity: "EUR"},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		if _, err := ledger.InsertTransaction(tx, ledger.Transaction{EntryDate: testutils.Date(4, 2), Payee: "Paycheck", Postings: []ledger.Posting{
+			{Bucket: "income", Amount: -5000},
+			{Bucket: "expenses:taxes", Amount: 1000},
+			{Bucket: "assets:checking", Amount: 4000},
+		}}); err != nil {
+			return err
+		}
+		return budget.InsertEntry(tx, budget.Entry{EntryDate: testutils.Date(4, 2), Amount: 1500, Category: "eating out", Description: "pizza"})
+	})
+	var book beancount.Book
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		book, err = beancount.Export(tx)
+		return err
+	})
+	// the seed adds three budget entries in January
+	var b bytes.Buffer
+	if err := beancount.Write(&b, book, []time.Time{testutils.Date(4, 2), testutils.Date(4, 30)}); err != nil {
+		t.Fatal(err)
+	}
+	want := `option "operating_currency" "USD"
+
+2021-01-01 open Assets:Assets
+2021-01-01 open Assets:Checking
+2021-01-01 open Assets:Euro
+2021-01-01 open Equity:Budget
+2021-01-01 open Equity:Equity
+2021-01-01 open Expenses:Budget:Groceries
+2021-01-01 open Expenses:Budget:Rent
+2021-01-01 open Expenses:Expenses
+2021-01-01 open Expenses:Food
+2021-01-01 open Expenses:Taxes
+2021-01-01 open Income:Income
+2021-01-01 open Liabilities:Credit-Card
+
+2021-01-01 * "-"
+  Expenses:Budget:Rent  30.00 USD
+  Equity:Budget  -30.00 USD
+
+2021-01-01 * "whole foods delivery"
+  Expenses:Budget:Groceries  1.00 USD
+  Equity:Budget  -1.00 USD
+
+2021-01-02 * "food train"
+  Expenses:Budget:Groceries  2.00 USD
+  Equity:Budget  -2.00 USD
+
+2021-04-01 price EUR 1.08 USD
+
+2021-04-01 * "Corner shop" "a \"weekly\" shop" #food #trip-paris
+  Liabilities:Credit-Card  -12.34 USD
+  Expenses:Food  12.34 USD
+
+2021-04-02 open Expenses:Budget:Eating-Out
+
+2021-04-02 ! "Paycheck" ""
+  Income:Income  -50.00 USD
+  Expenses:Taxes  10.00 USD
+  Assets:Checking  40.00 USD
+
+2021-04-02 * "pizza"
+  Expenses:Budget:Eating-Out  15.00 USD
+  Equity:Budget  -15.00 USD
+
+2021-04-03 balance Assets:Checking  40.00 USD
+2021-04-03 balance Equity:Budget  -48.00 USD
+2021-04-03 balance Expenses:Budget:Eating-Out  15.00 USD
+2021-04-03 balance Expenses:Budget:Groceries  3.00 USD
+2021-04-03 balance Expenses:Budget:Rent  30.00 USD
+2021-04-03 balance Expenses:Food  12.34 USD
+2021-04-03 balance Expenses:Taxes  10.00 USD
+2021-04-03 balance Income:Income  -50.00 USD
+2021-04-03 balance Liabilities:Credit-Card  -12.34 USD
+
+2021-04-03 ! ""
+  Equity:Equity  -100.00 EUR
+  Assets:Euro  100.00 EUR
+
+2021-04-30 close Liabilities:Credit-Card
+
+2021-05-01 balance Assets:Checking  40.00 USD
+2021-05-01 balance Assets:Euro  100.00 EUR
+2021-05-01 balance Equity:Budget  -48.00 USD
+2021-05-01 balance Equity:Equity  -100.00 EUR
+2021-05-01 balance Expenses:Budget:Eating-Out  15.00 USD
+2021-05-01 balance Expenses:Budget:Groceries  3.00 USD
+2021-05-01 balance Expenses:Budget:Rent  30.00 USD
+2021-05-01 balance Expenses:Food  12.34 USD
+2021-05-01 balance Expenses:Taxes  10.00 USD
+2021-05-01 balance Income:Income  -50.00 USD
+`
+	testutils.AssertEqual(t, want, b.String())
+}
+
+func TestWriteCollision(t *testing.T) {
+	book := beancount.Book{Buckets: []string{"assets:credit card", "assets:credit-card"}}
+	var b bytes.Buffer
+	if err := beancount.Write(&b, book, nil); err == nil {
+		t.Fatal("want an error writing two buckets as one account")
+	}
+}
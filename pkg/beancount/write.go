@@ -0,0 +1,329 @@
+package beancount
+
+import (
+	"fmt"
+	"io"
+	"ledger/pkg/ledger"
+	"ledger/pkg/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the Beancount root account of each bucket type
+var roots = map[ledger.BucketType]string{
+	ledger.Asset:     "Assets",
+	ledger.Liability: "Liabilities",
+	ledger.Income:    "Income",
+	ledger.Expense:   "Expenses",
+	ledger.Equity:    "Equity",
+}
+
+const (
+	// parent of the account each budget category is spent into
+	budgetRoot = "Expenses:Budget"
+	// account every budget entry is spent from
+	budgetFunds = "Equity:Budget"
+)
+
+// order of directives written on the same day
+const (
+	openRank = iota
+	priceRank
+	balanceRank
+	transactionRank
+	closeRank
+)
+
+// write a Beancount file opening an account for every bucket and budget
+// category, followed by prices, transactions and closes in date order. For
+// each of balanceDates, a balance directive is written the next day for
+// every account that has seen postings, since Beancount checks a balance
+// before the transactions of its date.
+func Write(w io.Writer, b Book, balanceDates []time.Time) error {
+	type block struct {
+		day  string
+		rank int
+		text string
+	}
+	type posting struct {
+		day       string
+		account   string
+		commodity string
+		amount    int
+	}
+	var blocks []block
+	var postings []posting
+	// the bucket written to each account, and the first day each is used
+	owners := map[string]string{}
+	firstUse := map[string]string{}
+	use := func(account, day string) {
+		if first, ok := firstUse[account]; !ok || day < first {
+			firstUse[account] = day
+		}
+	}
+	accountOf := func(bucket string) (string, error) {
+		a := account(b.Registry, bucket)
+		if owner, ok := owners[a]; ok && owner != bucket {
+			return "", fmt.Errorf("Write() - buckets %s and %s would both be written as %s", owner, bucket, a)
+		}
+		owners[a] = bucket
+		if _, ok := firstUse[a]; !ok {
+			firstUse[a] = ""
+		}
+		return a, nil
+	}
+	for _, bucket := range b.Buckets {
+		if _, err := accountOf(bucket); err != nil {
+			return err
+		}
+	}
+	for _, p := range b.Prices {
+		text, err := formatPrice(p)
+		if err != nil {
+			return fmt.Errorf("Write() - %w", err)
+		}
+		blocks = append(blocks, block{utils.FormatDate(p.Date), priceRank, text})
+	}
+	splits := map[int][]ledger.Entry{}
+	for _, e := range b.Entries {
+		if e.TransactionID != 0 {
+			splits[e.TransactionID] = append(splits[e.TransactionID], e)
+		}
+	}
+	for _, e := range b.Entries {
+		group := []ledger.Entry{e}
+		if e.TransactionID != 0 {
+			var ok bool
+			if group, ok = splits[e.TransactionID]; !ok {
+				continue
+			}
+			delete(splits, e.TransactionID)
+		}
+		t := ledger.EntriesToTransaction(group)
+		if err := validateCurrency(t.Commodity); err != nil {
+			return fmt.Errorf("Write() - %w", err)
+		}
+		day := utils.FormatDate(t.EntryDate)
+		flag := "*"
+		if t.Status == ledger.Pending {
+			flag = "!"
+		}
+		var lines []string
+		for _, p := range t.Postings {
+			a, err := accountOf(p.Bucket)
+			if err != nil {
+				return err
+			}
+			use(a, day)
+			postings = append(postings, posting{day, a, t.Commodity, p.Amount})
+			lines = append(lines, a+"  "+formatAmount(p.Amount, t.Commodity))
+		}
+		blocks = append(blocks, block{day, transactionRank, formatTransaction(day, flag, t.Payee, t.Memo, t.Tags, lines)})
+	}
+	for _, e := range b.Budget {
+		day := utils.FormatDate(e.EntryDate)
+		category := budgetRoot + ":" + component(e.Category)
+		use(category, day)
+		use(budgetFunds, day)
+		amount := int(e.Amount)
+		postings = append(postings,
+			posting{day, category, ledger.DefaultCommodity, amount},
+			posting{day, budgetFunds, ledger.DefaultCommodity, -amount})
+		text := formatTransaction(day, "*", "", e.Description, e.Tags, []string{
+			category + "  " + formatAmount(amount, ledger.DefaultCommodity),
+			budgetFunds + "  " + formatAmount(-amount, ledger.DefaultCommodity),
+		})
+		blocks = append(blocks, block{day, transactionRank, text})
+	}
+
+	accounts := []string{}
+	for a := range firstUse {
+		accounts = append(accounts, a)
+	}
+	sort.Strings(accounts)
+	// accounts never posted to open with the earliest directive
+	earliest := utils.FormatDate(utils.BigBang)
+	for i, bl := range blocks {
+		if i == 0 || bl.day < earliest {
+			earliest = bl.day
+		}
+	}
+	opens, closes := map[string]string{}, map[string]string{}
+	for _, a := range accounts {
+		day := firstUse[a]
+		if r, ok := b.Registry[owners[a]]; ok {
+			if opened := utils.FormatDate(r.OpenedAt); day == "" || opened < day {
+				day = opened
+			}
+			if !r.ClosedAt.IsZero() {
+				closes[a] = utils.FormatDate(r.ClosedAt)
+			}
+		}
+		if day == "" {
+			day = earliest
+		}
+		opens[a] = day
+		blocks = append(blocks, block{day, openRank, fmt.Sprintf("%s open %s\n", day, a)})
+		if day, ok := closes[a]; ok {
+			blocks = append(blocks, block{day, closeRank, fmt.Sprintf("%s close %s\n", day, a)})
+		}
+	}
+	for _, d := range balanceDates {
+		through := utils.FormatDate(d)
+		day := utils.FormatDate(d.AddDate(0, 0, 1))
+		var lines strings.Builder
+		for _, a := range accounts {
+			if closed, ok := closes[a]; day < opens[a] || ok && day > closed {
+				continue
+			}
+			// a Beancount balance counts the postings of every subaccount
+			holdings := map[string]int{}
+			for _, p := range postings {
+				if p.day <= through && (p.account == a || strings.HasPrefix(p.account, a+":")) {
+					holdings[p.commodity] += p.amount
+				}
+			}
+			commodities := []string{}
+			for c := range holdings {
+				commodities = append(commodities, c)
+			}
+			sort.Strings(commodities)
+			for _, c := range commodities {
+				fmt.Fprintf(&lines, "%s balance %s  %s\n", day, a, formatAmount(holdings[c], c))
+			}
+		}
+		if lines.Len() > 0 {
+			blocks = append(blocks, block{day, balanceRank, lines.String()})
+		}
+	}
+
+	sort.SliceStable(blocks, func(i, k int) bool {
+		if blocks[i].day != blocks[k].day {
+			return blocks[i].day < blocks[k].day
+		}
+		return blocks[i].rank < blocks[k].rank
+	})
+	if _, err := fmt.Fprintf(w, "option \"operating_currency\" %s\n", quote(ledger.DefaultCommodity)); err != nil {
+		return fmt.Errorf("Write() - %w", err)
+	}
+	for i, bl := range blocks {
+		// runs of one-line directives are kept together
+		text := bl.text
+		if i == 0 || bl.rank != blocks[i-1].rank || bl.rank == transactionRank {
+			text = "\n" + text
+		}
+		if _, err := io.WriteString(w, text); err != nil {
+			return fmt.Errorf("Write() - %w", err)
+		}
+	}
+	return nil
+}
+
+// get the Beancount account a bucket is written to, e.g. Assets:Checking
+// for assets:checking
+func account(registry ledger.Registry, bucket string) string {
+	parts := strings.Split(bucket, ":")
+	implied, named := ledger.ImpliedType(bucket)
+	t := registry.Type(bucket)
+	if named && implied == t && len(parts) > 1 {
+		parts = parts[1:]
+	}
+	components := []string{roots[t]}
+	for _, p := range parts {
+		components = append(components, component(p))
+	}
+	return strings.Join(components, ":")
+}
+
+// turn part of a bucket name into an account component by capitalizing each
+// word and joining the words with dashes, e.g. "credit card" as Credit-Card
+func component(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !isLetterOrDigit(r)
+	})
+	if len(words) == 0 {
+		return "Unnamed"
+	}
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, "-")
+}
+
+func isLetterOrDigit(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// format a transaction with its postings already written out, e.g.
+//
+//	2021-04-01 * "Corner shop" "weekly shop" #food
+//	  Assets:Checking  -12.34 USD
+//	  Expenses:Food  12.34 USD
+func formatTransaction(day, flag, payee, narration string, tags, postings []string) string {
+	var b strings.Builder
+	b.WriteString(day + " " + flag)
+	if payee != "" {
+		b.WriteString(" " + quote(payee))
+	}
+	b.WriteString(" " + quote(narration))
+	for _, t := range tags {
+		b.WriteString(" #" + tag(t))
+	}
+	b.WriteString("\n")
+	for _, p := range postings {
+		b.WriteString("  " + p + "\n")
+	}
+	return b.String()
+}
+
+// format a price directive, e.g. "2021-04-01 price EUR 1.08 USD"
+func formatPrice(p ledger.Price) (string, error) {
+	for _, c := range []string{p.Commodity, p.Quote} {
+		if err := validateCurrency(c); err != nil {
+			return "", err
+		}
+	}
+	rate := strconv.FormatFloat(p.Rate, 'f', -1, 64)
+	return fmt.Sprintf("%s price %s %s %s\n", utils.FormatDate(p.Date), p.Commodity, rate, p.Quote), nil
+}
+
+// format an amount in hundredths of a commodity, e.g. -12.34 USD
+func formatAmount(amount int, commodity string) string {
+	if commodity == "" {
+		commodity = ledger.DefaultCommodity
+	}
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%.2d %s", sign, amount/100, amount%100, commodity)
+}
+
+// check that a commodity is also a Beancount currency, which takes at least
+// two characters and starts with a letter
+func validateCurrency(c string) error {
+	if len(c) < 2 || len(c) > 24 || c[0] < 'A' || c[0] > 'Z' {
+		return fmt.Errorf("commodity %q is not a Beancount currency", c)
+	}
+	return nil
+}
+
+// quote a string, escaping backslashes and quotes and folding line breaks
+func quote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r\n", " ", "\n", " ").Replace(s)
+	return `"` + s + `"`
+}
+
+// turn a tag into a Beancount tag, replacing characters it may not hold with
+// dashes, e.g. trip:paris as trip-paris
+func tag(t string) string {
+	return strings.Map(func(r rune) rune {
+		if isLetterOrDigit(r) || strings.ContainsRune("-_/.", r) {
+			return r
+		}
+		return '-'
+	}, t)
+}
@@ -3,6 +3,8 @@ package budget
 import (
 	"database/sql"
 	"fmt"
+	"ledger/pkg/audit"
+	"ledger/pkg/period"
 	"ledger/pkg/usd"
 	"ledger/pkg/utils"
 	"net/http"
@@ -17,6 +19,8 @@ type Entry struct {
 	Amount      usd.USD
 	Category    string
 	Description string
+	Tags        []string
+	FITID       string // id the bank gave the entry in an imported statement, or empty
 }
 
 type PlotData struct {
@@ -27,23 +31,48 @@ type PlotData struct {
 
 func InsertEntry(tx *sql.Tx, e Entry) error {
 	q := `INSERT INTO budget_entries
-		(happened_at, amount, category, description)
-		VALUES (date($1), $2, $3, $4);`
-	happened_at := utils.ConvertToDate(e.EntryDate)
-	_, err := tx.Exec(q, happened_at, e.Amount, e.Category, e.Description)
+		(happened_at, amount, category, description, fitid)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''));`
+	res, err := tx.Exec(q, utils.FormatDate(e.EntryDate), e.Amount, e.Category, e.Description, e.FITID)
 	if err != nil {
 		return fmt.Errorf("calling budget.InsertEntry() (%w)", err)
 	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("calling res.LastInsertId() (%w)", err)
+	}
+	if err := utils.InsertTags(tx, "budget_entry_id", id, e.Tags); err != nil {
+		return fmt.Errorf("calling utils.InsertTags() (%w)", err)
+	}
+	if err := audit.Inserted(tx, "budget_entries", int(id)); err != nil {
+		return fmt.Errorf("calling audit.Inserted() (%w)", err)
+	}
 	return nil
 }
 
-// get all entries from budget in given time period
-func GetBudgetEntries(tx *sql.Tx, start, end time.Time) ([]Entry, error) {
-	q := `SELECT * FROM budget_entries
-		WHERE date(happened_at) BETWEEN date($1) AND date($2)
+// report whether an entry the bank identified by fitid has already been
+// imported into category
+func Imported(tx *sql.Tx, category, fitid string) (bool, error) {
+	q := `SELECT count(id) FROM budget_entries
+		WHERE fitid = $1 AND category = $2;`
+	var count int
+	if err := tx.QueryRow(q, fitid, category).Scan(&count); err != nil {
+		return false, fmt.Errorf("calling row.Scan() (%w)", err)
+	}
+	return count > 0, nil
+}
+
+// get all entries from budget in given time period, optionally only those
+// carrying any of the given tags
+func GetBudgetEntries(tx *sql.Tx, start, end time.Time, tags ...string) ([]Entry, error) {
+	tagFilter, tagArgs := utils.TagFilter("id", "budget_entry_id", tags, 3)
+	q := `SELECT happened_at, amount, category, description, ` +
+		utils.TagsColumn("budget_entries", "budget_entry_id") + `
+		FROM budget_entries
+		WHERE happened_at BETWEEN $1 AND $2` + tagFilter + `
 		ORDER BY happened_at;`
 
-	rows, err := tx.Query(q, start, end)
+	rows, err := tx.Query(q, append([]interface{}{utils.FormatDate(start), utils.FormatDate(end)}, tagArgs...)...)
 	if err != nil {
 		return nil, fmt.Errorf("Could not query sql (%v)", err)
 	}
@@ -51,10 +80,11 @@ func GetBudgetEntries(tx *sql.Tx, start, end time.Time) ([]Entry, error) {
 	var budget []Entry
 	for rows.Next() {
 		e := Entry{}
-		var datestring string
-		if err := rows.Scan(&datestring, &e.Amount, &e.Category, &e.Description); err != nil {
+		var datestring, tags string
+		if err := rows.Scan(&datestring, &e.Amount, &e.Category, &e.Description, &tags); err != nil {
 			return nil, err
 		}
+		e.Tags = utils.SplitTags(tags)
 		if e.EntryDate, err = time.Parse("2006-01-02", datestring); err != nil {
 			return nil, err
 		}
@@ -63,14 +93,16 @@ func GetBudgetEntries(tx *sql.Tx, start, end time.Time) ([]Entry, error) {
 	return budget, nil
 }
 
-// get net spend of category from start through end
-func SummarizeCategory(tx *sql.Tx, category string, start, end time.Time) (usd.USD, error) {
+// get net spend of category from start through end, optionally counting only
+// entries that carry any of the given tags
+func SummarizeCategory(tx *sql.Tx, category string, start, end time.Time, tags ...string) (usd.USD, error) {
+	tagFilter, tagArgs := utils.TagFilter("id", "budget_entry_id", tags, 4)
 	q := `SELECT COALESCE(sum(amount), 0)
 		FROM budget_entries
 		WHERE category = $1
 		AND
-		date(happened_at) BETWEEN date($2) AND date($3)`
-	row := tx.QueryRow(q, category, start, end)
+		happened_at BETWEEN $2 AND $3` + tagFilter
+	row := tx.QueryRow(q, append([]interface{}{category, utils.FormatDate(start), utils.FormatDate(end)}, tagArgs...)...)
 	var sum usd.USD
 	if err := row.Scan(&sum); err != nil {
 		return -1, fmt.Errorf("calling row.Scan() (%w)", err)
@@ -78,11 +110,12 @@ func SummarizeCategory(tx *sql.Tx, category string, start, end time.Time) (usd.U
 	return sum, nil
 }
 
-// get net spend of categories from start through end
-func SummarizeCategories(tx *sql.Tx, categories []string, from, through time.Time) (map[string]usd.USD, error) {
+// get net spend of categories from start through end, optionally counting
+// only entries that carry any of the given tags
+func SummarizeCategories(tx *sql.Tx, categories []string, from, through time.Time, tags ...string) (map[string]usd.USD, error) {
 	output := map[string]usd.USD{}
 	for _, c := range categories {
-		val, err := SummarizeCategory(tx, c, from, through)
+		val, err := SummarizeCategory(tx, c, from, through, tags...)
 		if err != nil {
 			return nil, fmt.Errorf("calling SummarizeCategory() (%v)", err)
 		}
@@ -91,21 +124,60 @@ func SummarizeCategories(tx *sql.Tx, categories []string, from, through time.Tim
 	return output, nil
 }
 
-func SummarizeSpendsOverTime(tx *sql.Tx, categories []string, start, end time.Time, interval int) ([]map[string]usd.USD, error) {
+// get net spend of categories in each period of interval from start through
+// end, optionally counting only entries that carry any of the given tags. The
+// budget is read with a single query. Periods are whole, so calendar
+// intervals may reach back before start, and the last period runs its full
+// length, even past end.
+func SummarizeSpendsOverTime(tx *sql.Tx, categories []string, start, end time.Time, interval period.Interval, tags ...string) ([]map[string]usd.USD, error) {
+	periods := interval.Periods(start, end)
 	output := []map[string]usd.USD{}
-	for d := start; d.Before(end.AddDate(0, 0, 1)); d = d.AddDate(0, 0, interval) {
+	if len(periods) == 0 {
+		return output, nil
+	}
+	// get each day's spend per category
+	tagFilter, tagArgs := utils.TagFilter("id", "budget_entry_id", tags, 3)
+	q := `SELECT happened_at, category, sum(amount)
+		FROM budget_entries
+		WHERE happened_at BETWEEN $1 AND $2` + tagFilter + `
+		GROUP BY happened_at, category;`
+	first, last := periods[0].Start, periods[len(periods)-1].Last
+	rows, err := tx.Query(q, append([]interface{}{utils.FormatDate(first), utils.FormatDate(last)}, tagArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("budget.SummarizeSpendsOverTime() querying spends (%w)", err)
+	}
+	defer rows.Close()
+	spends := map[string]map[string]usd.USD{}
+	for rows.Next() {
+		var day, category string
+		var sum usd.USD
+		if err := rows.Scan(&day, &category, &sum); err != nil {
+			return nil, fmt.Errorf("calling rows.Scan() (%w)", err)
+		}
+		if spends[day] == nil {
+			spends[day] = map[string]usd.USD{}
+		}
+		spends[day][category] = sum
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("budget.SummarizeSpendsOverTime() reading spends (%w)", err)
+	}
+	for _, p := range periods {
 		// summarize from the start to end of an interval period
-		c, err := SummarizeCategories(tx, categories, d, d.AddDate(0, 0, interval-1))
-		if err != nil {
-			return nil, fmt.Errorf("calling SummarizeCategories() (%w)", err)
+		c := map[string]usd.USD{}
+		for _, category := range categories {
+			for d := p.Start; !d.After(p.Last); d = d.AddDate(0, 0, 1) {
+				c[category] += spends[utils.FormatDate(d)][category]
+			}
 		}
 		output = append(output, c)
 	}
 	return output, nil
 }
 
-// prepare data to be used in html template
-func MakePlot(summary []map[string]usd.USD, start time.Time, interval int) *PlotData {
+// prepare data to be used in html template, with a row for each period of
+// interval from start
+func MakePlot(summary []map[string]usd.USD, start time.Time, interval period.Interval) *PlotData {
 	output := &PlotData{}
 	if len(summary) > 0 {
 		for b := range summary[0] {
@@ -113,8 +185,10 @@ func MakePlot(summary []map[string]usd.USD, start time.Time, interval int) *Plot
 		}
 		sort.Strings(output.BucketHeaders)
 
-		for i, day := range summary {
-			output.DateHeaders = append(output.DateHeaders, start.AddDate(0, 0, i*interval).Format("2006-01-02"))
+		d := interval.Start(start)
+		for _, day := range summary {
+			output.DateHeaders = append(output.DateHeaders, interval.Label(d))
+			d = interval.Next(d)
 			row := []usd.USD{}
 			for _, b := range output.BucketHeaders {
 				row = append(row, day[b])
@@ -140,13 +214,17 @@ func PrepareEntryForInsert(r *http.Request) (Entry, error) {
 		Amount:      amount,
 		Category:    r.PostForm["category"][0],
 		Description: r.PostForm["description"][0],
+		Tags:        utils.ParseTags(r.PostForm.Get("tags")),
 	}
 	return entry, nil
 }
 
-func GetCategories(tx *sql.Tx) ([]string, error) {
-	q := `SELECT DISTINCT category FROM budget_entries ORDER BY category;`
-	rows, err := tx.Query(q)
+// get all categories, optionally only those with entries that carry any of
+// the given tags
+func GetCategories(tx *sql.Tx, tags ...string) ([]string, error) {
+	tagFilter, tagArgs := utils.TagFilter("id", "budget_entry_id", tags, 1)
+	q := `SELECT DISTINCT category FROM budget_entries WHERE 1` + tagFilter + ` ORDER BY category;`
+	rows, err := tx.Query(q, tagArgs...)
 	if err != nil {
 		return nil, err
 	}
@@ -3,9 +3,11 @@ package budget_test
 import (
 	"database/sql"
 	"ledger/pkg/budget"
+	"ledger/pkg/period"
 	"ledger/pkg/testutils"
 	"ledger/pkg/usd"
 	"testing"
+	"time"
 )
 
 func TestInsertEntry(t *testing.T) {
@@ -292,7 +294,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{},
 					janOne,
 					janOne,
-					1, // interval: summarize daily
+					period.Days(1), // interval: summarize daily
 				)
 				return err
 			})
@@ -310,7 +312,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{"COBRA"},
 					janOne,
 					janOne,
-					1,
+					period.Days(1),
 				)
 				return err
 			})
@@ -328,7 +330,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{"COBRA", "laundry"},
 					janOne,
 					janOne,
-					1,
+					period.Days(1),
 				)
 				return err
 			})
@@ -347,7 +349,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{"COBRA", "laundry"},
 					janOne,
 					janTwo,
-					1,
+					period.Days(1),
 				)
 				return err
 			})
@@ -366,7 +368,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{"COBRA", "laundry"},
 					janOne,
 					janTwo,
-					1,
+					period.Days(1),
 				)
 				return err
 			})
@@ -384,7 +386,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{"groceries"},
 					janOne,
 					janOne,
-					1,
+					period.Days(1),
 				)
 				return err
 			})
@@ -403,7 +405,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{"groceries", "rent"},
 					janOne,
 					janTwo,
-					1,
+					period.Days(1),
 				)
 				return err
 			})
@@ -421,7 +423,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{"groceries"},
 					janOne,
 					janTwo,
-					2,
+					period.Days(2),
 				)
 				return err
 			})
@@ -439,7 +441,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{"rent", "groceries"},
 					janOne,
 					janTwo,
-					2,
+					period.Days(2),
 				)
 				return err
 			})
@@ -459,7 +461,7 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 					[]string{"rent", "groceries"},
 					janOne,
 					janOne.AddDate(0, 0, 4),
-					2,
+					period.Days(2),
 				)
 				return err
 			})
@@ -467,3 +469,75 @@ func TestSummarizeSpendsOverTime(t *testing.T) {
 		})
 
 }
+
+func TestSummarizeSpendsOverTimeIntervals(t *testing.T) {
+	db := testutils.Db(t)
+	start := testutils.JanOne
+	end := start.AddDate(0, 1, 0)
+	categories := []string{"groceries", "rent", "unused"}
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		return insertSpends(tx, 200, start.AddDate(0, 0, -5))
+	})
+	for _, days := range []int{1, 7, 30, 45} {
+		interval := period.Days(days)
+		var want, got []map[string]usd.USD
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			for d := start; d.Before(end.AddDate(0, 0, 1)); d = d.AddDate(0, 0, days) {
+				c, err := budget.SummarizeCategories(tx, categories, d, d.AddDate(0, 0, days-1))
+				if err != nil {
+					return err
+				}
+				want = append(want, c)
+			}
+			got, err = budget.SummarizeSpendsOverTime(tx, categories, start, end, interval)
+			return err
+		})
+		testutils.AssertEqual(t, want, got)
+	}
+}
+
+func BenchmarkSummarizeSpendsOverTime(b *testing.B) {
+	db := testutils.Db(b)
+	start := testutils.JanOne
+	testutils.Tx(b, db, func(tx *sql.Tx) error {
+		return insertSpends(tx, 100000, start.AddDate(-1, 0, 0))
+	})
+	categories := []string{"groceries", "rent", "laundry"}
+	end := start.AddDate(0, 0, 29)
+	b.Run("single query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			testutils.Tx(b, db, func(tx *sql.Tx) error {
+				_, err := budget.SummarizeSpendsOverTime(tx, categories, start, end, period.Days(1))
+				return err
+			})
+		}
+	})
+	b.Run("query per day and category", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			testutils.Tx(b, db, func(tx *sql.Tx) error {
+				for d := start; d.Before(end.AddDate(0, 0, 1)); d = d.AddDate(0, 0, 1) {
+					if _, err := budget.SummarizeCategories(tx, categories, d, d); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+	})
+}
+
+// insert n budget entries, one every few hours from start
+func insertSpends(tx *sql.Tx, n int, start time.Time) error {
+	categories := []string{"groceries", "rent", "laundry", "COBRA"}
+	for i := 0; i < n; i++ {
+		e := budget.Entry{
+			EntryDate: start.Add(time.Duration(i*5) * time.Hour),
+			Amount:    usd.USD(i%97 + 1),
+			Category:  categories[i%len(categories)],
+		}
+		if err := budget.InsertEntry(tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
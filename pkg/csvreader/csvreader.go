@@ -37,9 +37,16 @@ func CsvToLedgerEntries(filepath string) ([]ledger.Entry, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Reading the header row: %w", err)
 	}
-	// Validate order of columns
-	if header[0] != "source" || header[1] != "destination" || header[2] != "entrydate" || header[3] != "amount" {
-		return nil, fmt.Errorf("Columns must be in order: source, destination, entrydate, amount (%w)", err)
+	// Validate order of columns; payee, memo and commodity are optional
+	// trailing columns
+	ledgerColumns := []string{"source", "destination", "entrydate", "amount", "payee", "memo", "commodity"}
+	if len(header) < 4 || len(header) > len(ledgerColumns) {
+		return nil, fmt.Errorf("Columns must be in order: source, destination, entrydate, amount[, payee[, memo[, commodity]]]")
+	}
+	for i, h := range header {
+		if h != ledgerColumns[i] {
+			return nil, fmt.Errorf("Columns must be in order: source, destination, entrydate, amount[, payee[, memo[, commodity]]] (got %s in column %d)", h, i+1)
+		}
 	}
 
 	// construct slice of buckets to return
@@ -69,6 +76,15 @@ func CsvToLedgerEntries(filepath string) ([]ledger.Entry, error) {
 			EntryDate:   EntryDate,
 			Amount:      amount,
 		}
+		if len(record) > 4 {
+			e.Payee = record[4]
+		}
+		if len(record) > 5 {
+			e.Memo = record[5]
+		}
+		if len(record) > 6 {
+			e.Commodity = record[6]
+		}
 		entries = append(entries, e)
 	}
 	return entries, nil
@@ -123,6 +139,56 @@ func CsvToBudgetEntries(filepath string) ([]budget.Entry, error) {
 	return entries, nil
 }
 
+// convert a CSV of price history to a slice of prices, each the rate of one
+// unit of commodity in quote on a date
+func CsvToPrices(filepath string) ([]ledger.Price, error) {
+	// create the reader
+	reader, err := csvReader(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("creating the reader: %w", err)
+	}
+	// read the header
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Reading the header row: %w", err)
+	}
+	// Validate order of columns
+	priceColumns := []string{"date", "commodity", "quote", "rate"}
+	if len(header) != len(priceColumns) {
+		return nil, fmt.Errorf("Columns must be in order: date, commodity, quote, rate")
+	}
+	for i, h := range header {
+		if h != priceColumns[i] {
+			return nil, fmt.Errorf("Columns must be in order: date, commodity, quote, rate (got %s in column %d)", h, i+1)
+		}
+	}
+
+	var prices []ledger.Price
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break // reached end of the file
+		} else if err != nil {
+			return nil, fmt.Errorf("Reading a row: %v", err)
+		}
+		date, err := utils.ParseDate(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("Parsing string to time.Time: %w", err)
+		}
+		rate, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Converting string to float: %w", err)
+		}
+		prices = append(prices, ledger.Price{
+			Commodity: record[1],
+			Quote:     record[2],
+			Date:      date,
+			Rate:      rate,
+		})
+	}
+	return prices, nil
+}
+
 func CreateTempFile(r *http.Request) (string, error) {
 	// retrieve file from posted form-data
 	// expects server has already called r.ParseMultipartForm()
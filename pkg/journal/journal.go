@@ -0,0 +1,72 @@
+// Package journal reads and writes the plain-text journals kept with
+// ledger-cli and hledger. It supports a subset of their format: dated
+// transactions with a status mark, payee, comment and tags; postings in a
+// single commodity, at most one of them with its amount left out; balance
+// assertions; and P price directives. Account, commodity and similar
+// directives are skipped, while costs, virtual postings, periodic and
+// automated transactions and includes are rejected.
+//
+// Transactions marked * are read as cleared, while those marked ! (pending)
+// or left unmarked are read as pending. Cleared and reconciled entries are
+// both written with *, so reconciled entries come back cleared, and pending
+// entries are left unmarked. The comment on a transaction's first line is its
+// memo, while tags go in a comment line of their own. A payee that starts
+// with a mark or a code is written after an empty code, (), and one holding a
+// semicolon cannot be written at all. Ids, voids, period closes and repeating entries
+// are not carried, so a voided entry and its reversal come back as two
+// ordinary entries.
+package journal
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/audit"
+	"ledger/pkg/ledger"
+)
+
+// Journal is what a journal file holds. Entries from a transaction of more
+// than two postings share a TransactionID, numbered within the journal when
+// read from a file; every other entry has none.
+type Journal struct {
+	Entries    []ledger.Entry
+	Assertions []ledger.Assertion
+	Prices     []ledger.Price
+}
+
+// record everything in a journal, inserting each split transaction whole
+// where its first entry falls
+func Import(tx *sql.Tx, j Journal) error {
+	return audit.ChangeSet(tx, func() error {
+		for _, p := range j.Prices {
+			if _, err := ledger.InsertPrice(tx, p); err != nil {
+				return fmt.Errorf("Import() - %w", err)
+			}
+		}
+		if err := ledger.InsertEntries(tx, j.Entries); err != nil {
+			return fmt.Errorf("Import() - %w", err)
+		}
+		for _, a := range j.Assertions {
+			if _, err := ledger.InsertAssertion(tx, a); err != nil {
+				return fmt.Errorf("Import() - %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// get every stored entry, balance assertion and price
+func Export(tx *sql.Tx) (Journal, error) {
+	entries, err := ledger.GetEntries(tx)
+	if err != nil {
+		return Journal{}, fmt.Errorf("Export() - %w", err)
+	}
+	assertions, err := ledger.GetAssertions(tx)
+	if err != nil {
+		return Journal{}, fmt.Errorf("Export() - %w", err)
+	}
+	prices, err := ledger.GetPrices(tx)
+	if err != nil {
+		return Journal{}, fmt.Errorf("Export() - %w", err)
+	}
+	return Journal{Entries: entries, Assertions: assertions, Prices: prices}, nil
+}
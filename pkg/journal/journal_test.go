@@ -0,0 +1,180 @@
+package journal_test
+
+import (
+	"bytes"
+	"database/sql"
+	"ledger/pkg/journal"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := `; a journal kept with hledger
+account assets:checking
+account expenses:food
+
+P 2021-04-01 EUR $1.08
+
+2021/04/01 * (1001) Corner shop  ; weekly shop
+    ; :food:home:
+    expenses:food        $1,234.50
+    assets:checking
+
+2021-04-02 ! Paycheck
+    ; employer:acme, payroll:
+    income:salary        -$50
+    taxes                 $10
+    assets:checking       $40 = $1,000.00
+
+2021-04-03 Holiday
+    assets:euro          -12.5 EUR  ; card payment
+    expenses:travel      EUR 12.50
+
+2021-04-30 balance assertion
+    assets:euro  0 = -12.50 EUR
+`
+	got, err := journal.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := journal.Journal{
+		Entries: []ledger.Entry{
+			{Source: "assets:checking", Destination: "expenses:food", EntryDate: testutils.Date(4, 1), Amount: 123450, Commodity: "USD",
+				Status: ledger.Cleared, Payee: "Corner shop", Memo: "weekly shop", Tags: []string{"food", "home"}},
+			{Source: "income:salary", Destination: "taxes", EntryDate: testutils.Date(4, 2), Amount: 1000, Commodity: "USD",
+				Status: ledger.Pending, Payee: "Paycheck", TransactionID: 1, Tags: []string{"employer:acme", "payroll"}},
+			{Source: "income:salary", Destination: "assets:checking", EntryDate: testutils.Date(4, 2), Amount: 4000, Commodity: "USD",
+				Status: ledger.Pending, Payee: "Paycheck", TransactionID: 1, Tags: []string{"employer:acme", "payroll"}},
+			{Source: "assets:euro", Destination: "expenses:travel", EntryDate: testutils.Date(4, 3), Amount: 1250, Commodity: "EUR",
+				Status: ledger.Pending, Payee: "Holiday"},
+		},
+		Assertions: []ledger.Assertion{
+			{Bucket: "assets:checking", Date: testutils.Date(4, 2), Amount: 100000, Commodity: "USD"},
+			{Bucket: "assets:euro", Date: testutils.Date(4, 30), Amount: -1250, Commodity: "EUR"},
+		},
+		Prices: []ledger.Price{{Commodity: "EUR", Quote: "USD", Date: testutils.Date(4, 1), Rate: 1.08}},
+	}
+	testutils.AssertEqual(t, want, got)
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"unbalanced":         "2021-04-01 shop\n    expenses:food  $10\n    assets:checking  -$9\n",
+		"two elided amounts": "2021-04-01 shop\n    expenses:food  $10\n    assets:checking\n    assets:savings\n",
+		"mixed commodities":  "2021-04-01 shop\n    expenses:food  $10\n    assets:euro  -10 EUR\n",
+		"cost":               "2021-04-01 shop\n    assets:euro  10 EUR @ $1.10\n    assets:checking\n",
+		"fractions of cents": "2021-04-01 shop\n    expenses:food  $10.005\n    assets:checking\n",
+		"periodic":           "~ monthly\n    expenses:rent  $100\n    assets:checking\n",
+		"no year":            "04/01 shop\n    expenses:food  $10\n    assets:checking\n",
+	} {
+		if _, err := journal.Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+// write everything stored in db as a journal
+func export(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	var j journal.Journal
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		j, err = journal.Export(tx)
+		return err
+	})
+	var b bytes.Buffer
+	if err := journal.Write(&b, j); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	db := testutils.Db(t)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		if _, err := ledger.InsertPrice(tx, ledger.Price{Commodity: "EUR", Quote: "USD", Date: testutils.Date(4, 1), Rate: 1.08}); err != nil {
+			return err
+		}
+		for _, e := range []ledger.Entry{
+			{Source: "assets:checking", Destination: "expenses:food", EntryDate: testutils.Date(4, 1), Amount: 1234,
+				Payee: "Corner shop", Memo: "weekly shop", Tags: []string{"food", "home"}, Status: ledger.Cleared},
+			{Source: "assets:checking", Destination: "assets:euro", EntryDate: testutils.Date(4, 3), Amount: 0, Commodity: "EUR"},
+			{Source: "equity", Destination: "assets:euro", EntryDate: testutils.Date(4, 3), Amount: 10000, Commodity: "EUR",
+				Tags: []string{"trip:paris"}, Status: ledger.Reconciled},
+			// a memo that looks like a tag, and payees that look like a mark
+			// or a code
+			{Source: "assets:checking", Destination: "expenses:fees", EntryDate: testutils.Date(4, 4), Amount: 500, Payee: "Bank", Memo: "note: call bank"},
+			{Source: "assets:checking", Destination: "expenses:fun", EntryDate: testutils.Date(4, 5), Amount: 100, Payee: "*Star"},
+			{Source: "assets:checking", Destination: "expenses:fun", EntryDate: testutils.Date(4, 5), Amount: 200, Payee: "(Paren)", Status: ledger.Cleared},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		if _, err := ledger.InsertTransaction(tx, ledger.Transaction{EntryDate: testutils.Date(4, 2), Payee: "Paycheck", Postings: []ledger.Posting{
+			{Bucket: "income:salary", Amount: -5000},
+			{Bucket: "taxes", Amount: 1000},
+			{Bucket: "assets:checking", Amount: 4000},
+		}}); err != nil {
+			return err
+		}
+		_, err := ledger.InsertAssertion(tx, ledger.Assertion{Bucket: "assets:checking", Date: testutils.Date(4, 2), Amount: 2766})
+		return err
+	})
+	want := `P 2021-04-01 EUR $1.08
+
+2021-04-01 * Corner shop  ; weekly shop
+    ; :food:home:
+    assets:checking  -$12.34
+    expenses:food  $12.34
+
+2021-04-02 Paycheck
+    income:salary  -$50.00
+    taxes  $10.00
+    assets:checking  $40.00
+
+2021-04-02 balance assertion
+    assets:checking  0 = $27.66
+
+2021-04-03
+    assets:checking  0.00 EUR
+    assets:euro  0.00 EUR
+
+2021-04-03 *
+    ; trip:paris
+    equity  -100.00 EUR
+    assets:euro  100.00 EUR
+
+2021-04-04 Bank  ; note: call bank
+    assets:checking  -$5.00
+    expenses:fees  $5.00
+
+2021-04-05 () *Star
+    assets:checking  -$1.00
+    expenses:fun  $1.00
+
+2021-04-05 * () (Paren)
+    assets:checking  -$2.00
+    expenses:fun  $2.00
+`
+	got := export(t, db)
+	testutils.AssertEqual(t, want, got)
+
+	// reading the journal into an empty ledger stores the same thing
+	imported := testutils.Db(t)
+	testutils.Tx(t, imported, func(tx *sql.Tx) error {
+		j, err := journal.Parse(strings.NewReader(got))
+		if err != nil {
+			return err
+		}
+		return journal.Import(tx, j)
+	})
+	testutils.AssertEqual(t, want, export(t, imported))
+
+	// a semicolon in a payee would come back as a memo
+	j := journal.Journal{Entries: []ledger.Entry{{Source: "assets:checking", Destination: "expenses:food", EntryDate: testutils.Date(4, 6), Amount: 100, Payee: "A; B"}}}
+	if err := journal.Write(&bytes.Buffer{}, j); err == nil {
+		t.Error("want an error writing a payee with a semicolon")
+	}
+}
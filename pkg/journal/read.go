@@ -0,0 +1,467 @@
+package journal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"ledger/pkg/ledger"
+	"ledger/pkg/usd"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// statuses read for each mark; unmarked transactions are pending too
+var statuses = map[string]ledger.Status{
+	"*": ledger.Cleared,
+	"!": ledger.Pending,
+}
+
+// commodities written as a symbol rather than a code
+var symbols = map[string]string{
+	"$": "USD",
+	"€": "EUR",
+	"£": "GBP",
+	"¥": "JPY",
+}
+
+// directives that carry nothing this ledger keeps; they are skipped along
+// with any indented lines below them
+var skipped = []string{"account", "alias", "apply", "commodity", "D", "decimal-mark", "end", "payee", "tag", "Y", "year"}
+
+// transaction is a transaction as read, before its postings are balanced
+type transaction struct {
+	line     int
+	date     time.Time
+	status   ledger.Status
+	payee    string
+	memo     []string
+	tags     []string
+	postings []posting
+}
+
+// posting is one line of a transaction. An elided posting takes whatever
+// amount balances the others.
+type posting struct {
+	bucket    string
+	amount    int
+	commodity string
+	elided    bool
+	asserted  bool
+	balance   int    // balance asserted after the posting
+	balanceIn string // commodity of balance
+}
+
+// read a journal file
+func ReadFile(filepath string) (Journal, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return Journal{}, fmt.Errorf("ReadFile() - opening the file: %w", err)
+	}
+	defer file.Close()
+	j, err := Parse(file)
+	if err != nil {
+		return Journal{}, fmt.Errorf("ReadFile() - %w", err)
+	}
+	return j, nil
+}
+
+// parse a journal, turning transactions of two postings into one entry each
+// and larger ones into the entries of a split transaction. Every balance
+// assertion is taken to hold at the end of its transaction's date.
+func Parse(r io.Reader) (Journal, error) {
+	var j Journal
+	var t *transaction
+	skipping := false
+	splits := 0
+	finish := func() error {
+		if t == nil {
+			return nil
+		}
+		entries, assertions, err := t.resolve()
+		if err != nil {
+			return fmt.Errorf("Parse() - line %d: %w", t.line, err)
+		}
+		if len(entries) > 1 {
+			splits++
+			for i := range entries {
+				entries[i].TransactionID = splits
+			}
+		}
+		j.Entries = append(j.Entries, entries...)
+		j.Assertions = append(j.Assertions, assertions...)
+		t = nil
+		return nil
+	}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.TrimSpace(line) == "" {
+			if err := finish(); err != nil {
+				return Journal{}, err
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if t != nil {
+				if err := t.add(strings.TrimSpace(line)); err != nil {
+					return Journal{}, fmt.Errorf("Parse() - line %d: %w", n, err)
+				}
+			} else if !skipping && !isComment(strings.TrimSpace(line)) {
+				return Journal{}, fmt.Errorf("Parse() - line %d: indented line outside of a transaction", n)
+			}
+			continue
+		}
+		if err := finish(); err != nil {
+			return Journal{}, err
+		}
+		skipping = false
+		word := strings.Fields(line)[0]
+		switch {
+		case isComment(line):
+		case unicode.IsDigit(rune(line[0])):
+			var err error
+			if t, err = parseHeader(line); err != nil {
+				return Journal{}, fmt.Errorf("Parse() - line %d: %w", n, err)
+			}
+			t.line = n
+		case word == "P":
+			p, err := parsePrice(line)
+			if err != nil {
+				return Journal{}, fmt.Errorf("Parse() - line %d: %w", n, err)
+			}
+			j.Prices = append(j.Prices, p)
+		case isSkipped(word):
+			skipping = true
+		default:
+			return Journal{}, fmt.Errorf("Parse() - line %d: unsupported line %q", n, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Journal{}, fmt.Errorf("Parse() - reading: %w", err)
+	}
+	if err := finish(); err != nil {
+		return Journal{}, err
+	}
+	return j, nil
+}
+
+// report whether a line is a comment; top-level comments may also start
+// with #, %, | or *
+func isComment(line string) bool {
+	return strings.ContainsRune(";#%|*", rune(line[0]))
+}
+
+func isSkipped(word string) bool {
+	for _, s := range skipped {
+		if word == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parse the first line of a transaction, e.g.
+// "2021-04-01=2021-04-03 * (42) Corner shop  ; weekly shop"
+func parseHeader(line string) (*transaction, error) {
+	t := &transaction{status: ledger.Pending}
+	text := line
+	comment := ""
+	if i := strings.Index(text, ";"); i >= 0 {
+		text, comment = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	fields := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	var err error
+	if t.date, err = parseDate(fields[0]); err != nil {
+		return nil, err
+	}
+	rest := ""
+	if len(fields) > 1 {
+		rest = strings.TrimSpace(fields[1])
+	}
+	if rest != "" {
+		if status, ok := statuses[rest[:1]]; ok {
+			t.status = status
+			rest = strings.TrimSpace(rest[1:])
+		}
+	}
+	if strings.HasPrefix(rest, "(") {
+		if i := strings.Index(rest, ")"); i >= 0 {
+			rest = strings.TrimSpace(rest[i+1:])
+		}
+	}
+	t.payee = rest
+	if comment != "" {
+		t.memo = append(t.memo, comment)
+	}
+	return t, nil
+}
+
+// parse a date such as 2021-04-01, 2021/04/01 or 2021.04.01, leaving out a
+// secondary date after =
+func parseDate(s string) (time.Time, error) {
+	if i := strings.Index(s, "="); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.NewReplacer("/", "-", ".", "-").Replace(s)
+	d, err := time.Parse("2006-1-2", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing date %q, which needs a year: %w", s, err)
+	}
+	return d, nil
+}
+
+// add a comment line before the first posting to the tags if it only holds
+// tags, or else to the memo
+func (t *transaction) comment(text string) {
+	if tags, ok := parseTags(text); ok {
+		t.tags = append(t.tags, tags...)
+	} else {
+		t.memo = append(t.memo, text)
+	}
+}
+
+// parse tags written the ledger-cli way, ":food:home:", or the hledger way,
+// "food:, trip:paris"; a tag with a value keeps it, e.g. "trip:paris"
+func parseTags(text string) ([]string, bool) {
+	var tags []string
+	if len(text) > 1 && strings.HasPrefix(text, ":") && strings.HasSuffix(text, ":") && !strings.ContainsAny(text, " \t") {
+		for _, tag := range strings.Split(text[1:len(text)-1], ":") {
+			if tag == "" {
+				return nil, false
+			}
+			tags = append(tags, tag)
+		}
+		return tags, true
+	}
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		i := strings.Index(part, ":")
+		if i <= 0 || strings.ContainsAny(part[:i], " \t") {
+			return nil, false
+		}
+		if value := strings.TrimSpace(part[i+1:]); value != "" {
+			tags = append(tags, part[:i]+":"+value)
+		} else {
+			tags = append(tags, part[:i])
+		}
+	}
+	return tags, true
+}
+
+// add an indented line: a comment or a posting such as
+// "assets:checking  -$12.34 = $100.00  ; posting comment"
+func (t *transaction) add(line string) error {
+	if strings.HasPrefix(line, ";") {
+		// comments below a posting belong to it, and are dropped
+		if len(t.postings) == 0 {
+			t.comment(strings.TrimSpace(line[1:]))
+		}
+		return nil
+	}
+	if i := strings.Index(line, ";"); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	if strings.HasPrefix(line, "* ") || strings.HasPrefix(line, "! ") {
+		line = strings.TrimSpace(line[2:])
+	}
+	if strings.HasPrefix(line, "(") || strings.HasPrefix(line, "[") {
+		return fmt.Errorf("virtual posting %q is not supported", line)
+	}
+	// the bucket ends at a tab or at two spaces
+	end := len(line)
+	if i := strings.Index(line, "\t"); i >= 0 {
+		end = i
+	}
+	if i := strings.Index(line, "  "); i >= 0 && i < end {
+		end = i
+	}
+	p := posting{bucket: strings.TrimSpace(line[:end])}
+	rest := strings.TrimSpace(line[end:])
+	if strings.Contains(rest, "@") {
+		return fmt.Errorf("posting %q has a cost, which is not supported", line)
+	}
+	if i := strings.Index(rest, "="); i >= 0 {
+		balance := strings.TrimLeft(rest[i:], "=*")
+		rest = strings.TrimSpace(rest[:i])
+		var err error
+		if p.balance, p.balanceIn, err = parseAmount(balance); err != nil {
+			return err
+		}
+		p.asserted = true
+	}
+	if rest == "" {
+		p.elided = true
+	} else {
+		var err error
+		if p.amount, p.commodity, err = parseAmount(rest); err != nil {
+			return err
+		}
+	}
+	t.postings = append(t.postings, p)
+	return nil
+}
+
+// balance the postings, then turn them into entries and balance assertions.
+// A transaction of a single empty posting only asserts a balance.
+func (t *transaction) resolve() ([]ledger.Entry, []ledger.Assertion, error) {
+	if len(t.postings) == 0 {
+		return nil, nil, fmt.Errorf("transaction has no postings")
+	}
+	commodity := ""
+	sum := 0
+	elided := -1
+	for i, p := range t.postings {
+		if p.elided {
+			if elided >= 0 {
+				return nil, nil, fmt.Errorf("only one posting may leave out its amount")
+			}
+			elided = i
+			continue
+		}
+		if p.amount == 0 {
+			continue
+		}
+		if commodity != "" && p.commodity != commodity {
+			return nil, nil, fmt.Errorf("postings in both %s and %s are not supported", commodity, p.commodity)
+		}
+		commodity = p.commodity
+		sum += p.amount
+	}
+	// postings that all come to nothing keep the commodity they were written in
+	for _, p := range t.postings {
+		if commodity == "" && !p.elided {
+			commodity = p.commodity
+		}
+	}
+	if commodity == "" {
+		commodity = ledger.DefaultCommodity
+	}
+	if elided >= 0 {
+		t.postings[elided].amount = -sum
+	} else if sum != 0 {
+		return nil, nil, fmt.Errorf("postings must sum to zero, got %d", sum)
+	}
+	var assertions []ledger.Assertion
+	for _, p := range t.postings {
+		if p.asserted {
+			assertions = append(assertions, ledger.Assertion{Bucket: p.bucket, Date: t.date, Amount: p.balance, Commodity: p.balanceIn})
+		}
+	}
+	split := ledger.Transaction{
+		EntryDate: t.date,
+		Commodity: commodity,
+		Status:    t.status,
+		Payee:     t.payee,
+		Memo:      strings.Join(t.memo, " "),
+		Tags:      t.tags,
+	}
+	for _, p := range t.postings {
+		split.Postings = append(split.Postings, ledger.Posting{Bucket: p.bucket, Amount: p.amount})
+	}
+	switch len(split.Postings) {
+	case 1:
+		if split.Postings[0].Amount != 0 {
+			return nil, nil, fmt.Errorf("a single posting must be empty, got %d", split.Postings[0].Amount)
+		}
+		return nil, assertions, nil
+	case 2:
+		// money flows out of the first posting unless it is the one gaining
+		from, to := split.Postings[0], split.Postings[1]
+		if from.Amount > 0 {
+			from, to = to, from
+		}
+		return []ledger.Entry{{
+			Source:      from.Bucket,
+			Destination: to.Bucket,
+			EntryDate:   t.date,
+			Amount:      to.Amount,
+			Commodity:   commodity,
+			Status:      t.status,
+			Payee:       split.Payee,
+			Memo:        split.Memo,
+			Tags:        split.Tags,
+		}}, assertions, nil
+	}
+	return split.Entries(), assertions, nil
+}
+
+// parse a price directive, e.g. "P 2021-04-01 EUR $1.08"
+func parsePrice(line string) (ledger.Price, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return ledger.Price{}, fmt.Errorf("price %q needs a date, a commodity and an amount", line)
+	}
+	// a time of day may follow the date
+	if strings.Contains(fields[2], ":") && len(fields) > 4 {
+		fields = append(fields[:2], fields[3:]...)
+	}
+	date, err := parseDate(fields[1])
+	if err != nil {
+		return ledger.Price{}, err
+	}
+	number, quote, err := splitAmount(strings.Join(fields[3:], " "))
+	if err != nil {
+		return ledger.Price{}, err
+	}
+	rate, err := strconv.ParseFloat(strings.Replace(number, ",", "", -1), 64)
+	if err != nil {
+		return ledger.Price{}, fmt.Errorf("parsing price %q: %w", line, err)
+	}
+	return ledger.Price{Commodity: commodity(fields[2]), Quote: commodity(quote), Date: date, Rate: rate}, nil
+}
+
+// parse an amount such as $1,234.56, -$12, $-12, 12.50 EUR or EUR -12.50
+// into hundredths of its commodity
+func parseAmount(s string) (int, string, error) {
+	number, symbol, err := splitAmount(s)
+	if err != nil {
+		return 0, "", err
+	}
+	cents, err := usd.ParseCents(number)
+	if err != nil {
+		return 0, "", err
+	}
+	return cents, commodity(symbol), nil
+}
+
+// split an amount into its signed number and its commodity symbol or code,
+// which may come before or after the number
+func splitAmount(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		sign, s = s[:1], strings.TrimSpace(s[1:])
+	}
+	start := strings.IndexAny(s, "0123456789")
+	if start < 0 {
+		return "", "", fmt.Errorf("amount %q has no number", s)
+	}
+	end := start
+	for end < len(s) && strings.ContainsRune("0123456789.,", rune(s[end])) {
+		end++
+	}
+	prefix, suffix := strings.TrimSpace(s[:start]), strings.TrimSpace(s[end:])
+	if strings.HasSuffix(prefix, "-") {
+		sign = "-"
+		prefix = strings.TrimSpace(strings.TrimSuffix(prefix, "-"))
+	}
+	if prefix != "" && suffix != "" {
+		return "", "", fmt.Errorf("amount %q has a commodity on both sides", s)
+	}
+	return sign + s[start:end], prefix + suffix, nil
+}
+
+// get the commodity code for a symbol or code; no commodity at all is the
+// default
+func commodity(symbol string) string {
+	if code, ok := symbols[symbol]; ok {
+		return code
+	}
+	if symbol == "" {
+		return ledger.DefaultCommodity
+	}
+	return strings.ToUpper(strings.Trim(symbol, `"`))
+}
@@ -0,0 +1,145 @@
+package journal
+
+import (
+	"fmt"
+	"io"
+	"ledger/pkg/ledger"
+	"ledger/pkg/usd"
+	"ledger/pkg/utils"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// marks written for each status; pending entries go unmarked, and the
+// journals have no mark for reconciled entries beyond cleared
+var marks = map[ledger.Status]string{
+	ledger.Cleared:    "*",
+	ledger.Reconciled: "*",
+}
+
+// write a journal with one transaction for each entry or split transaction,
+// in date order. Prices come before the transactions on their date, and each
+// balance assertion after them, as a transaction of its own.
+func Write(w io.Writer, j Journal) error {
+	type block struct {
+		day  string
+		rank int
+		text string
+	}
+	var blocks []block
+	for _, p := range j.Prices {
+		blocks = append(blocks, block{utils.FormatDate(p.Date), 0, formatPrice(p)})
+	}
+	splits := map[int][]ledger.Entry{}
+	for _, e := range j.Entries {
+		if e.TransactionID != 0 {
+			splits[e.TransactionID] = append(splits[e.TransactionID], e)
+		}
+	}
+	for _, e := range j.Entries {
+		group := []ledger.Entry{e}
+		if e.TransactionID != 0 {
+			var ok bool
+			if group, ok = splits[e.TransactionID]; !ok {
+				continue
+			}
+			delete(splits, e.TransactionID)
+		}
+		text, err := formatTransaction(ledger.EntriesToTransaction(group))
+		if err != nil {
+			return fmt.Errorf("Write() - %w", err)
+		}
+		blocks = append(blocks, block{utils.FormatDate(group[0].EntryDate), 1, text})
+	}
+	for _, a := range j.Assertions {
+		blocks = append(blocks, block{utils.FormatDate(a.Date), 2, formatAssertion(a)})
+	}
+	sort.SliceStable(blocks, func(i, k int) bool {
+		if blocks[i].day != blocks[k].day {
+			return blocks[i].day < blocks[k].day
+		}
+		return blocks[i].rank < blocks[k].rank
+	})
+	for i, b := range blocks {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return fmt.Errorf("Write() - %w", err)
+			}
+		}
+		if _, err := io.WriteString(w, b.text); err != nil {
+			return fmt.Errorf("Write() - %w", err)
+		}
+	}
+	return nil
+}
+
+// format a transaction, e.g.
+//
+//	2021-04-01 ! Corner shop  ; weekly shop
+//	    ; :food:
+//	    assets:checking  -$12.34
+//	    expenses:food  $12.34
+func formatTransaction(t ledger.Transaction) (string, error) {
+	// a semicolon would end the payee and start the memo
+	if strings.Contains(t.Payee, ";") {
+		return "", fmt.Errorf("payee %q of the %s transaction holds a semicolon", t.Payee, utils.FormatDate(t.EntryDate))
+	}
+	var b strings.Builder
+	b.WriteString(utils.FormatDate(t.EntryDate))
+	if mark := marks[t.Status]; mark != "" {
+		b.WriteString(" " + mark)
+	}
+	// an empty code keeps a payee such as *Star from being read as a mark
+	// or a code
+	if t.Payee != "" && strings.ContainsRune("*!(", rune(t.Payee[0])) {
+		b.WriteString(" ()")
+	}
+	if t.Payee != "" {
+		b.WriteString(" " + t.Payee)
+	}
+	if t.Memo != "" {
+		b.WriteString("  ; " + t.Memo)
+	}
+	b.WriteString("\n")
+	if len(t.Tags) > 0 {
+		b.WriteString("    ; " + formatTags(t.Tags) + "\n")
+	}
+	for _, p := range t.Postings {
+		fmt.Fprintf(&b, "    %s  %s\n", p.Bucket, usd.Format(p.Amount, t.Commodity))
+	}
+	return b.String(), nil
+}
+
+// format tags the ledger-cli way, ":food:home:", unless a tag holds a colon,
+// e.g. trip:paris, which only the hledger way keeps, "food:, trip:paris"
+func formatTags(tags []string) string {
+	for _, tag := range tags {
+		if strings.Contains(tag, ":") {
+			var parts []string
+			for _, tag := range tags {
+				if !strings.Contains(tag, ":") {
+					tag += ":"
+				}
+				parts = append(parts, tag)
+			}
+			return strings.Join(parts, ", ")
+		}
+	}
+	return ":" + strings.Join(tags, ":") + ":"
+}
+
+// format a balance assertion as a transaction with a single empty posting
+func formatAssertion(a ledger.Assertion) string {
+	return fmt.Sprintf("%s balance assertion\n    %s  0 = %s\n",
+		utils.FormatDate(a.Date), a.Bucket, usd.Format(a.Amount, a.Commodity))
+}
+
+// format a price directive, e.g. "P 2021-04-01 EUR $1.08"
+func formatPrice(p ledger.Price) string {
+	rate := strconv.FormatFloat(p.Rate, 'f', -1, 64)
+	if p.Quote == ledger.DefaultCommodity {
+		return fmt.Sprintf("P %s %s $%s\n", utils.FormatDate(p.Date), p.Commodity, rate)
+	}
+	return fmt.Sprintf("P %s %s %s %s\n", utils.FormatDate(p.Date), p.Commodity, rate, p.Quote)
+}
@@ -0,0 +1,137 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Assertion records that a bucket must hold Amount at the end of Date. Like
+// SummarizeBucket, Amount is a stored balance, so money owed on a liability
+// is negative, and a parent bucket includes its children. Only holdings in
+// Commodity count towards the balance.
+type Assertion struct {
+	ID        int
+	Bucket    string
+	Date      time.Time
+	Amount    int
+	Commodity string // DefaultCommodity when left empty
+}
+
+// AssertionFailure is an assertion along with the balance actually found
+type AssertionFailure struct {
+	Assertion
+	Actual     int
+	Difference int // Actual less the asserted Amount
+}
+
+func (f AssertionFailure) String() string {
+	s := fmt.Sprintf("%s on %s: want %d, got %d (off by %d)",
+		f.Bucket, f.Date.Format("2006-01-02"), f.Amount, f.Actual, f.Difference)
+	if c := normalizeCommodity(f.Commodity); c != DefaultCommodity {
+		s += " " + c
+	}
+	return s
+}
+
+// record a balance assertion and get its id
+func InsertAssertion(tx *sql.Tx, a Assertion) (int, error) {
+	if a.Bucket == "" {
+		return 0, fmt.Errorf("InsertAssertion() - assertion is missing a bucket")
+	}
+	a.Commodity = normalizeCommodity(a.Commodity)
+	if err := validateCommodity(a.Commodity); err != nil {
+		return 0, fmt.Errorf("InsertAssertion() - %w", err)
+	}
+	q := `INSERT INTO assertions (bucket, asserted_at, amount, commodity)
+		VALUES ($1, date($2), $3, $4);`
+	res, err := tx.Exec(q, a.Bucket, a.Date.Format("2006-01-02"), a.Amount, a.Commodity)
+	if err != nil {
+		return 0, fmt.Errorf("InsertAssertion() - executing the insert: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("InsertAssertion() - getting assertion id: %w", err)
+	}
+	return int(id), nil
+}
+
+// get every balance assertion, ordered by date
+func GetAssertions(tx *sql.Tx) ([]Assertion, error) {
+	q := `SELECT id, bucket, asserted_at, amount, commodity FROM assertions
+		ORDER BY asserted_at, bucket;`
+	rows, err := tx.Query(q)
+	if err != nil {
+		return nil, fmt.Errorf("GetAssertions() - querying assertions: %w", err)
+	}
+	defer rows.Close()
+	var assertions []Assertion
+	for rows.Next() {
+		var a Assertion
+		var date string
+		if err := rows.Scan(&a.ID, &a.Bucket, &date, &a.Amount, &a.Commodity); err != nil {
+			return nil, fmt.Errorf("GetAssertions() - scanning assertion: %w", err)
+		}
+		if a.Date, err = utils.ParseDate(date); err != nil {
+			return nil, fmt.Errorf("GetAssertions() - %w", err)
+		}
+		assertions = append(assertions, a)
+	}
+	return assertions, nil
+}
+
+// remove a balance assertion
+func DeleteAssertion(tx *sql.Tx, id int) error {
+	q := `DELETE FROM assertions WHERE id = $1;`
+	res, err := tx.Exec(q, id)
+	if err != nil {
+		return fmt.Errorf("DeleteAssertion() - executing the delete: %w", err)
+	}
+	if err := checkAffected(res, "assertion", id); err != nil {
+		return fmt.Errorf("DeleteAssertion() - %w", err)
+	}
+	return nil
+}
+
+// check every balance assertion against the ledger, returning those that
+// fail
+func CheckAssertions(tx *sql.Tx) ([]AssertionFailure, error) {
+	assertions, err := GetAssertions(tx)
+	if err != nil {
+		return nil, fmt.Errorf("CheckAssertions() - %w", err)
+	}
+	var failures []AssertionFailure
+	for _, a := range assertions {
+		holdings, err := SummarizeHoldings(tx, a.Bucket, utils.BigBang, a.Date)
+		if err != nil {
+			return nil, fmt.Errorf("CheckAssertions() - %w", err)
+		}
+		actual := holdings[a.Commodity]
+		if actual != a.Amount {
+			failures = append(failures, AssertionFailure{a, actual, actual - a.Amount})
+		}
+	}
+	return failures, nil
+}
+
+// parse a balance assertion from a form
+func PrepareAssertionForInsert(r *http.Request) (Assertion, error) {
+	r.ParseForm()
+	date, err := utils.ParseDate(r.PostForm.Get("asserted_at"))
+	if err != nil {
+		return Assertion{}, fmt.Errorf("Could not parse asserted_at (%v)", err)
+	}
+	amount, err := strconv.Atoi(r.PostForm.Get("amount"))
+	if err != nil {
+		return Assertion{}, fmt.Errorf("Could not convert amount field to int (%v)", err)
+	}
+	return Assertion{
+		Bucket:    r.PostForm.Get("bucket"),
+		Date:      date,
+		Amount:    amount,
+		Commodity: r.PostForm.Get("commodity"),
+	}, nil
+}
@@ -0,0 +1,47 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestCheckAssertions(t *testing.T) {
+	db := testutils.Db(t)
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		for _, e := range []ledger.Entry{
+			{Source: "income", Destination: "assets:checking", EntryDate: start, Amount: 1000},
+			{Source: "assets:checking", Destination: "groceries", EntryDate: start.AddDate(0, 0, 3), Amount: 80},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		for _, a := range []ledger.Assertion{
+			{Bucket: "assets:checking", Date: start, Amount: 1000},
+			{Bucket: "assets", Date: start.AddDate(0, 0, 3), Amount: 920},
+			// the import missed a 20 cent fee
+			{Bucket: "assets:checking", Date: start.AddDate(0, 1, 0), Amount: 900},
+		} {
+			if _, err := ledger.InsertAssertion(tx, a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	var got []ledger.AssertionFailure
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		got, err = ledger.CheckAssertions(tx)
+		return err
+	})
+	want := []ledger.AssertionFailure{{
+		Assertion:  ledger.Assertion{ID: 3, Bucket: "assets:checking", Date: start.AddDate(0, 1, 0), Amount: 900, Commodity: ledger.DefaultCommodity},
+		Actual:     920,
+		Difference: 20,
+	}}
+	testutils.AssertEqual(t, want, got)
+	testutils.AssertEqual(t, "assets:checking on 2021-04-01: want 900, got 920 (off by 20)", got[0].String())
+}
@@ -0,0 +1,296 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/usd"
+	"ledger/pkg/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BucketType is the accounting type of a bucket, which sets its normal balance
+type BucketType string
+
+const (
+	Asset     BucketType = "asset"
+	Liability BucketType = "liability"
+	Income    BucketType = "income"
+	Expense   BucketType = "expense"
+	Equity    BucketType = "equity"
+)
+
+// report whether t is one of the known bucket types
+func (t BucketType) Valid() bool {
+	switch t {
+	case Asset, Liability, Income, Expense, Equity:
+		return true
+	}
+	return false
+}
+
+// get the sign that turns a stored balance into a normal balance: assets and
+// expenses grow with money flowing in, while liabilities, income and equity
+// grow with money flowing out
+func (t BucketType) Sign() int {
+	switch t {
+	case Liability, Income, Equity:
+		return -1
+	}
+	return 1
+}
+
+// Bucket is a registered bucket. A zero ClosedAt means the bucket is open.
+type Bucket struct {
+	Name        string
+	Type        BucketType
+	DisplayName string
+	OpenedAt    time.Time
+	ClosedAt    time.Time
+}
+
+// report whether entries may be dated d in this bucket
+func (b Bucket) OpenOn(d time.Time) bool {
+	day := d.Format("2006-01-02")
+	if day < b.OpenedAt.Format("2006-01-02") {
+		return false
+	}
+	return b.ClosedAt.IsZero() || day <= b.ClosedAt.Format("2006-01-02")
+}
+
+// Registry holds every registered bucket by name
+type Registry map[string]Bucket
+
+// find the registration that covers a bucket: the bucket itself or else its
+// nearest registered parent
+func (r Registry) Find(name string) (Bucket, bool) {
+	if b, ok := r[name]; ok {
+		return b, true
+	}
+	ancestors := BucketAncestors(name)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if b, ok := r[ancestors[i]]; ok {
+			return b, true
+		}
+	}
+	return Bucket{}, false
+}
+
+// the bucket type implied by the first part of a bucket's name
+var impliedTypes = map[string]BucketType{
+	"asset":       Asset,
+	"assets":      Asset,
+	"liability":   Liability,
+	"liabilities": Liability,
+	"income":      Income,
+	"expense":     Expense,
+	"expenses":    Expense,
+	"equity":      Equity,
+}
+
+// get the type implied by the first part of a bucket's name, e.g. Income for
+// income:salary, and whether the name implies one at all
+func ImpliedType(name string) (BucketType, bool) {
+	t, ok := impliedTypes[strings.ToLower(strings.Split(name, BucketSeparator)[0])]
+	return t, ok
+}
+
+// get the type of a bucket. Unregistered buckets take the type implied by
+// their name, or are treated as assets when it implies none.
+func (r Registry) Type(name string) BucketType {
+	if b, ok := r.Find(name); ok {
+		return b.Type
+	}
+	if t, ok := ImpliedType(name); ok {
+		return t
+	}
+	return Asset
+}
+
+// flip stored balances into normal balances, so that e.g. a credit card that
+// was spent from shows a positive amount owed
+func (r Registry) Normalize(balances map[string]int) map[string]int {
+	output := map[string]int{}
+	for b, v := range balances {
+		output[b] = r.Type(b).Sign() * v
+	}
+	return output
+}
+
+// Normalize each balance in a series
+func (r Registry) NormalizeSeries(series []map[string]int) []map[string]int {
+	output := []map[string]int{}
+	for _, balances := range series {
+		output = append(output, r.Normalize(balances))
+	}
+	return output
+}
+
+// describe a normal balance in words, e.g. "owed $500.00" for a liability
+func (r Registry) Describe(name string, normal int) string {
+	return r.DescribeIn(name, normal, DefaultCommodity)
+}
+
+// describe a normal balance counted in commodity in words, e.g.
+// "owed 500.00 EUR" for a liability
+func (r Registry) DescribeIn(name string, normal int, commodity string) string {
+	amount := usd.Format(normal, normalizeCommodity(commodity))
+	switch r.Type(name) {
+	case Liability:
+		if normal < 0 {
+			return "overpaid " + usd.Format(-normal, normalizeCommodity(commodity))
+		}
+		return "owed " + amount
+	case Income:
+		return "earned " + amount
+	case Expense:
+		return "spent " + amount
+	}
+	return amount
+}
+
+// fill in the description of every node in a tree, and use display names
+// as labels for registered buckets
+func (r Registry) Annotate(nodes []*BucketNode) {
+	r.AnnotateIn(nodes, DefaultCommodity)
+}
+
+// Annotate a tree whose balances are counted in commodity
+func (r Registry) AnnotateIn(nodes []*BucketNode, commodity string) {
+	for _, n := range nodes {
+		if b, ok := r[n.Name]; ok && b.DisplayName != "" {
+			n.Label = b.DisplayName
+		}
+		n.Description = r.DescribeIn(n.Name, n.Balance, commodity)
+		r.AnnotateIn(n.Children, commodity)
+	}
+}
+
+// register a bucket
+func RegisterBucket(tx *sql.Tx, b Bucket) error {
+	if b.Name == "" {
+		return fmt.Errorf("RegisterBucket() - bucket is missing a name")
+	}
+	if !b.Type.Valid() {
+		return fmt.Errorf("RegisterBucket() - unknown bucket type %q", b.Type)
+	}
+	q := `INSERT INTO buckets
+		(name, type, display_name, opened_at, closed_at)
+		VALUES ($1, $2, $3, date($4), NULLIF($5, ''));`
+	closed := ""
+	if !b.ClosedAt.IsZero() {
+		closed = b.ClosedAt.Format("2006-01-02")
+	}
+	_, err := tx.Exec(q, b.Name, b.Type, b.DisplayName, b.OpenedAt.Format("2006-01-02"), closed)
+	if err != nil {
+		return fmt.Errorf("RegisterBucket() - executing the insert: %w", err)
+	}
+	return nil
+}
+
+// close a registered bucket; entries dated after closedAt will be rejected
+func CloseBucket(tx *sql.Tx, name string, closedAt time.Time) error {
+	q := `UPDATE buckets SET closed_at = date($1) WHERE name = $2;`
+	res, err := tx.Exec(q, closedAt.Format("2006-01-02"), name)
+	if err != nil {
+		return fmt.Errorf("CloseBucket() - executing the update: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("CloseBucket() - checking rows affected: %w", err)
+	}
+	if n != 1 {
+		return fmt.Errorf("CloseBucket() - no registered bucket named %s", name)
+	}
+	return nil
+}
+
+// get every registered bucket
+func GetRegistry(tx *sql.Tx) (Registry, error) {
+	q := `SELECT name, type, display_name, opened_at, COALESCE(closed_at, '')
+		FROM buckets ORDER BY name;`
+	rows, err := tx.Query(q)
+	if err != nil {
+		return nil, fmt.Errorf("GetRegistry() - querying buckets: %w", err)
+	}
+	defer rows.Close()
+	registry := Registry{}
+	for rows.Next() {
+		var b Bucket
+		var opened, closed string
+		if err := rows.Scan(&b.Name, &b.Type, &b.DisplayName, &opened, &closed); err != nil {
+			return nil, err
+		}
+		if b.OpenedAt, err = utils.ParseDate(opened); err != nil {
+			return nil, err
+		}
+		if closed != "" {
+			if b.ClosedAt, err = utils.ParseDate(closed); err != nil {
+				return nil, err
+			}
+		}
+		registry[b.Name] = b
+	}
+	return registry, nil
+}
+
+// check that an entry has a known status and a well-formed commodity, that
+// it is not dated in a closed period unless it closes one, and that both of
+// its buckets are registered and open on its date. Until the first bucket is
+// registered, any bucket is accepted.
+func validateEntry(tx *sql.Tx, e Entry) error {
+	if !e.Status.Valid() {
+		return fmt.Errorf("unknown status %q", e.Status)
+	}
+	if err := validateCommodity(e.Commodity); err != nil {
+		return err
+	}
+	if e.ClosingID == 0 {
+		if err := checkOpen(tx, utils.FormatDate(e.EntryDate)); err != nil {
+			return err
+		}
+	}
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return err
+	}
+	return registry.validate(e)
+}
+
+func (r Registry) validate(e Entry) error {
+	if len(r) == 0 {
+		return nil
+	}
+	for _, name := range []string{e.Source, e.Destination} {
+		b, ok := r.Find(name)
+		if !ok {
+			return fmt.Errorf("bucket %s is not registered", name)
+		}
+		if !b.OpenOn(e.EntryDate) {
+			return fmt.Errorf("bucket %s is not open on %s", name, e.EntryDate.Format("2006-01-02"))
+		}
+	}
+	return nil
+}
+
+// parse a bucket registration from a form
+func PrepareBucketForInsert(r *http.Request) (Bucket, error) {
+	r.ParseForm()
+	opened, err := utils.ParseDate(r.PostForm.Get("opened_at"))
+	if err != nil {
+		return Bucket{}, fmt.Errorf("Could not parse opened_at (%v)", err)
+	}
+	b := Bucket{
+		Name:        r.PostForm.Get("name"),
+		Type:        BucketType(r.PostForm.Get("type")),
+		DisplayName: r.PostForm.Get("display_name"),
+		OpenedAt:    opened,
+	}
+	if closed := r.PostForm.Get("closed_at"); closed != "" {
+		if b.ClosedAt, err = utils.ParseDate(closed); err != nil {
+			return Bucket{}, fmt.Errorf("Could not parse closed_at (%v)", err)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,101 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestRegisterBucket(t *testing.T) {
+	db := testutils.Db(t)
+	opened := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		for _, b := range []ledger.Bucket{
+			{Name: "assets", Type: ledger.Asset, OpenedAt: opened},
+			{Name: "liabilities:visa", Type: ledger.Liability, DisplayName: "Visa", OpenedAt: opened},
+			{Name: "expenses", Type: ledger.Expense, OpenedAt: opened},
+		} {
+			if err := ledger.RegisterBucket(tx, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// insert entries that should fail, each in a tx that is rolled back
+	reject := func(t *testing.T, e ledger.Entry) {
+		t.Helper()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("starting tx: %v", err)
+		}
+		defer tx.Rollback()
+		if err := ledger.InsertEntry(tx, e); err == nil {
+			t.Fatalf("want error inserting %v, got nil", e)
+		}
+	}
+	t.Run("unknown bucket type",
+		func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			defer tx.Rollback()
+			err = ledger.RegisterBucket(tx, ledger.Bucket{Name: "x", Type: "stuff", OpenedAt: opened})
+			if err == nil {
+				t.Fatalf("want error for unknown type, got nil")
+			}
+		})
+	t.Run("entries must use registered buckets",
+		func(t *testing.T) {
+			reject(t, ledger.Entry{Source: "liabilities:visa", Destination: "vacation", EntryDate: opened, Amount: 100})
+		})
+	t.Run("entries must fall while a bucket is open",
+		func(t *testing.T) {
+			reject(t, ledger.Entry{Source: "liabilities:visa", Destination: "expenses", EntryDate: opened.AddDate(0, 0, -1), Amount: 100})
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.CloseBucket(tx, "liabilities:visa", opened.AddDate(0, 1, 0))
+			})
+			reject(t, ledger.Entry{Source: "liabilities:visa", Destination: "expenses", EntryDate: opened.AddDate(0, 1, 1), Amount: 100})
+		})
+	t.Run("children of registered buckets are accepted",
+		func(t *testing.T) {
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.InsertEntry(tx, ledger.Entry{
+					Source:      "liabilities:visa",
+					Destination: "expenses:food",
+					EntryDate:   opened,
+					Amount:      50000,
+				})
+			})
+		})
+	t.Run("registered buckets are listed without entries",
+		func(t *testing.T) {
+			want := []string{"assets", "expenses", "expenses:food", "liabilities:visa"}
+			var got []string
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.GetBuckets(tx)
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+		})
+	t.Run("liabilities show as owed",
+		func(t *testing.T) {
+			var registry ledger.Registry
+			var balances map[string]int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				registry, err = ledger.GetRegistry(tx)
+				if err != nil {
+					return err
+				}
+				balances, err = ledger.SummarizeBalance(tx, []string{"liabilities:visa", "expenses:food"}, testutils.BigBang, opened)
+				return err
+			})
+			want := map[string]int{"liabilities:visa": 50000, "expenses:food": 50000}
+			got := registry.Normalize(balances)
+			testutils.AssertEqual(t, want, got)
+			testutils.AssertEqual(t, "owed $500.00", registry.Describe("liabilities:visa", got["liabilities:visa"]))
+			testutils.AssertEqual(t, "Visa", registry["liabilities:visa"].DisplayName)
+		})
+}
@@ -0,0 +1,366 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/audit"
+	"ledger/pkg/utils"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Closing is a closed period. Every entry dated through ClosedThrough is
+// read-only, income and expense buckets were emptied into Equity on that
+// date, and the balance of every bucket at the end of it is kept as its
+// opening balance for what follows.
+type Closing struct {
+	ID              int
+	ClosedThrough   time.Time
+	Equity          string
+	ClosedAt        time.Time
+	OpeningBalances map[string]Holdings
+}
+
+// close every period through the given date, posting closing entries that
+// empty each income and expense bucket into equity, one per commodity, and
+// recording the opening balance of every bucket. Periods are closed in order, so through
+// must come after the last close.
+func ClosePeriod(tx *sql.Tx, through time.Time, equity string) (Closing, error) {
+	var c Closing
+	err := audit.ChangeSet(tx, func() (err error) {
+		c, err = closePeriod(tx, through, equity)
+		return err
+	})
+	return c, err
+}
+
+// see ClosePeriod
+func closePeriod(tx *sql.Tx, through time.Time, equity string) (Closing, error) {
+	if equity == "" {
+		return Closing{}, fmt.Errorf("ClosePeriod() - missing an equity bucket")
+	}
+	last, closed, err := latestClosing(tx)
+	if err != nil {
+		return Closing{}, fmt.Errorf("ClosePeriod() - %w", err)
+	}
+	if closed && dayKey(through) <= dayKey(last.ClosedThrough) {
+		return Closing{}, fmt.Errorf("ClosePeriod() - already closed through %s", dayKey(last.ClosedThrough))
+	}
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return Closing{}, fmt.Errorf("ClosePeriod() - %w", err)
+	}
+	if len(registry) > 0 && registry.Type(equity) != Equity {
+		return Closing{}, fmt.Errorf("ClosePeriod() - %s is not an equity bucket", equity)
+	}
+	c := Closing{ClosedThrough: through, Equity: equity, ClosedAt: time.Now().UTC().Truncate(time.Second)}
+	q := `INSERT INTO closings (closed_through, equity, closed_at) VALUES ($1, $2, $3);`
+	res, err := tx.Exec(q, utils.FormatDate(through), equity, c.ClosedAt.Format(time.RFC3339))
+	if err != nil {
+		return Closing{}, fmt.Errorf("ClosePeriod() - executing the insert: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Closing{}, fmt.Errorf("ClosePeriod() - getting closing id: %w", err)
+	}
+	c.ID = int(id)
+	balances, err := bucketChanges(tx, utils.BigBang, through, true)
+	if err != nil {
+		return Closing{}, fmt.Errorf("ClosePeriod() - %w", err)
+	}
+	names := []string{}
+	for b := range balances {
+		names = append(names, b)
+	}
+	sort.Strings(names)
+	if balances[equity] == nil {
+		balances[equity] = Holdings{}
+	}
+	for _, b := range names {
+		if t := registry.Type(b); (t != Income && t != Expense) || b == equity {
+			continue
+		}
+		for _, commodity := range sortedKeys(balances[b]) {
+			amount := balances[b][commodity]
+			e := Entry{
+				Source:      b,
+				Destination: equity,
+				EntryDate:   through,
+				Amount:      amount,
+				Commodity:   commodity,
+				Memo:        fmt.Sprintf("closing entry through %s", dayKey(through)),
+				ClosingID:   c.ID,
+			}
+			if amount < 0 {
+				e.Source, e.Destination, e.Amount = equity, b, -amount
+			}
+			if err := InsertEntry(tx, e); err != nil {
+				return Closing{}, fmt.Errorf("ClosePeriod() - closing %s: %w", b, err)
+			}
+			balances[b].add(commodity, -amount)
+			balances[equity].add(commodity, amount)
+		}
+	}
+	// roll the balances up into parents, as SummarizeBucket would
+	c.OpeningBalances = map[string]Holdings{}
+	for b, h := range balances {
+		for _, name := range append(BucketAncestors(b), b) {
+			if c.OpeningBalances[name] == nil {
+				c.OpeningBalances[name] = Holdings{}
+			}
+			c.OpeningBalances[name].addAll(h)
+		}
+	}
+	q = `INSERT INTO opening_balances (closing_id, bucket, commodity, amount) VALUES ($1, $2, $3, $4);`
+	for b, h := range c.OpeningBalances {
+		if len(h) == 0 {
+			delete(c.OpeningBalances, b)
+		}
+		for commodity, amount := range h {
+			if _, err := tx.Exec(q, c.ID, b, commodity, amount); err != nil {
+				return Closing{}, fmt.Errorf("ClosePeriod() - recording opening balances: %w", err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// reopen the last closed period, removing its closing entries and opening
+// balances so that its entries may be edited again
+func ReopenPeriod(tx *sql.Tx) (Closing, error) {
+	var c Closing
+	err := audit.ChangeSet(tx, func() (err error) {
+		c, err = reopenPeriod(tx)
+		return err
+	})
+	return c, err
+}
+
+// see ReopenPeriod
+func reopenPeriod(tx *sql.Tx) (Closing, error) {
+	c, closed, err := latestClosing(tx)
+	if err != nil {
+		return Closing{}, fmt.Errorf("ReopenPeriod() - %w", err)
+	}
+	if !closed {
+		return Closing{}, fmt.Errorf("ReopenPeriod() - no period is closed")
+	}
+	if _, err := tx.Exec(`DELETE FROM opening_balances WHERE closing_id = $1;`, c.ID); err != nil {
+		return Closing{}, fmt.Errorf("ReopenPeriod() - deleting opening balances: %w", err)
+	}
+	// lift the lock before removing the closing entries, which are dated in it
+	if _, err := tx.Exec(`DELETE FROM closings WHERE id = $1;`, c.ID); err != nil {
+		return Closing{}, fmt.Errorf("ReopenPeriod() - deleting closing: %w", err)
+	}
+	rows, err := tx.Query(`SELECT id FROM entries WHERE closing_id = $1;`, c.ID)
+	if err != nil {
+		return Closing{}, fmt.Errorf("ReopenPeriod() - querying closing entries: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return Closing{}, fmt.Errorf("ReopenPeriod() - scanning closing entry: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		before, err := audit.Snapshot(tx, "entries", id)
+		if err != nil {
+			return Closing{}, fmt.Errorf("ReopenPeriod() - %w", err)
+		}
+		if err := utils.DeleteTags(tx, "entry_id", int64(id)); err != nil {
+			return Closing{}, fmt.Errorf("ReopenPeriod() - %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM entries WHERE id = $1;`, id); err != nil {
+			return Closing{}, fmt.Errorf("ReopenPeriod() - deleting closing entry: %w", err)
+		}
+		if err := audit.Deleted(tx, "entries", id, before); err != nil {
+			return Closing{}, fmt.Errorf("ReopenPeriod() - %w", err)
+		}
+	}
+	return c, nil
+}
+
+// get every closed period, earliest first, with its opening balances
+func GetClosings(tx *sql.Tx) ([]Closing, error) {
+	closings, err := queryClosings(tx, `SELECT id, closed_through, equity, closed_at FROM closings ORDER BY closed_through;`)
+	if err != nil {
+		return nil, fmt.Errorf("GetClosings() - %w", err)
+	}
+	for i := range closings {
+		if closings[i].OpeningBalances, err = openingBalances(tx, closings[i].ID); err != nil {
+			return nil, fmt.Errorf("GetClosings() - %w", err)
+		}
+	}
+	return closings, nil
+}
+
+// get the last closed period, if any, without its opening balances
+func latestClosing(tx *sql.Tx) (Closing, bool, error) {
+	closings, err := queryClosings(tx, `SELECT id, closed_through, equity, closed_at FROM closings
+		ORDER BY closed_through DESC LIMIT 1;`)
+	if err != nil || len(closings) == 0 {
+		return Closing{}, false, err
+	}
+	return closings[0], true, nil
+}
+
+func queryClosings(tx *sql.Tx, q string) ([]Closing, error) {
+	rows, err := tx.Query(q)
+	if err != nil {
+		return nil, fmt.Errorf("querying closings: %w", err)
+	}
+	defer rows.Close()
+	var closings []Closing
+	for rows.Next() {
+		var c Closing
+		var through, closedAt string
+		if err := rows.Scan(&c.ID, &through, &c.Equity, &closedAt); err != nil {
+			return nil, fmt.Errorf("scanning closing: %w", err)
+		}
+		if c.ClosedThrough, err = time.ParseInLocation("2006-01-02", through, time.Local); err != nil {
+			return nil, fmt.Errorf("closing %d has unparseable date %q", c.ID, through)
+		}
+		if c.ClosedAt, err = time.Parse(time.RFC3339, closedAt); err != nil {
+			return nil, fmt.Errorf("closing %d has unparseable time %q", c.ID, closedAt)
+		}
+		closings = append(closings, c)
+	}
+	return closings, rows.Err()
+}
+
+func openingBalances(tx *sql.Tx, closingID int) (map[string]Holdings, error) {
+	rows, err := tx.Query(`SELECT bucket, commodity, amount FROM opening_balances WHERE closing_id = $1;`, closingID)
+	if err != nil {
+		return nil, fmt.Errorf("querying opening balances: %w", err)
+	}
+	defer rows.Close()
+	balances := map[string]Holdings{}
+	for rows.Next() {
+		var b, commodity string
+		var amount int
+		if err := rows.Scan(&b, &commodity, &amount); err != nil {
+			return nil, fmt.Errorf("scanning opening balance: %w", err)
+		}
+		if balances[b] == nil {
+			balances[b] = Holdings{}
+		}
+		balances[b].add(commodity, amount)
+	}
+	return balances, rows.Err()
+}
+
+// return an error if day, formatted YYYY-MM-DD, falls in a closed period
+func checkOpen(tx *sql.Tx, day string) error {
+	var through sql.NullString
+	if err := tx.QueryRow(`SELECT max(closed_through) FROM closings;`).Scan(&through); err != nil {
+		return fmt.Errorf("querying closings: %w", err)
+	}
+	if through.Valid && day <= through.String {
+		return fmt.Errorf("the period through %s is closed", through.String)
+	}
+	return nil
+}
+
+// get the net amount of each commodity each bucket gained from from through
+// through, not counting its children, repeating entries included. Closing
+// entries are left out unless withClosings.
+func bucketChanges(tx *sql.Tx, from, through time.Time, withClosings bool) (map[string]Holdings, error) {
+	q := `SELECT bucket, commodity, sum(amount) FROM (
+		SELECT destination AS bucket, commodity, amount, happened_at, closing_id FROM entries
+		UNION ALL
+		SELECT source, commodity, -amount, happened_at, closing_id FROM entries
+		)
+		WHERE happened_at BETWEEN $1 AND $2 AND ($3 OR closing_id IS NULL)
+		GROUP BY bucket, commodity;`
+	rows, err := tx.Query(q, utils.FormatDate(from), utils.FormatDate(through), withClosings)
+	if err != nil {
+		return nil, fmt.Errorf("querying balances: %w", err)
+	}
+	defer rows.Close()
+	balances := map[string]Holdings{}
+	add := func(b, commodity string, amount int) {
+		if balances[b] == nil {
+			balances[b] = Holdings{}
+		}
+		balances[b].add(commodity, amount)
+	}
+	for rows.Next() {
+		var b, commodity string
+		var amount int
+		if err := rows.Scan(&b, &commodity, &amount); err != nil {
+			return nil, fmt.Errorf("scanning balance: %w", err)
+		}
+		add(b, commodity, amount)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading balances: %w", err)
+	}
+	scheduled, err := scheduledEntries(tx, from, through)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range scheduled {
+		add(e.Destination, e.Commodity, e.Amount)
+		add(e.Source, e.Commodity, -e.Amount)
+	}
+	return balances, nil
+}
+
+// return an error if replacing the repeating entry before with after would
+// change any of its occurrences in a closed period. Either may be nil, for
+// an insert or a delete.
+func checkScheduleOpen(tx *sql.Tx, before, after *Schedule) error {
+	c, closed, err := latestClosing(tx)
+	if err != nil || !closed {
+		return err
+	}
+	occurrences := func(s *Schedule) ([]Entry, error) {
+		if s == nil {
+			return nil, nil
+		}
+		dates, err := s.Occurrences(utils.BigBang, c.ClosedThrough)
+		var entries []Entry
+		for _, d := range dates {
+			entries = append(entries, Entry{Source: s.Source, Destination: s.Destination, EntryDate: d, Amount: s.Amount, Commodity: normalizeCommodity(s.Commodity)})
+		}
+		return entries, err
+	}
+	was, err := occurrences(before)
+	if err != nil {
+		return err
+	}
+	will, err := occurrences(after)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(was, will) {
+		return fmt.Errorf("occurrences through %s are in a closed period", dayKey(c.ClosedThrough))
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parse the last date of the period to close and the equity bucket to close
+// it into from a form
+func PrepareClosing(r *http.Request) (time.Time, string, error) {
+	r.ParseForm()
+	through, err := time.Parse("2006-01-02", r.PostForm.Get("closed_through"))
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("Could not parse closed_through (%v)", err)
+	}
+	return through, r.PostForm.Get("equity"), nil
+}
@@ -0,0 +1,133 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestClosePeriod(t *testing.T) {
+	db := testutils.Db(t)
+	buckets := []string{"assets", "assets:checking", "equity:retained", "expenses:food", "income:salary"}
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		for name, bt := range map[string]ledger.BucketType{
+			"assets":          ledger.Asset,
+			"income":          ledger.Income,
+			"expenses":        ledger.Expense,
+			"equity:retained": ledger.Equity,
+		} {
+			if err := ledger.RegisterBucket(tx, ledger.Bucket{Name: name, Type: bt, OpenedAt: testutils.BigBang}); err != nil {
+				return err
+			}
+		}
+		for _, e := range []ledger.Entry{
+			{Source: "income:salary", Destination: "assets:checking", EntryDate: testutils.Dec31.AddDate(0, 0, -10), Amount: 1000},
+			{Source: "assets:checking", Destination: "expenses:food", EntryDate: testutils.Dec31, Amount: 300},
+			{Source: "assets:checking", Destination: "expenses:food", EntryDate: testutils.JanTwo, Amount: 50},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	balances := func(t *testing.T, through time.Time) map[string]int {
+		t.Helper()
+		var got map[string]int
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			got, err = ledger.SummarizeBalance(tx, buckets, testutils.BigBang, through)
+			return err
+		})
+		return got
+	}
+	beforeClose := balances(t, testutils.JanTwo)
+	var c ledger.Closing
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		c, err = ledger.ClosePeriod(tx, testutils.Dec31, "equity:retained")
+		return err
+	})
+
+	t.Run("income and expenses are emptied into equity", func(t *testing.T) {
+		usd := func(amount int) ledger.Holdings { return ledger.Holdings{"USD": amount} }
+		// emptied buckets open with nothing held
+		want := map[string]ledger.Holdings{
+			"assets": usd(700), "assets:checking": usd(700), "equity": usd(-700), "equity:retained": usd(-700),
+		}
+		testutils.AssertEqual(t, want, c.OpeningBalances)
+		got := balances(t, testutils.JanTwo)
+		testutils.AssertEqual(t, beforeClose["assets:checking"], got["assets:checking"])
+		testutils.AssertEqual(t, 50, got["expenses:food"])
+		testutils.AssertEqual(t, -700, got["equity:retained"])
+	})
+	t.Run("balances over time start from the opening balances", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			series, err := ledger.SummarizeBalanceOverTime(tx, buckets, testutils.JanOne, testutils.JanTwo)
+			if err != nil {
+				return err
+			}
+			testutils.AssertEqual(t, 700, series[0]["assets:checking"])
+			testutils.AssertEqual(t, 650, series[1]["assets:checking"])
+			testutils.AssertEqual(t, 50, series[1]["expenses:food"])
+			return nil
+		})
+	})
+	t.Run("entries dated in the closed period are read-only", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("starting tx: %v", err)
+		}
+		defer tx.Rollback()
+		if err := ledger.DeleteEntry(tx, 1); err == nil {
+			t.Errorf("want error deleting a closed entry, got nil")
+		}
+		late := ledger.Entry{Source: "assets:checking", Destination: "expenses:food", EntryDate: testutils.Dec31, Amount: 5}
+		if err := ledger.InsertEntry(tx, late); err == nil {
+			t.Errorf("want error inserting into a closed period, got nil")
+		}
+		// even behind the ledger's back
+		if _, err := tx.Exec(`UPDATE entries SET amount = 1 WHERE id = 1;`); err == nil {
+			t.Errorf("want error updating a closed entry, got nil")
+		}
+		if err := ledger.DeleteEntry(tx, 3); err != nil {
+			t.Errorf("deleting an open entry: %v", err)
+		}
+		if _, err := ledger.InsertSchedule(tx, ledger.Schedule{Source: "income:salary", Destination: "assets:checking",
+			Amount: 10, StartDate: testutils.Dec31, Frequency: "monthly"}); err == nil {
+			t.Errorf("want error scheduling into a closed period, got nil")
+		}
+	})
+	t.Run("periods close in order", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("starting tx: %v", err)
+		}
+		defer tx.Rollback()
+		if _, err := ledger.ClosePeriod(tx, testutils.Dec31.AddDate(0, -1, 0), "equity:retained"); err == nil {
+			t.Errorf("want error closing an earlier period, got nil")
+		}
+	})
+	t.Run("reopening removes the closing entries", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			if _, err := ledger.ReopenPeriod(tx); err != nil {
+				return err
+			}
+			return ledger.DeleteEntry(tx, 1)
+		})
+		got := balances(t, testutils.JanTwo)
+		testutils.AssertEqual(t, 0, got["equity:retained"])
+		testutils.AssertEqual(t, 350, got["expenses:food"])
+	})
+	t.Run("closing entries of a reopened period cannot be undone back", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("starting tx: %v", err)
+		}
+		defer tx.Rollback()
+		// the delete of entry 1 is undone, then the closing entries are refused
+		if _, err := ledger.Undo(tx, 2); err == nil {
+			t.Errorf("want error restoring a closing entry without its closing, got nil")
+		}
+	})
+}
@@ -0,0 +1,379 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/utils"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCommodity is the commodity of entries that do not name one, and the
+// base that reports convert into unless asked for another
+const DefaultCommodity = "USD"
+
+// normalize a commodity code, e.g. " eur" to "EUR", with an empty code
+// meaning DefaultCommodity
+func normalizeCommodity(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return DefaultCommodity
+	}
+	return code
+}
+
+// check that a normalized commodity code is a single word of letters and
+// digits, e.g. USD or VTSAX
+func validateCommodity(code string) error {
+	for _, r := range code {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return fmt.Errorf("commodity %q must be letters and digits", code)
+		}
+	}
+	return nil
+}
+
+// Holdings is an amount of each commodity, keyed by commodity code. Amounts
+// are in the smallest unit of their commodity, e.g. cents.
+type Holdings map[string]int
+
+// add an amount of a commodity, leaving out commodities that come to zero
+func (h Holdings) add(commodity string, amount int) {
+	commodity = normalizeCommodity(commodity)
+	h[commodity] += amount
+	if h[commodity] == 0 {
+		delete(h, commodity)
+	}
+}
+
+// add every amount held in other
+func (h Holdings) addAll(other Holdings) {
+	for c, v := range other {
+		h.add(c, v)
+	}
+}
+
+// report whether every amount is held in base
+func (h Holdings) onlyIn(base string) bool {
+	for c := range h {
+		if c != base {
+			return false
+		}
+	}
+	return true
+}
+
+// Price is what one unit of Commodity was worth in Quote on Date, e.g. one
+// EUR at a Rate of 1.08 USD
+type Price struct {
+	ID        int
+	Commodity string
+	Quote     string
+	Date      time.Time
+	Rate      float64
+}
+
+// record a price and get its id. Recording a price for a pair and date that
+// already has one replaces it.
+func InsertPrice(tx *sql.Tx, p Price) (int, error) {
+	p.Commodity, p.Quote = normalizeCommodity(p.Commodity), normalizeCommodity(p.Quote)
+	for _, c := range []string{p.Commodity, p.Quote} {
+		if err := validateCommodity(c); err != nil {
+			return 0, fmt.Errorf("InsertPrice() - %w", err)
+		}
+	}
+	if p.Commodity == p.Quote {
+		return 0, fmt.Errorf("InsertPrice() - %s cannot be priced in itself", p.Commodity)
+	}
+	if !(p.Rate > 0) {
+		return 0, fmt.Errorf("InsertPrice() - rate must be positive, got %v", p.Rate)
+	}
+	q := `INSERT OR REPLACE INTO prices (commodity, quote, priced_at, rate) VALUES ($1, $2, $3, $4);`
+	res, err := tx.Exec(q, p.Commodity, p.Quote, utils.FormatDate(p.Date), p.Rate)
+	if err != nil {
+		return 0, fmt.Errorf("InsertPrice() - executing the insert: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("InsertPrice() - getting price id: %w", err)
+	}
+	return int(id), nil
+}
+
+// remove a price
+func DeletePrice(tx *sql.Tx, id int) error {
+	res, err := tx.Exec(`DELETE FROM prices WHERE id = $1;`, id)
+	if err != nil {
+		return fmt.Errorf("DeletePrice() - executing the delete: %w", err)
+	}
+	if err := checkAffected(res, "price", id); err != nil {
+		return fmt.Errorf("DeletePrice() - %w", err)
+	}
+	return nil
+}
+
+// Prices holds every recorded price, oldest first, to convert amounts
+// between commodities
+type Prices []Price
+
+// get every recorded price, oldest first
+func GetPrices(tx *sql.Tx) (Prices, error) {
+	q := `SELECT id, commodity, quote, priced_at, rate FROM prices
+		ORDER BY priced_at, commodity, quote;`
+	rows, err := tx.Query(q)
+	if err != nil {
+		return nil, fmt.Errorf("GetPrices() - querying prices: %w", err)
+	}
+	defer rows.Close()
+	var prices Prices
+	for rows.Next() {
+		var p Price
+		var date string
+		if err := rows.Scan(&p.ID, &p.Commodity, &p.Quote, &date, &p.Rate); err != nil {
+			return nil, fmt.Errorf("GetPrices() - scanning price: %w", err)
+		}
+		if p.Date, err = utils.ParseDate(date); err != nil {
+			return nil, fmt.Errorf("GetPrices() - %w", err)
+		}
+		prices = append(prices, p)
+	}
+	return prices, rows.Err()
+}
+
+// get the latest rate on or before day that converts one unit of from into
+// to, using a price of either one in the other, or else going through
+// DefaultCommodity
+func (p Prices) Rate(from, to string, day time.Time) (float64, error) {
+	from, to = normalizeCommodity(from), normalizeCommodity(to)
+	if from == to {
+		return 1, nil
+	}
+	if rate, ok := p.direct(from, to, dayKey(day)); ok {
+		return rate, nil
+	}
+	if from != DefaultCommodity && to != DefaultCommodity {
+		a, aok := p.direct(from, DefaultCommodity, dayKey(day))
+		b, bok := p.direct(DefaultCommodity, to, dayKey(day))
+		if aok && bok {
+			return a * b, nil
+		}
+	}
+	return 0, fmt.Errorf("no price of %s in %s on or before %s", from, to, dayKey(day))
+}
+
+// get the latest rate on or before day priced directly between two
+// commodities, in either direction
+func (p Prices) direct(from, to, day string) (float64, bool) {
+	for i := len(p) - 1; i >= 0; i-- {
+		if dayKey(p[i].Date) > day {
+			continue
+		}
+		switch {
+		case p[i].Commodity == from && p[i].Quote == to:
+			return p[i].Rate, true
+		case p[i].Commodity == to && p[i].Quote == from:
+			return 1 / p[i].Rate, true
+		}
+	}
+	return 0, false
+}
+
+// convert an amount of one commodity into another at the rate on day,
+// rounding to the nearest unit
+func (p Prices) Convert(amount int, from, to string, day time.Time) (int, error) {
+	if normalizeCommodity(from) == normalizeCommodity(to) {
+		return amount, nil
+	}
+	rate, err := p.Rate(from, to, day)
+	if err != nil {
+		return 0, err
+	}
+	return int(math.Round(float64(amount) * rate)), nil
+}
+
+// get the worth of holdings in base at the rates on day
+func (p Prices) Value(h Holdings, base string, day time.Time) (int, error) {
+	total := 0
+	for c, amount := range h {
+		v, err := p.Convert(amount, c, base, day)
+		if err != nil {
+			return 0, err
+		}
+		total += v
+	}
+	return total, nil
+}
+
+// convert the amounts each bucket holds into base at the rates on day
+func (p Prices) values(balances map[string]Holdings, base string, day time.Time) (map[string]int, error) {
+	output := map[string]int{}
+	for b, h := range balances {
+		v, err := p.Value(h, base, day)
+		if err != nil {
+			return nil, fmt.Errorf("valuing %s: %w", b, err)
+		}
+		output[b] = v
+	}
+	return output, nil
+}
+
+// get the worth of holdings in base at the rates on day, only reading prices
+// when something is held in another commodity
+func valueIn(tx *sql.Tx, h Holdings, base string, day time.Time) (int, error) {
+	if h.onlyIn(base) {
+		return h[base], nil
+	}
+	prices, err := GetPrices(tx)
+	if err != nil {
+		return 0, err
+	}
+	return prices.Value(h, base, day)
+}
+
+// FXGain is the unrealized gain or loss on a commodity held in a bucket,
+// measured in a base commodity: what the holding is worth at the report
+// date less what it was worth on the days it came in and out
+type FXGain struct {
+	Bucket    string
+	Commodity string
+	Holding   int // amount of Commodity held
+	Cost      int // Holding in base at the rates on the days it moved
+	Value     int // Holding in base at the rates on the report date
+	Gain      int // Value less Cost
+}
+
+// get the unrealized gain or loss in base on every commodity other than base
+// held through through by an asset or liability bucket. Money moves between
+// commodities through an exchange bucket, which ends up holding both sides
+// of each trade, e.g. +1,100 USD and -1,000 EUR; registered as equity, it
+// stays out of the gains.
+func UnrealizedGains(tx *sql.Tx, base string, through time.Time) ([]FXGain, error) {
+	base = normalizeCommodity(base)
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return nil, fmt.Errorf("UnrealizedGains() - %w", err)
+	}
+	prices, err := GetPrices(tx)
+	if err != nil {
+		return nil, fmt.Errorf("UnrealizedGains() - %w", err)
+	}
+	q := `SELECT bucket, commodity, happened_at, sum(amount) FROM (
+		SELECT destination AS bucket, commodity, happened_at, amount FROM entries
+		UNION ALL
+		SELECT source, commodity, happened_at, -amount FROM entries
+		)
+		WHERE commodity != $1 AND happened_at <= $2
+		GROUP BY bucket, commodity, happened_at;`
+	rows, err := tx.Query(q, base, utils.FormatDate(through))
+	if err != nil {
+		return nil, fmt.Errorf("UnrealizedGains() - querying entries: %w", err)
+	}
+	defer rows.Close()
+	type key struct{ bucket, commodity string }
+	gains := map[key]*FXGain{}
+	add := func(bucket, commodity string, day time.Time, amount int) error {
+		if t := registry.Type(bucket); t != Asset && t != Liability {
+			return nil
+		}
+		cost, err := prices.Convert(amount, commodity, base, day)
+		if err != nil {
+			return fmt.Errorf("valuing %s in %s: %w", commodity, bucket, err)
+		}
+		k := key{bucket, normalizeCommodity(commodity)}
+		if gains[k] == nil {
+			gains[k] = &FXGain{Bucket: bucket, Commodity: k.commodity}
+		}
+		gains[k].Holding += amount
+		gains[k].Cost += cost
+		return nil
+	}
+	for rows.Next() {
+		var bucket, commodity, day string
+		var amount int
+		if err := rows.Scan(&bucket, &commodity, &day, &amount); err != nil {
+			return nil, fmt.Errorf("UnrealizedGains() - scanning entry: %w", err)
+		}
+		d, err := utils.ParseDate(day)
+		if err != nil {
+			return nil, fmt.Errorf("UnrealizedGains() - %w", err)
+		}
+		if err := add(bucket, commodity, d, amount); err != nil {
+			return nil, fmt.Errorf("UnrealizedGains() - %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("UnrealizedGains() - reading entries: %w", err)
+	}
+	scheduled, err := scheduledEntries(tx, utils.BigBang, through)
+	if err != nil {
+		return nil, fmt.Errorf("UnrealizedGains() - %w", err)
+	}
+	for _, e := range scheduled {
+		if normalizeCommodity(e.Commodity) == base {
+			continue
+		}
+		if err := add(e.Destination, e.Commodity, e.EntryDate, e.Amount); err != nil {
+			return nil, fmt.Errorf("UnrealizedGains() - %w", err)
+		}
+		if err := add(e.Source, e.Commodity, e.EntryDate, -e.Amount); err != nil {
+			return nil, fmt.Errorf("UnrealizedGains() - %w", err)
+		}
+	}
+	var output []FXGain
+	for _, g := range gains {
+		if g.Holding == 0 {
+			continue
+		}
+		if g.Value, err = prices.Convert(g.Holding, g.Commodity, base, through); err != nil {
+			return nil, fmt.Errorf("UnrealizedGains() - valuing %s in %s: %w", g.Commodity, g.Bucket, err)
+		}
+		g.Gain = g.Value - g.Cost
+		output = append(output, *g)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if output[i].Bucket != output[j].Bucket {
+			return output[i].Bucket < output[j].Bucket
+		}
+		return output[i].Commodity < output[j].Commodity
+	})
+	return output, nil
+}
+
+// parse a price from a form
+func PreparePriceForInsert(r *http.Request) (Price, error) {
+	r.ParseForm()
+	date, err := utils.ParseDate(r.PostForm.Get("priced_at"))
+	if err != nil {
+		return Price{}, fmt.Errorf("Could not parse priced_at (%v)", err)
+	}
+	rate, err := strconv.ParseFloat(r.PostForm.Get("rate"), 64)
+	if err != nil {
+		return Price{}, fmt.Errorf("Could not convert rate field to a number (%v)", err)
+	}
+	return Price{
+		Commodity: r.PostForm.Get("commodity"),
+		Quote:     r.PostForm.Get("quote"),
+		Date:      date,
+		Rate:      rate,
+	}, nil
+}
+
+// parse the base commodity and date of an unrealized gains report from a
+// form or query string. It defaults to DefaultCommodity as of today.
+func PrepareFXGains(r *http.Request) (string, time.Time, error) {
+	r.ParseForm()
+	now := time.Now()
+	through := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	base := normalizeCommodity(r.Form.Get("commodity"))
+	if v := r.Form.Get("through"); v != "" {
+		var err error
+		if through, err = utils.ParseDate(v); err != nil {
+			return base, through, fmt.Errorf("Could not parse through (%v)", err)
+		}
+	}
+	return base, through, nil
+}
@@ -0,0 +1,176 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"ledger/pkg/utils"
+	"testing"
+	"time"
+)
+
+// record a euro at 1.10 dollars in January and 1.20 from February, and a
+// pound at 1.25 dollars
+func insertPrices(tx *sql.Tx) error {
+	for _, p := range []ledger.Price{
+		{Commodity: "eur", Quote: "usd", Date: testutils.Date(1, 1), Rate: 1.10},
+		{Commodity: "EUR", Quote: "USD", Date: testutils.Date(2, 1), Rate: 1.20},
+		{Commodity: "GBP", Quote: "USD", Date: testutils.Date(1, 1), Rate: 1.25},
+	} {
+		if _, err := ledger.InsertPrice(tx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestConvert(t *testing.T) {
+	db := testutils.Db(t)
+	testutils.Tx(t, db, insertPrices)
+	var prices ledger.Prices
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		prices, err = ledger.GetPrices(tx)
+		return err
+	})
+	for _, tc := range []struct {
+		amount   int
+		from, to string
+		day      time.Time
+		want     int
+	}{
+		{1000, "EUR", "USD", testutils.Date(1, 15), 1100},
+		{1000, "EUR", "USD", testutils.Date(2, 1), 1200},
+		// the inverse of a recorded price
+		{1100, "USD", "EUR", testutils.Date(1, 15), 1000},
+		// through dollars, at 1.25 / 1.10
+		{1100, "GBP", "EUR", testutils.Date(1, 15), 1250},
+		{1000, "JPY", "JPY", testutils.Date(1, 15), 1000},
+	} {
+		got, err := prices.Convert(tc.amount, tc.from, tc.to, tc.day)
+		if err != nil {
+			t.Fatalf("converting %d %s to %s: %v", tc.amount, tc.from, tc.to, err)
+		}
+		testutils.AssertEqual(t, tc.want, got)
+	}
+	if _, err := prices.Convert(1000, "EUR", "USD", testutils.Date(1, 1).AddDate(0, 0, -1)); err == nil {
+		t.Fatalf("want an error converting before the first price")
+	}
+}
+
+func TestSummarizeBalanceIn(t *testing.T) {
+	db := testutils.Db(t)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		if err := insertPrices(tx); err != nil {
+			return err
+		}
+		for _, e := range []ledger.Entry{
+			{Source: "equity", Destination: "assets:checking", EntryDate: testutils.Date(1, 5), Amount: 1000},
+			{Source: "equity", Destination: "assets:euro", EntryDate: testutils.Date(1, 5), Amount: 500, Commodity: "EUR"},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		_, err := ledger.InsertAssertion(tx, ledger.Assertion{Bucket: "assets:euro", Date: testutils.Date(1, 31), Amount: 500, Commodity: "EUR"})
+		return err
+	})
+	buckets := []string{"assets", "assets:checking", "assets:euro"}
+	var holdings ledger.Holdings
+	var inDollars, inEuros map[string]int
+	var failures []ledger.AssertionFailure
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		if holdings, err = ledger.SummarizeHoldings(tx, "assets", utils.BigBang, testutils.Date(1, 15)); err != nil {
+			return err
+		}
+		if inDollars, err = ledger.SummarizeBalance(tx, buckets, utils.BigBang, testutils.Date(1, 15)); err != nil {
+			return err
+		}
+		if inEuros, err = ledger.SummarizeBalanceIn(tx, "EUR", buckets, utils.BigBang, testutils.Date(1, 15)); err != nil {
+			return err
+		}
+		failures, err = ledger.CheckAssertions(tx)
+		return err
+	})
+	testutils.AssertEqual(t, ledger.Holdings{"USD": 1000, "EUR": 500}, holdings)
+	testutils.AssertEqual(t, map[string]int{"assets": 1550, "assets:checking": 1000, "assets:euro": 550}, inDollars)
+	testutils.AssertEqual(t, map[string]int{"assets": 1409, "assets:checking": 909, "assets:euro": 500}, inEuros)
+	testutils.AssertEqual(t, 0, len(failures))
+}
+
+func TestClosePeriodCommodities(t *testing.T) {
+	db := testutils.Db(t)
+	var closing ledger.Closing
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		for _, e := range []ledger.Entry{
+			{Source: "income", Destination: "assets:euro", EntryDate: testutils.Date(1, 10), Amount: 500, Commodity: "EUR"},
+			{Source: "assets:checking", Destination: "expenses", EntryDate: testutils.Date(1, 12), Amount: 100},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		for name, bt := range map[string]ledger.BucketType{
+			"assets":   ledger.Asset,
+			"income":   ledger.Income,
+			"expenses": ledger.Expense,
+			"equity":   ledger.Equity,
+		} {
+			if err := ledger.RegisterBucket(tx, ledger.Bucket{Name: name, Type: bt, OpenedAt: testutils.BigBang}); err != nil {
+				return err
+			}
+		}
+		closing, err = ledger.ClosePeriod(tx, testutils.Date(1, 31), "equity:retained")
+		return err
+	})
+	want := map[string]ledger.Holdings{
+		"assets":          {"USD": -100, "EUR": 500},
+		"assets:checking": {"USD": -100},
+		"assets:euro":     {"EUR": 500},
+		"equity":          {"USD": 100, "EUR": -500},
+		"equity:retained": {"USD": 100, "EUR": -500},
+	}
+	testutils.AssertEqual(t, want, closing.OpeningBalances)
+	// each commodity closes with its own entry, leaving income empty in both
+	var income ledger.Holdings
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		income, err = ledger.SummarizeHoldings(tx, "income", testutils.Date(1, 1), testutils.Date(1, 31))
+		return err
+	})
+	testutils.AssertEqual(t, ledger.Holdings{}, income)
+}
+
+func TestUnrealizedGains(t *testing.T) {
+	db := testutils.Db(t)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		if err := insertPrices(tx); err != nil {
+			return err
+		}
+		for name, bt := range map[string]ledger.BucketType{
+			"assets": ledger.Asset,
+			"income": ledger.Income,
+			"equity": ledger.Equity,
+		} {
+			if err := ledger.RegisterBucket(tx, ledger.Bucket{Name: name, Type: bt, OpenedAt: testutils.BigBang}); err != nil {
+				return err
+			}
+		}
+		// 1,100 dollars buy 1,000 euros through the exchange bucket
+		for _, e := range []ledger.Entry{
+			{Source: "income", Destination: "assets:checking", EntryDate: testutils.Date(1, 5), Amount: 1100},
+			{Source: "assets:checking", Destination: "equity:exchange", EntryDate: testutils.Date(1, 5), Amount: 1100},
+			{Source: "equity:exchange", Destination: "assets:euro", EntryDate: testutils.Date(1, 5), Amount: 1000, Commodity: "EUR"},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	var got []ledger.FXGain
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		got, err = ledger.UnrealizedGains(tx, "", testutils.Date(2, 15))
+		return err
+	})
+	want := []ledger.FXGain{{Bucket: "assets:euro", Commodity: "EUR", Holding: 1000, Cost: 1100, Value: 1200, Gain: 100}}
+	testutils.AssertEqual(t, want, got)
+}
@@ -0,0 +1,214 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/audit"
+	"ledger/pkg/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// columns read by scanEntry, in order
+var entryColumns = `id, source, destination, happened_at, amount, commodity, payee, memo,
+	COALESCE(transaction_id, 0), status, COALESCE(voids, 0),
+	COALESCE((SELECT v.id FROM entries v WHERE v.voids = entries.id), 0), COALESCE(closing_id, 0),
+	COALESCE(fitid, ''), ` + utils.TagsColumn("entries", "entry_id")
+
+// scan a single row of the entries table into an Entry, dated at local
+// midnight of the day it happened
+func scanEntry(row scanner) (Entry, error) {
+	e := Entry{}
+	var datestring, tags string
+	if err := row.Scan(&e.ID, &e.Source, &e.Destination, &datestring, &e.Amount, &e.Commodity, &e.Payee, &e.Memo, &e.TransactionID, &e.Status, &e.Voids, &e.VoidedBy, &e.ClosingID, &e.FITID, &tags); err != nil {
+		return Entry{}, err
+	}
+	e.Tags = utils.SplitTags(tags)
+	var err error
+	if e.EntryDate, err = time.ParseInLocation("2006-01-02", datestring, time.Local); err != nil {
+		return Entry{}, fmt.Errorf("scanEntry() - entry %d has unparseable date %q", e.ID, datestring)
+	}
+	return e, nil
+}
+
+// get a single entry by its id
+func GetEntry(tx *sql.Tx, id int) (Entry, error) {
+	q := `SELECT ` + entryColumns + ` FROM entries
+		WHERE id = $1;`
+	e, err := scanEntry(tx.QueryRow(q, id))
+	if err == sql.ErrNoRows {
+		return Entry{}, fmt.Errorf("GetEntry() - no entry with id %d", id)
+	} else if err != nil {
+		return Entry{}, fmt.Errorf("GetEntry() - querying entry: %w", err)
+	}
+	return e, nil
+}
+
+// get every entry stored in the ledger, ordered by date
+func GetEntries(tx *sql.Tx) ([]Entry, error) {
+	q := `SELECT ` + entryColumns + ` FROM entries
+		ORDER BY happened_at, id;`
+	rows, err := tx.Query(q)
+	if err != nil {
+		return nil, fmt.Errorf("GetEntries() - querying entries: %w", err)
+	}
+	defer rows.Close()
+	var entries []Entry
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("GetEntries() - %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// overwrite the entry identified by e.ID with the values in e. An empty
+// status leaves the entry's status as it is.
+func UpdateEntry(tx *sql.Tx, e Entry) error {
+	status, err := checkEditable(tx, e.ID, 0)
+	if err != nil {
+		return fmt.Errorf("UpdateEntry() - %w", err)
+	}
+	if e.Status == "" {
+		e.Status = status
+	}
+	if e.Status == Reconciled {
+		return fmt.Errorf("UpdateEntry() - entries are only reconciled by FinishReconciliation()")
+	}
+	e.Commodity = normalizeCommodity(e.Commodity)
+	if err := validateEntry(tx, e); err != nil {
+		return fmt.Errorf("UpdateEntry() - %w", err)
+	}
+	before, err := audit.Snapshot(tx, "entries", e.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateEntry() - %w", err)
+	}
+	q := `UPDATE entries
+		SET source = $1, destination = $2, happened_at = $3, amount = $4, commodity = $5,
+			payee = $6, memo = $7, status = $8
+		WHERE id = $9;`
+	res, err := tx.Exec(q, e.Source, e.Destination, utils.FormatDate(e.EntryDate), e.Amount, e.Commodity, e.Payee, e.Memo, e.Status, e.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateEntry() - executing the update: %w", err)
+	}
+	if err := checkAffected(res, "entry", e.ID); err != nil {
+		return err
+	}
+	if err := utils.DeleteTags(tx, "entry_id", int64(e.ID)); err != nil {
+		return fmt.Errorf("UpdateEntry() - %w", err)
+	}
+	if err := utils.InsertTags(tx, "entry_id", int64(e.ID), e.Tags); err != nil {
+		return fmt.Errorf("UpdateEntry() - %w", err)
+	}
+	if err := audit.Updated(tx, "entries", e.ID, before); err != nil {
+		return fmt.Errorf("UpdateEntry() - %w", err)
+	}
+	return nil
+}
+
+// remove the entry with the given id
+func DeleteEntry(tx *sql.Tx, id int) error {
+	if _, err := checkEditable(tx, id, 0); err != nil {
+		return fmt.Errorf("DeleteEntry() - %w", err)
+	}
+	before, err := audit.Snapshot(tx, "entries", id)
+	if err != nil {
+		return fmt.Errorf("DeleteEntry() - %w", err)
+	}
+	if err := utils.DeleteTags(tx, "entry_id", int64(id)); err != nil {
+		return fmt.Errorf("DeleteEntry() - %w", err)
+	}
+	q := `DELETE FROM entries WHERE id = $1;`
+	res, err := tx.Exec(q, id)
+	if err != nil {
+		return fmt.Errorf("DeleteEntry() - executing the delete: %w", err)
+	}
+	if err := checkAffected(res, "entry", id); err != nil {
+		return err
+	}
+	if err := audit.Deleted(tx, "entries", id, before); err != nil {
+		return fmt.Errorf("DeleteEntry() - %w", err)
+	}
+	return nil
+}
+
+// get the status of an entry, returning an error if it is one leg of a split
+// transaction, which must be edited as a whole to keep its postings balanced,
+// if it has been reconciled against a statement, if it is half of a void, or
+// if it is dated in a closed period. Legs of transaction, when it is not 0,
+// are being edited as a whole and so pass.
+func checkEditable(tx *sql.Tx, id, transaction int) (Status, error) {
+	q := `SELECT COALESCE(transaction_id, 0), status, COALESCE(voids, 0),
+		COALESCE((SELECT v.id FROM entries v WHERE v.voids = entries.id), 0), happened_at
+		FROM entries WHERE id = $1;`
+	var tid, voids, voidedBy int
+	var status Status
+	var day string
+	err := tx.QueryRow(q, id).Scan(&tid, &status, &voids, &voidedBy, &day)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no entry with id %d", id)
+	} else if err != nil {
+		return "", fmt.Errorf("querying entry: %w", err)
+	}
+	if tid != 0 && tid != transaction {
+		return "", fmt.Errorf("entry %d belongs to transaction %d", id, tid)
+	}
+	if status == Reconciled {
+		return "", fmt.Errorf("entry %d is reconciled", id)
+	}
+	if voids != 0 {
+		return "", fmt.Errorf("entry %d voids entry %d", id, voids)
+	}
+	if voidedBy != 0 {
+		return "", fmt.Errorf("entry %d is voided by entry %d", id, voidedBy)
+	}
+	if err := checkOpen(tx, day); err != nil {
+		return "", fmt.Errorf("entry %d is dated %s: %w", id, day, err)
+	}
+	return status, nil
+}
+
+// return an error if a statement did not touch exactly one row, e.g. one
+// "entry" or "schedule"
+func checkAffected(res sql.Result, what string, id int) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("checking rows affected: %w", err)
+	}
+	if n != 1 {
+		return fmt.Errorf("no %s with id %d", what, id)
+	}
+	return nil
+}
+
+// parse the id of an existing entry from a form
+func PrepareEntryID(r *http.Request) (int, error) {
+	r.ParseForm()
+	id, err := strconv.Atoi(r.Form.Get("id"))
+	if err != nil {
+		return -1, fmt.Errorf("Could not convert id field to int (%v)", err)
+	}
+	return id, nil
+}
+
+// parse an entry, including its id, from a form
+func PrepareEntryForUpdate(r *http.Request) (Entry, error) {
+	id, err := PrepareEntryID(r)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry, err := PrepareEntryForInsert(r)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.ID = id
+	return entry, nil
+}
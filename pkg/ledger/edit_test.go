@@ -0,0 +1,143 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestGetEntry(t *testing.T) {
+	db := testutils.Db(t)
+	t.Run("one entry",
+		func(t *testing.T) {
+			input := ledger.Entry{
+				Source:      "savings",
+				Destination: "checking",
+				EntryDate:   time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local),
+				Amount:      100,
+			}
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.InsertEntry(tx, input)
+			})
+			want := input
+			want.ID = 1
+			want.Commodity = ledger.DefaultCommodity
+			want.Status = ledger.Pending
+			var got ledger.Entry
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.GetEntry(tx, 1)
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+		})
+	t.Run("missing entry",
+		func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			defer tx.Rollback()
+			if _, err := ledger.GetEntry(tx, 42); err == nil {
+				t.Fatalf("want error for missing entry, got nil")
+			}
+		})
+}
+
+func TestUpdateEntry(t *testing.T) {
+	db := testutils.Db(t)
+	bigBang := testutils.BigBang
+	entryDate := time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local)
+	t.Run("fix a mistaken destination",
+		func(t *testing.T) {
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.InsertEntry(tx, ledger.Entry{
+					Source:      "savings",
+					Destination: "chekcing",
+					EntryDate:   entryDate,
+					Amount:      100,
+				})
+			})
+			want := ledger.Entry{
+				ID:          1,
+				Source:      "savings",
+				Destination: "checking",
+				EntryDate:   entryDate,
+				Amount:      250,
+				Commodity:   ledger.DefaultCommodity,
+				Status:      ledger.Pending,
+			}
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.UpdateEntry(tx, want)
+			})
+			var got ledger.Entry
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.GetEntry(tx, 1)
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+			// the mistaken bucket no longer holds anything
+			var typo int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				typo, err = ledger.SummarizeBucket(tx, "chekcing", bigBang, entryDate)
+				return err
+			})
+			testutils.AssertEqual(t, 0, typo)
+		})
+	t.Run("missing entry",
+		func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			defer tx.Rollback()
+			if err := ledger.UpdateEntry(tx, ledger.Entry{ID: 42}); err == nil {
+				t.Fatalf("want error for missing entry, got nil")
+			}
+		})
+}
+
+func TestDeleteEntry(t *testing.T) {
+	db := testutils.Db(t)
+	bigBang := testutils.BigBang
+	entryDate := time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local)
+	t.Run("delete one of two entries",
+		func(t *testing.T) {
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				for i := 0; i < 2; i++ {
+					err := ledger.InsertEntry(tx, ledger.Entry{
+						Source:      "savings",
+						Destination: "checking",
+						EntryDate:   entryDate,
+						Amount:      100,
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.DeleteEntry(tx, 1)
+			})
+			want := 100
+			var got int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.SummarizeBucket(tx, "checking", bigBang, entryDate)
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+		})
+	t.Run("missing entry",
+		func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			defer tx.Rollback()
+			if err := ledger.DeleteEntry(tx, 1); err == nil {
+				t.Fatalf("want error for already deleted entry, got nil")
+			}
+		})
+}
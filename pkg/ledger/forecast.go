@@ -0,0 +1,151 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/utils"
+	"time"
+)
+
+// Forecast is the projected first day a bucket's balance drops below a
+// threshold. Balances are stored balances, as from SummarizeBucket, and
+// include future occurrences of repeating entries. Entries in another
+// commodity count in DefaultCommodity at the rates on From.
+type Forecast struct {
+	Bucket       string
+	Threshold    int
+	From         time.Time
+	Through      time.Time
+	StartBalance int       // balance at the start of From
+	Found        bool      // whether the balance drops below Threshold by Through
+	Date         time.Time // first day the balance is below Threshold
+	Balance      int       // balance at the end of Date
+	Entries      []Entry   // entries on Date that took money out of the bucket
+}
+
+// walk the projected balance of a bucket from from through through, and find
+// the first day it ends below threshold
+func ForecastBucket(tx *sql.Tx, bucket string, threshold int, from, through time.Time) (Forecast, error) {
+	forecasts, err := forecastBuckets(tx, []string{bucket}, threshold, from, through)
+	if err != nil {
+		return Forecast{}, fmt.Errorf("ForecastBucket() - %w", err)
+	}
+	return forecasts[0], nil
+}
+
+// forecast every asset bucket that is at or above threshold at the start of
+// from, returning those projected to drop below it by through
+func ForecastAssets(tx *sql.Tx, threshold int, from, through time.Time) ([]Forecast, error) {
+	buckets, err := GetBuckets(tx)
+	if err != nil {
+		return nil, fmt.Errorf("ForecastAssets() - %w", err)
+	}
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return nil, fmt.Errorf("ForecastAssets() - %w", err)
+	}
+	var assets []string
+	for _, b := range buckets {
+		if registry.Type(b) == Asset {
+			assets = append(assets, b)
+		}
+	}
+	forecasts, err := forecastBuckets(tx, assets, threshold, from, through)
+	if err != nil {
+		return nil, fmt.Errorf("ForecastAssets() - %w", err)
+	}
+	var output []Forecast
+	for _, f := range forecasts {
+		if f.Found && f.StartBalance >= threshold {
+			output = append(output, f)
+		}
+	}
+	return output, nil
+}
+
+// forecast each bucket over a single read of the ledger
+func forecastBuckets(tx *sql.Tx, buckets []string, threshold int, from, through time.Time) ([]Forecast, error) {
+	entries, err := GetLedger(tx, from, through.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	if entries, err = entriesIn(tx, entries, DefaultCommodity, from); err != nil {
+		return nil, err
+	}
+	balances, err := SummarizeBalance(tx, buckets, utils.BigBang, from.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	var output []Forecast
+	for _, b := range buckets {
+		start := balances[b]
+		f := Forecast{
+			Bucket:       b,
+			Threshold:    threshold,
+			From:         from,
+			Through:      through,
+			StartBalance: start,
+		}
+		if start < threshold {
+			f.Found, f.Date, f.Balance = true, from, start
+		} else {
+			f.walk(entries)
+		}
+		output = append(output, f)
+	}
+	return output, nil
+}
+
+// apply entries, ordered by date, a day at a time until the balance ends a
+// day below the threshold
+func (f *Forecast) walk(entries []Entry) {
+	balance := f.StartBalance
+	for i := 0; i < len(entries); {
+		day := entries[i].EntryDate.Format("2006-01-02")
+		var outflows []Entry
+		for ; i < len(entries) && entries[i].EntryDate.Format("2006-01-02") == day; i++ {
+			e := entries[i]
+			amount := 0
+			if withinBucket(e.Destination, f.Bucket) {
+				amount += e.Amount
+			}
+			if withinBucket(e.Source, f.Bucket) {
+				amount -= e.Amount
+			}
+			balance += amount
+			if amount < 0 {
+				outflows = append(outflows, e)
+			}
+		}
+		if balance < f.Threshold {
+			f.Found = true
+			f.Date = entries[i-1].EntryDate
+			f.Balance = balance
+			f.Entries = outflows
+			return
+		}
+	}
+}
+
+// convert entries into base at the rates on day, only reading prices when an
+// entry is in another commodity
+func entriesIn(tx *sql.Tx, entries []Entry, base string, day time.Time) ([]Entry, error) {
+	var prices Prices
+	for i, e := range entries {
+		if normalizeCommodity(e.Commodity) == base {
+			continue
+		}
+		if prices == nil {
+			var err error
+			if prices, err = GetPrices(tx); err != nil {
+				return nil, err
+			}
+		}
+		amount, err := prices.Convert(e.Amount, e.Commodity, base, day)
+		if err != nil {
+			return nil, fmt.Errorf("converting entry %d: %w", e.ID, err)
+		}
+		entries[i].Amount, entries[i].Commodity = amount, base
+	}
+	return entries, nil
+}
@@ -0,0 +1,72 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestForecastBucket(t *testing.T) {
+	db := testutils.Db(t)
+	today := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		if err := ledger.InsertEntry(tx, ledger.Entry{Source: "income", Destination: "checking", EntryDate: today.AddDate(0, 0, -1), Amount: 3000}); err != nil {
+			return err
+		}
+		// rent outpaces the paycheck by 500 a month
+		for _, s := range []ledger.Schedule{
+			{Source: "checking", Destination: "rent", Amount: 1500, StartDate: today, Frequency: "monthly"},
+			{Source: "income", Destination: "checking", Amount: 1000, StartDate: today, Frequency: "monthly"},
+		} {
+			if _, err := ledger.InsertSchedule(tx, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	t.Run("runs dry on a repeating entry",
+		func(t *testing.T) {
+			var got ledger.Forecast
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.ForecastBucket(tx, "checking", 0, today, today.AddDate(1, 0, 0))
+				return err
+			})
+			testutils.AssertEqual(t, 3000, got.StartBalance)
+			testutils.AssertEqual(t, true, got.Found)
+			testutils.AssertEqual(t, today.AddDate(0, 6, 0), got.Date)
+			testutils.AssertEqual(t, -500, got.Balance)
+			testutils.AssertEqual(t, 1, len(got.Entries))
+			testutils.AssertEqual(t, "rent", got.Entries[0].Destination)
+		})
+	t.Run("drops below a threshold",
+		func(t *testing.T) {
+			var got ledger.Forecast
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.ForecastBucket(tx, "checking", 2000, today, today.AddDate(1, 0, 0))
+				return err
+			})
+			testutils.AssertEqual(t, today.AddDate(0, 2, 0), got.Date)
+		})
+	t.Run("stays above within the horizon",
+		func(t *testing.T) {
+			var got ledger.Forecast
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.ForecastBucket(tx, "checking", 0, today, today.AddDate(0, 3, 0))
+				return err
+			})
+			testutils.AssertEqual(t, false, got.Found)
+		})
+	t.Run("only assets that are above the threshold today",
+		func(t *testing.T) {
+			var got []ledger.Forecast
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.ForecastAssets(tx, 0, today, today.AddDate(1, 0, 0))
+				return err
+			})
+			// income is already negative and rent only grows
+			testutils.AssertEqual(t, 1, len(got))
+			testutils.AssertEqual(t, "checking", got[0].Bucket)
+		})
+}
@@ -0,0 +1,114 @@
+package ledger
+
+import (
+	"sort"
+	"strings"
+)
+
+// BucketSeparator splits a bucket name into levels, e.g. assets:bank:checking
+const BucketSeparator = ":"
+
+// BucketNode is one level of the bucket hierarchy. Its balance includes the
+// balances of all of its children.
+type BucketNode struct {
+	Name        string // full name, e.g. assets:bank
+	Label       string // last level of the name, e.g. bank
+	Depth       int    // 1 for a top-level bucket
+	Balance     int
+	Description string // balance in words, see Registry.Describe
+	Children    []*BucketNode
+}
+
+// get the number of levels in a bucket name
+func BucketDepth(bucket string) int {
+	return strings.Count(bucket, BucketSeparator) + 1
+}
+
+// get the parents of a bucket, outermost first
+func BucketAncestors(bucket string) []string {
+	parts := strings.Split(bucket, BucketSeparator)
+	var ancestors []string
+	for i := 1; i < len(parts); i++ {
+		ancestors = append(ancestors, strings.Join(parts[:i], BucketSeparator))
+	}
+	return ancestors
+}
+
+// report whether name is bucket itself or one of its children
+func withinBucket(name, bucket string) bool {
+	return name == bucket || strings.HasPrefix(name, bucket+BucketSeparator)
+}
+
+// cut a bucket name down to at most depth levels; depth < 1 leaves it as is
+func TruncateBucket(bucket string, depth int) string {
+	if depth < 1 {
+		return bucket
+	}
+	parts := strings.Split(bucket, BucketSeparator)
+	if len(parts) <= depth {
+		return bucket
+	}
+	return strings.Join(parts[:depth], BucketSeparator)
+}
+
+// get the sorted, distinct bucket names at most depth levels deep, so that
+// summarizing them rolls every deeper bucket up into its parent
+func BucketsAtDepth(buckets []string, depth int) []string {
+	seen := map[string]bool{}
+	output := []string{}
+	for _, b := range buckets {
+		t := TruncateBucket(b, depth)
+		if !seen[t] {
+			seen[t] = true
+			output = append(output, t)
+		}
+	}
+	sort.Strings(output)
+	return output
+}
+
+// get the sorted bucket names along with every parent level above them
+func ExpandBuckets(buckets []string) []string {
+	seen := map[string]bool{}
+	output := []string{}
+	for _, b := range buckets {
+		for _, name := range append(BucketAncestors(b), b) {
+			if !seen[name] {
+				seen[name] = true
+				output = append(output, name)
+			}
+		}
+	}
+	sort.Strings(output)
+	return output
+}
+
+// arrange rolled-up balances into a tree. balances should hold every level,
+// as returned by SummarizeBalance over ExpandBuckets; any missing level is
+// added with a zero balance.
+func MakeBucketTree(balances map[string]int) []*BucketNode {
+	names := []string{}
+	for b := range balances {
+		names = append(names, b)
+	}
+	nodes := map[string]*BucketNode{}
+	var roots []*BucketNode
+	// parents sort before their children, so each parent already exists
+	for _, name := range ExpandBuckets(names) {
+		i := strings.LastIndex(name, BucketSeparator)
+		n := &BucketNode{
+			Name:    name,
+			Label:   name[i+1:],
+			Depth:   BucketDepth(name),
+			Balance: balances[name],
+		}
+		nodes[name] = n
+		if i < 0 {
+			roots = append(roots, n)
+		} else {
+			parent := nodes[name[:i]]
+			parent.Children = append(parent.Children, n)
+		}
+	}
+	return roots
+}
@@ -0,0 +1,117 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestSummarizeParentBuckets(t *testing.T) {
+	db := testutils.Db(t)
+	bigBang := testutils.BigBang
+	start := time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local)
+	input := []ledger.Entry{
+		{Source: "income:salary", Destination: "assets:bank:checking", EntryDate: start, Amount: 1000},
+		{Source: "assets:bank:checking", Destination: "assets:bank:savings", EntryDate: start, Amount: 300},
+		{Source: "assets:bank:checking", Destination: "expenses:food:groceries", EntryDate: start.AddDate(0, 0, 1), Amount: 80},
+		{Source: "assets:bank:checking", Destination: "expenses:food", EntryDate: start.AddDate(0, 0, 1), Amount: 20},
+		// a sibling that shares a prefix but not a parent
+		{Source: "assets:bankroll", Destination: "expenses:fun", EntryDate: start.AddDate(0, 0, 1), Amount: 5},
+	}
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		for _, e := range input {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	t.Run("parents at every depth",
+		func(t *testing.T) {
+			want := map[string]int{
+				"assets":               895,
+				"assets:bank":          900,
+				"assets:bank:checking": 600,
+				"expenses":             105,
+				"expenses:food":        100,
+				"income":               -1000,
+			}
+			var got map[string]int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.SummarizeBalance(
+					tx,
+					[]string{"assets", "assets:bank", "assets:bank:checking", "expenses", "expenses:food", "income"},
+					bigBang,
+					start.AddDate(0, 0, 1),
+				)
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+		})
+	t.Run("parents over time",
+		func(t *testing.T) {
+			want := []map[string]int{
+				{"assets:bank": 1000, "expenses": 0},
+				{"assets:bank": 900, "expenses": 105},
+			}
+			var got []map[string]int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.SummarizeBalanceOverTime(
+					tx,
+					[]string{"assets:bank", "expenses"},
+					start,
+					start.AddDate(0, 0, 1),
+				)
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+		})
+}
+
+func TestBucketsAtDepth(t *testing.T) {
+	buckets := []string{"assets:bank:checking", "assets:bank:savings", "assets:cash", "income"}
+	t.Run("depth one", func(t *testing.T) {
+		want := []string{"assets", "income"}
+		got := ledger.BucketsAtDepth(buckets, 1)
+		testutils.AssertEqual(t, want, got)
+	})
+	t.Run("depth two", func(t *testing.T) {
+		want := []string{"assets:bank", "assets:cash", "income"}
+		got := ledger.BucketsAtDepth(buckets, 2)
+		testutils.AssertEqual(t, want, got)
+	})
+	t.Run("unlimited depth", func(t *testing.T) {
+		got := ledger.BucketsAtDepth(buckets, 0)
+		testutils.AssertEqual(t, buckets, got)
+	})
+}
+
+func TestExpandBuckets(t *testing.T) {
+	want := []string{"assets", "assets:bank", "assets:bank:checking", "income"}
+	got := ledger.ExpandBuckets([]string{"income", "assets:bank:checking"})
+	testutils.AssertEqual(t, want, got)
+}
+
+func TestMakeBucketTree(t *testing.T) {
+	// rolled-up balances; assets:bank is missing and shows as zero
+	balances := map[string]int{
+		"assets:bank:checking": 600,
+		"assets:bank:savings":  300,
+		"assets":               905,
+		"income":               -905,
+	}
+	got := ledger.MakeBucketTree(balances)
+	testutils.AssertEqual(t, 2, len(got))
+	assets := got[0]
+	testutils.AssertEqual(t, "assets", assets.Name)
+	testutils.AssertEqual(t, 905, assets.Balance)
+	bank := assets.Children[0]
+	testutils.AssertEqual(t, "bank", bank.Label)
+	testutils.AssertEqual(t, 2, bank.Depth)
+	testutils.AssertEqual(t, 0, bank.Balance)
+	testutils.AssertEqual(t, "assets:bank:checking", bank.Children[0].Name)
+	testutils.AssertEqual(t, 600, bank.Children[0].Balance)
+	testutils.AssertEqual(t, -905, got[1].Balance)
+}
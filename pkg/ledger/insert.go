@@ -3,6 +3,8 @@ package ledger
 import (
 	"database/sql"
 	"fmt"
+	"ledger/pkg/audit"
+	"ledger/pkg/utils"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,46 +14,63 @@ import (
 
 // transaction represents a double-Entry accounting item in the ledger.
 type Entry struct {
-	Source      string
-	Destination string
-	EntryDate   time.Time
-	Amount      int
+	ID            int
+	Source        string
+	Destination   string
+	EntryDate     time.Time
+	Amount        int
+	Commodity     string // what Amount is counted in; DefaultCommodity when left empty
+	Payee         string // who the money was paid to or received from
+	Memo          string // why the money moved
+	TransactionID int    // split transaction this entry belongs to, or 0
+	ScheduleID    int    // repeating entry this is an occurrence of, or 0
+	Status        Status // Pending when left empty on insert
+	Voids         int    // entry this one reverses, or 0
+	VoidedBy      int    // entry reversing this one, or 0; never set on insert
+	ClosingID     int    // period close that posted this entry, or 0
+	FITID         string // id the bank gave the entry in an imported statement, or empty
+	Tags          []string
 }
 
 // Insert an entry
 func InsertEntry(tx *sql.Tx, e Entry) error {
+	if e.Status == "" {
+		e.Status = Pending
+	}
+	e.Commodity = normalizeCommodity(e.Commodity)
+	if err := validateEntry(tx, e); err != nil {
+		return fmt.Errorf("insert() - %w", err)
+	}
 	q := `INSERT INTO entries
-		(source, destination, happened_at, amount)
-		VALUES ($1, $2, $3, $4);`
-	_, err := tx.Exec(q, e.Source, e.Destination, e.EntryDate, e.Amount)
+		(source, destination, happened_at, amount, commodity, payee, memo, transaction_id, status, voids, closing_id, fitid)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), $9, NULLIF($10, 0), NULLIF($11, 0), NULLIF($12, ''));`
+	res, err := tx.Exec(q, e.Source, e.Destination, utils.FormatDate(e.EntryDate), e.Amount, e.Commodity, e.Payee, e.Memo, e.TransactionID, e.Status, e.Voids, e.ClosingID, e.FITID)
 	if err != nil {
 		return fmt.Errorf("insert() - executing the insert: %w", err)
 	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("insert() - getting entry id: %w", err)
+	}
+	if err := utils.InsertTags(tx, "entry_id", id, e.Tags); err != nil {
+		return fmt.Errorf("insert() - tagging entry: %w", err)
+	}
+	if err := audit.Inserted(tx, "entries", int(id)); err != nil {
+		return fmt.Errorf("insert() - %w", err)
+	}
 	return nil
 }
 
-// insert a transaction that repeats weekly or monthly
-func InsertRepeatingEntry(tx *sql.Tx, e Entry, freq string) error {
-	q := `INSERT INTO entries
-		(source, destination, happened_at, amount)
-		VALUES ($1, $2, $3, $4);`
-	var freqMonth int
-	var freqDay int
-	if freq == "monthly" {
-		freqMonth = 1
-		freqDay = 0
-	} else if freq == "weekly" {
-		freqMonth = 0
-		freqDay = 7
+// report whether an entry the bank identified by fitid has already been
+// imported into bucket
+func Imported(tx *sql.Tx, bucket, fitid string) (bool, error) {
+	q := `SELECT count(id) FROM entries
+		WHERE fitid = $1 AND (source = $2 OR destination = $2);`
+	var count int
+	if err := tx.QueryRow(q, fitid, bucket).Scan(&count); err != nil {
+		return false, fmt.Errorf("Imported() - %w", err)
 	}
-	endDate := time.Now().AddDate(2, 0, 0)
-	for e.EntryDate.Before(endDate) {
-		if _, err := tx.Exec(q, e.Source, e.Destination, e.EntryDate, e.Amount); err != nil {
-			return fmt.Errorf("insertRepeating() - inserting transactions: %w", err)
-		}
-		e.EntryDate = e.EntryDate.AddDate(0, freqMonth, freqDay)
-	}
-	return nil
+	return count > 0, nil
 }
 
 func PrepareEntryForInsert(r *http.Request) (Entry, error) {
@@ -69,6 +88,11 @@ func PrepareEntryForInsert(r *http.Request) (Entry, error) {
 		Destination: r.PostForm["destination"][0],
 		EntryDate:   entrydate,
 		Amount:      amount,
+		Commodity:   r.PostForm.Get("commodity"),
+		Payee:       r.PostForm.Get("payee"),
+		Memo:        r.PostForm.Get("memo"),
+		Status:      Status(r.PostForm.Get("status")),
+		Tags:        utils.ParseTags(r.PostForm.Get("tags")),
 	}
 	return entry, nil
 }
@@ -2,8 +2,8 @@ package ledger_test
 
 import (
 	"database/sql"
-	"io/ioutil"
 	"ledger/pkg/ledger"
+	"ledger/pkg/migrations"
 	"ledger/pkg/testutils"
 	"testing"
 	"time"
@@ -50,22 +50,23 @@ func TestInsertEntry(t *testing.T) {
 		})
 }
 
-func TestInsertRepeatingEntry(t *testing.T) {
+func TestInsertSchedule(t *testing.T) {
 	// initialize db and test vars
 	db := testdb(t)
 	bigBang := testutils.BigBang
 	entryDate := time.Now()
 	t.Run("one monthly repeating entry",
 		func(t *testing.T) {
-			entry := ledger.Entry{
+			schedule := ledger.Schedule{
 				Source:      "savings",
 				Destination: "checking",
 				Amount:      100,
-				EntryDate:   entryDate,
+				StartDate:   entryDate,
+				Frequency:   "monthly",
 			}
 			// insert repeating entry
 			testutils.Tx(t, db, func(tx *sql.Tx) error {
-				err := ledger.InsertRepeatingEntry(tx, entry, "monthly")
+				_, err := ledger.InsertSchedule(tx, schedule)
 				return err
 			})
 			// summarize ledger source
@@ -87,12 +88,8 @@ func testdb(t *testing.T) *sql.DB {
 	if err != nil {
 		t.Fatalf("opening database: %v", err)
 	}
-	schema, err := ioutil.ReadFile("../../schema.sql")
-	if err != nil {
-		t.Fatalf("opening schema file: %v", err)
-	}
-	if _, err := db.Exec(string(schema)); err != nil {
-		t.Fatalf("loading schema: %v", err)
+	if _, err := migrations.Run(db); err != nil {
+		t.Fatalf("migrating: %v", err)
 	}
 	return db
 }
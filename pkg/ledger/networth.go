@@ -0,0 +1,141 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/period"
+	"ledger/pkg/utils"
+	"net/http"
+	"time"
+)
+
+// NetWorth is assets less liabilities at the end of each period. Plot breaks
+// it down by top-level bucket, each holding the share of net worth carried by
+// the asset and liability buckets beneath it.
+type NetWorth struct {
+	Plot      PlotData
+	Totals    []int  // net worth at the end of each period
+	Changes   []int  // change in net worth over each period
+	Projected []bool // whether a period ends after today, so counts entries yet to happen
+}
+
+// get net worth in DefaultCommodity, see SummarizeNetWorthIn
+func SummarizeNetWorth(tx *sql.Tx, start, end, today time.Time, interval period.Interval) (NetWorth, error) {
+	return SummarizeNetWorthIn(tx, DefaultCommodity, start, end, today, interval)
+}
+
+// get net worth in base at the end of each period of interval from start
+// through end, valuing other commodities at the rates on each period's last
+// day. Periods ending after today are a projection: they count future-dated
+// entries and the future occurrences of repeating entries.
+func SummarizeNetWorthIn(tx *sql.Tx, base string, start, end, today time.Time, interval period.Interval) (NetWorth, error) {
+	periods := interval.Periods(start, end)
+	if len(periods) == 0 {
+		return NetWorth{}, nil
+	}
+	used, err := GetBuckets(tx)
+	if err != nil {
+		return NetWorth{}, fmt.Errorf("SummarizeNetWorth() - %w", err)
+	}
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return NetWorth{}, fmt.Errorf("SummarizeNetWorth() - %w", err)
+	}
+	buckets := ExpandBuckets(used)
+	// start the day before the first period, to measure its change
+	from := periods[0].Start.AddDate(0, 0, -1)
+	daily, err := SummarizeBalanceOverTimeIn(tx, base, buckets, from, periods[len(periods)-1].Last)
+	if err != nil {
+		return NetWorth{}, fmt.Errorf("SummarizeNetWorth() - %w", err)
+	}
+	days := map[string]int{}
+	for i, d := 0, from; i < len(daily); i, d = i+1, d.AddDate(0, 0, 1) {
+		days[dayKey(d)] = i
+	}
+	children := map[string][]string{}
+	for _, b := range buckets {
+		if ancestors := BucketAncestors(b); len(ancestors) > 0 {
+			parent := ancestors[len(ancestors)-1]
+			children[parent] = append(children[parent], b)
+		}
+	}
+	// split rolled-up balances into the amount each bucket holds itself, and
+	// credit those of asset and liability buckets to their top-level bucket
+	shares := func(balances map[string]int) map[string]int {
+		output := map[string]int{}
+		for _, b := range buckets {
+			if t := registry.Type(b); t != Asset && t != Liability {
+				continue
+			}
+			own := balances[b]
+			for _, c := range children[b] {
+				own -= balances[c]
+			}
+			output[TruncateBucket(b, 1)] += own
+		}
+		return output
+	}
+	total := func(shares map[string]int) int {
+		t := 0
+		for _, v := range shares {
+			t += v
+		}
+		return t
+	}
+	var output NetWorth
+	var series []map[string]int
+	previous := total(shares(daily[0]))
+	for _, p := range periods {
+		s := shares(daily[days[dayKey(p.Last)]])
+		series = append(series, s)
+		output.Totals = append(output.Totals, total(s))
+		output.Changes = append(output.Changes, total(s)-previous)
+		output.Projected = append(output.Projected, dayKey(p.Last) > dayKey(today))
+		previous = total(s)
+	}
+	// leave out top-level buckets that never hold any net worth
+	for top := range series[0] {
+		empty := true
+		for _, s := range series {
+			if s[top] != 0 {
+				empty = false
+			}
+		}
+		if empty {
+			for _, s := range series {
+				delete(s, top)
+			}
+		}
+	}
+	output.Plot = *MakePlot(series, periods[0].Start, interval)
+	return output, nil
+}
+
+// parse the dates, interval and base commodity of a net worth summary from a
+// form or query string. It defaults to monthly from a year ago, projected a
+// year ahead, in DefaultCommodity.
+func PrepareNetWorth(r *http.Request) (time.Time, time.Time, period.Interval, string, error) {
+	r.ParseForm()
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	start, end := today.AddDate(-1, 0, 0), today.AddDate(1, 0, 0)
+	interval := period.Interval{Unit: period.Month}
+	base := normalizeCommodity(r.Form.Get("commodity"))
+	var err error
+	if v := r.Form.Get("start"); v != "" {
+		if start, err = utils.ParseDate(v); err != nil {
+			return start, end, interval, base, fmt.Errorf("Could not parse start (%v)", err)
+		}
+	}
+	if v := r.Form.Get("end"); v != "" {
+		if end, err = utils.ParseDate(v); err != nil {
+			return start, end, interval, base, fmt.Errorf("Could not parse end (%v)", err)
+		}
+	}
+	if v := r.Form.Get("interval"); v != "" {
+		if interval, err = period.Parse(v); err != nil {
+			return start, end, interval, base, fmt.Errorf("Could not parse interval (%v)", err)
+		}
+	}
+	return start, end, interval, base, nil
+}
@@ -0,0 +1,70 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/period"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestSummarizeNetWorth(t *testing.T) {
+	db := testutils.Db(t)
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2021, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		// the card is registered below an unregistered parent
+		for name, bt := range map[string]ledger.BucketType{
+			"assets":           ledger.Asset,
+			"liabilities:visa": ledger.Liability,
+			"income":           ledger.Income,
+			"expenses":         ledger.Expense,
+			"equity":           ledger.Equity,
+		} {
+			if err := ledger.RegisterBucket(tx, ledger.Bucket{Name: name, Type: bt, OpenedAt: testutils.BigBang}); err != nil {
+				return err
+			}
+		}
+		for _, e := range []ledger.Entry{
+			{Source: "equity", Destination: "assets:checking", EntryDate: date(1, 5), Amount: 1000},
+			{Source: "liabilities:visa", Destination: "expenses:food", EntryDate: date(1, 20), Amount: 200},
+			{Source: "assets:checking", Destination: "liabilities:visa", EntryDate: date(2, 10), Amount: 200},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		_, err := ledger.InsertSchedule(tx, ledger.Schedule{Source: "income:salary", Destination: "assets:checking",
+			Amount: 500, StartDate: date(3, 1), Frequency: "monthly"})
+		return err
+	})
+	var got ledger.NetWorth
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		got, err = ledger.SummarizeNetWorth(tx, date(1, 1), date(4, 30), date(3, 15), period.Interval{Unit: period.Month})
+		return err
+	})
+	testutils.AssertEqual(t, []string{"2021-01", "2021-02", "2021-03", "2021-04"}, got.Plot.DateHeaders)
+	testutils.AssertEqual(t, []string{"assets", "liabilities"}, got.Plot.BucketHeaders)
+	testutils.AssertEqual(t, [][]int{{1000, -200}, {800, 0}, {1300, 0}, {1800, 0}}, got.Plot.Data)
+	testutils.AssertEqual(t, []int{800, 800, 1300, 1800}, got.Totals)
+	testutils.AssertEqual(t, []int{800, 0, 500, 500}, got.Changes)
+	testutils.AssertEqual(t, []bool{false, false, true, true}, got.Projected)
+}
+
+func TestSummarizeNetWorthUnregistered(t *testing.T) {
+	db := testutils.Db(t)
+	// income is not registered, but its name marks it as income rather than
+	// an asset that went negative
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		return ledger.InsertEntry(tx, ledger.Entry{Source: "income", Destination: "checking", EntryDate: testutils.Date(1, 5), Amount: 1000})
+	})
+	var got ledger.NetWorth
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		got, err = ledger.SummarizeNetWorth(tx, testutils.Date(1, 1), testutils.Date(1, 31), testutils.Date(1, 31), period.Interval{Unit: period.Month})
+		return err
+	})
+	testutils.AssertEqual(t, []string{"checking"}, got.Plot.BucketHeaders)
+	testutils.AssertEqual(t, []int{1000}, got.Totals)
+}
@@ -0,0 +1,208 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/audit"
+	"ledger/pkg/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Status tracks whether an entry has shown up on a statement
+type Status string
+
+const (
+	Pending    Status = "pending"    // not yet seen on a statement
+	Cleared    Status = "cleared"    // seen on a statement being reconciled
+	Reconciled Status = "reconciled" // locked in by a finished reconciliation
+)
+
+// report whether s is one of the known statuses
+func (s Status) Valid() bool {
+	switch s {
+	case Pending, Cleared, Reconciled:
+		return true
+	}
+	return false
+}
+
+// Reconciliation compares a bucket against a statement. Uncleared holds every
+// entry through the statement date that is not yet reconciled, whether
+// pending or cleared.
+type Reconciliation struct {
+	Bucket            string
+	Commodity         string // what the statement and every balance are counted in
+	StatementDate     time.Time
+	StatementBalance  int
+	ReconciledBalance int // balance of entries reconciled by earlier statements
+	ClearedBalance    int // ReconciledBalance plus the cleared entries
+	Difference        int // StatementBalance less ClearedBalance
+	Uncleared         []Entry
+}
+
+// get the amount an entry adds to a bucket and its children
+func (r Reconciliation) signedAmount(e Entry) int {
+	sum := 0
+	if withinBucket(e.Destination, r.Bucket) {
+		sum += e.Amount
+	}
+	if withinBucket(e.Source, r.Bucket) {
+		sum -= e.Amount
+	}
+	return sum
+}
+
+// compare a bucket against a statement balance as of the statement date. A
+// statement is counted in one commodity, so buckets holding more than one are
+// refused rather than summed across commodities.
+func Reconcile(tx *sql.Tx, bucket string, statementDate time.Time, statementBalance int) (Reconciliation, error) {
+	r := Reconciliation{
+		Bucket:           bucket,
+		Commodity:        DefaultCommodity,
+		StatementDate:    statementDate,
+		StatementBalance: statementBalance,
+	}
+	q := `SELECT ` + entryColumns + ` FROM entries
+		WHERE (destination = $1 OR substr(destination, 1, length($1) + 1) = $1 || ':'
+			OR source = $1 OR substr(source, 1, length($1) + 1) = $1 || ':')
+		AND happened_at <= $2
+		ORDER BY happened_at, id;`
+	rows, err := tx.Query(q, bucket, utils.FormatDate(statementDate))
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("Reconcile() - querying entries: %w", err)
+	}
+	defer rows.Close()
+	for i := 0; rows.Next(); i++ {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return Reconciliation{}, fmt.Errorf("Reconcile() - scanning entry: %w", err)
+		}
+		if i == 0 {
+			r.Commodity = e.Commodity
+		} else if e.Commodity != r.Commodity {
+			return Reconciliation{}, fmt.Errorf("Reconcile() - %s holds both %s and %s", bucket, r.Commodity, e.Commodity)
+		}
+		switch e.Status {
+		case Reconciled:
+			r.ReconciledBalance += r.signedAmount(e)
+		case Cleared:
+			r.ClearedBalance += r.signedAmount(e)
+			r.Uncleared = append(r.Uncleared, e)
+		default:
+			r.Uncleared = append(r.Uncleared, e)
+		}
+	}
+	r.ClearedBalance += r.ReconciledBalance
+	r.Difference = r.StatementBalance - r.ClearedBalance
+	return r, nil
+}
+
+// mark entries as cleared or pending while reconciling. Legs of split
+// transactions may be marked too, since each shows up on its own statement.
+func SetStatus(tx *sql.Tx, status Status, ids ...int) error {
+	return audit.ChangeSet(tx, func() error {
+		if status != Pending && status != Cleared {
+			return fmt.Errorf("SetStatus() - entries can only be marked pending or cleared")
+		}
+		q := `UPDATE entries SET status = $1 WHERE id = $2 AND status != 'reconciled';`
+		for _, id := range ids {
+			before, err := audit.Snapshot(tx, "entries", id)
+			if err != nil {
+				return fmt.Errorf("SetStatus() - %w", err)
+			}
+			res, err := tx.Exec(q, status, id)
+			if err != nil {
+				return fmt.Errorf("SetStatus() - executing the update: %w", err)
+			}
+			if err := checkAffected(res, "unreconciled entry", id); err != nil {
+				return fmt.Errorf("SetStatus() - %w", err)
+			}
+			if err := audit.Updated(tx, "entries", id, before); err != nil {
+				return fmt.Errorf("SetStatus() - %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// lock in the cleared entries of a bucket as reconciled, once they account
+// for the statement balance exactly
+func FinishReconciliation(tx *sql.Tx, bucket string, statementDate time.Time, statementBalance int) (Reconciliation, error) {
+	var r Reconciliation
+	err := audit.ChangeSet(tx, func() (err error) {
+		r, err = finishReconciliation(tx, bucket, statementDate, statementBalance)
+		return err
+	})
+	return r, err
+}
+
+// see FinishReconciliation
+func finishReconciliation(tx *sql.Tx, bucket string, statementDate time.Time, statementBalance int) (Reconciliation, error) {
+	r, err := Reconcile(tx, bucket, statementDate, statementBalance)
+	if err != nil {
+		return Reconciliation{}, fmt.Errorf("FinishReconciliation() - %w", err)
+	}
+	if r.Difference != 0 {
+		return Reconciliation{}, fmt.Errorf("FinishReconciliation() - cleared entries are %d away from the statement balance", r.Difference)
+	}
+	var uncleared []Entry
+	for _, e := range r.Uncleared {
+		if e.Status != Cleared {
+			uncleared = append(uncleared, e)
+			continue
+		}
+		before, err := audit.Snapshot(tx, "entries", e.ID)
+		if err != nil {
+			return Reconciliation{}, fmt.Errorf("FinishReconciliation() - %w", err)
+		}
+		q := `UPDATE entries SET status = 'reconciled' WHERE id = $1;`
+		if _, err := tx.Exec(q, e.ID); err != nil {
+			return Reconciliation{}, fmt.Errorf("FinishReconciliation() - executing the update: %w", err)
+		}
+		if err := audit.Updated(tx, "entries", e.ID, before); err != nil {
+			return Reconciliation{}, fmt.Errorf("FinishReconciliation() - %w", err)
+		}
+	}
+	r.ReconciledBalance = r.ClearedBalance
+	r.Uncleared = uncleared
+	return r, nil
+}
+
+// parse a bucket, statement date and statement balance from a form
+func PrepareReconciliation(r *http.Request) (string, time.Time, int, error) {
+	r.ParseForm()
+	date, err := time.Parse("2006-01-02", r.Form.Get("statement_date"))
+	if err != nil {
+		return "", time.Time{}, 0, fmt.Errorf("Could not parse statement_date (%v)", err)
+	}
+	balance, err := strconv.Atoi(r.Form.Get("statement_balance"))
+	if err != nil {
+		return "", time.Time{}, 0, fmt.Errorf("Could not convert statement_balance field to int (%v)", err)
+	}
+	return r.Form.Get("bucket"), date, balance, nil
+}
+
+// parse the entries ticked as cleared on a reconciliation form, along with
+// the rest of the entries shown on it, which are left pending
+func PrepareClearedEntries(r *http.Request) ([]int, []int, error) {
+	r.ParseForm()
+	ticked := map[string]bool{}
+	for _, v := range r.PostForm["cleared"] {
+		ticked[v] = true
+	}
+	var cleared, pending []int
+	for _, v := range r.PostForm["shown"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not convert shown field to int (%v)", err)
+		}
+		if ticked[v] {
+			cleared = append(cleared, id)
+		} else {
+			pending = append(pending, id)
+		}
+	}
+	return cleared, pending, nil
+}
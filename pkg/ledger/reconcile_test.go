@@ -0,0 +1,105 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestReconcile(t *testing.T) {
+	db := testutils.Db(t)
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	statementDate := time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		for _, e := range []ledger.Entry{
+			{Source: "income", Destination: "checking", EntryDate: start, Amount: 1000},
+			{Source: "checking", Destination: "groceries", EntryDate: start.AddDate(0, 0, 3), Amount: 80},
+			// a check that has not been cashed yet
+			{Source: "checking", Destination: "rent", EntryDate: start.AddDate(0, 0, 28), Amount: 500},
+			// after the statement date
+			{Source: "checking", Destination: "fun", EntryDate: start.AddDate(0, 1, 0), Amount: 20},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	reconcile := func(t *testing.T, balance int) ledger.Reconciliation {
+		t.Helper()
+		var got ledger.Reconciliation
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			got, err = ledger.Reconcile(tx, "checking", statementDate, balance)
+			return err
+		})
+		return got
+	}
+	t.Run("every entry through the statement date starts out uncleared",
+		func(t *testing.T) {
+			got := reconcile(t, 920)
+			testutils.AssertEqual(t, 3, len(got.Uncleared))
+			testutils.AssertEqual(t, 920, got.Difference)
+		})
+	t.Run("finishing is refused until the difference is zero",
+		func(t *testing.T) {
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.SetStatus(tx, ledger.Cleared, 1)
+			})
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			defer tx.Rollback()
+			if _, err := ledger.FinishReconciliation(tx, "checking", statementDate, 920); err == nil {
+				t.Fatalf("want error for a nonzero difference, got nil")
+			}
+		})
+	t.Run("cleared entries are locked in",
+		func(t *testing.T) {
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				if err := ledger.SetStatus(tx, ledger.Cleared, 2); err != nil {
+					return err
+				}
+				got, err := ledger.FinishReconciliation(tx, "checking", statementDate, 920)
+				if err != nil {
+					return err
+				}
+				testutils.AssertEqual(t, 920, got.ReconciledBalance)
+				testutils.AssertEqual(t, 1, len(got.Uncleared))
+				return nil
+			})
+			got := reconcile(t, 420)
+			testutils.AssertEqual(t, 920, got.ReconciledBalance)
+			testutils.AssertEqual(t, -500, got.Difference)
+			testutils.AssertEqual(t, 3, got.Uncleared[0].ID)
+			// reconciled entries can no longer be changed
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			defer tx.Rollback()
+			if err := ledger.DeleteEntry(tx, 1); err == nil {
+				t.Fatalf("want error deleting a reconciled entry, got nil")
+			}
+			if err := ledger.SetStatus(tx, ledger.Pending, 2); err == nil {
+				t.Fatalf("want error marking a reconciled entry pending, got nil")
+			}
+		})
+	t.Run("buckets holding more than one commodity are refused",
+		func(t *testing.T) {
+			testutils.AssertEqual(t, ledger.DefaultCommodity, reconcile(t, 420).Commodity)
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.InsertEntry(tx, ledger.Entry{Source: "income", Destination: "checking", EntryDate: start, Amount: 50, Commodity: "EUR"})
+			})
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			defer tx.Rollback()
+			if _, err := ledger.Reconcile(tx, "checking", statementDate, 420); err == nil {
+				t.Fatalf("want error for a bucket holding USD and EUR, got nil")
+			}
+		})
+}
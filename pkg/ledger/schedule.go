@@ -0,0 +1,447 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Frequencies lists every frequency a schedule may repeat at. "semimonthly"
+// falls on the 1st and 15th, and "last-business-day" on the last weekday of
+// each month.
+var Frequencies = []string{"weekly", "biweekly", "semimonthly", "monthly", "quarterly", "yearly", "last-business-day"}
+
+// WeekendShifts lists the ways an occurrence that falls on a weekend may be
+// moved: not at all, back to the Friday before or on to the Monday after
+var WeekendShifts = []string{"", "friday", "monday"}
+
+// Schedule is a rule for an entry that repeats. Its occurrences are never
+// stored; summaries expand them on the fly, so editing or stopping the rule
+// changes every occurrence at once.
+type Schedule struct {
+	ID           int
+	Source       string
+	Destination  string
+	Amount       int
+	Commodity    string // what Amount is counted in; DefaultCommodity when left empty
+	Payee        string
+	Memo         string
+	Tags         []string
+	StartDate    time.Time // date of the first occurrence
+	Frequency    string    // one of Frequencies
+	Interval     int       // e.g. 2 with "weekly" repeats every other week; 0 means 1
+	WeekendShift string    // one of WeekendShifts
+	EndDate      time.Time // last possible occurrence, or zero to repeat forever
+	Count        int       // number of occurrences, or 0 for no limit
+}
+
+// get the nth date of a schedule before any weekend shift, counting from the
+// start date (or the start of its month, for frequencies tied to days of the
+// month) as 0. Dates before the start date are skipped by Occurrences.
+func (s Schedule) occurrence(n int) (time.Time, error) {
+	interval := s.Interval
+	if interval == 0 {
+		interval = 1
+	}
+	start := s.StartDate
+	month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, start.Location())
+	switch s.Frequency {
+	case "weekly":
+		return start.AddDate(0, 0, 7*n*interval), nil
+	case "biweekly":
+		return start.AddDate(0, 0, 14*n*interval), nil
+	case "semimonthly":
+		return addMonths(month, n/2*interval).AddDate(0, 0, 14*(n%2)), nil
+	case "monthly":
+		return addMonths(start, n*interval), nil
+	case "quarterly":
+		return addMonths(start, 3*n*interval), nil
+	case "yearly":
+		return addMonths(start, 12*n*interval), nil
+	case "last-business-day":
+		last := addMonths(month, n*interval).AddDate(0, 1, -1)
+		return shiftWeekend(last, "friday"), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown frequency %q", s.Frequency)
+}
+
+// move a date that falls on a weekend to the Friday before or the Monday
+// after, as given by shift
+func shiftWeekend(d time.Time, shift string) time.Time {
+	switch {
+	case d.Weekday() == time.Saturday && shift == "friday":
+		return d.AddDate(0, 0, -1)
+	case d.Weekday() == time.Saturday && shift == "monday":
+		return d.AddDate(0, 0, 2)
+	case d.Weekday() == time.Sunday && shift == "friday":
+		return d.AddDate(0, 0, -2)
+	case d.Weekday() == time.Sunday && shift == "monday":
+		return d.AddDate(0, 0, 1)
+	}
+	return d
+}
+
+// add months to a date, keeping to the last day of a shorter month instead
+// of spilling into the next one, e.g. Jan 31 + 1 month is Feb 28
+func addMonths(d time.Time, months int) time.Time {
+	first := time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, d.Location()).AddDate(0, months, 0)
+	last := first.AddDate(0, 1, -1).Day()
+	day := d.Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, d.Hour(), d.Minute(), d.Second(), d.Nanosecond(), d.Location())
+}
+
+// get the dates the schedule occurs on, from through through inclusive
+func (s Schedule) Occurrences(from, through time.Time) ([]time.Time, error) {
+	fromDay := from.Format("2006-01-02")
+	throughDay := through.Format("2006-01-02")
+	startDay := s.StartDate.Format("2006-01-02")
+	var output []time.Time
+	count := 0
+	for n := 0; s.Count == 0 || count < s.Count; n++ {
+		d, err := s.occurrence(n)
+		if err != nil {
+			return nil, err
+		}
+		if d.Format("2006-01-02") < startDay {
+			// e.g. the 1st of a semimonthly schedule that starts on the 5th
+			continue
+		}
+		count++
+		d = shiftWeekend(d, s.WeekendShift)
+		day := d.Format("2006-01-02")
+		if day > throughDay || (!s.EndDate.IsZero() && day > s.EndDate.Format("2006-01-02")) {
+			break
+		}
+		if day >= fromDay {
+			output = append(output, d)
+		}
+	}
+	return output, nil
+}
+
+// get the entry a schedule produces on a given date
+func (s Schedule) entry(d time.Time) Entry {
+	return Entry{
+		Source:      s.Source,
+		Destination: s.Destination,
+		EntryDate:   d,
+		Amount:      s.Amount,
+		Commodity:   s.Commodity,
+		Payee:       s.Payee,
+		Memo:        s.Memo,
+		ScheduleID:  s.ID,
+		Tags:        s.Tags,
+	}
+}
+
+func (s Schedule) validate(registry Registry) error {
+	if s.Interval < 0 || s.Count < 0 {
+		return fmt.Errorf("interval and count must not be negative")
+	}
+	if err := validateCommodity(s.Commodity); err != nil {
+		return err
+	}
+	if _, err := s.occurrence(0); err != nil {
+		return err
+	}
+	switch s.WeekendShift {
+	case "", "friday", "monday":
+	default:
+		return fmt.Errorf("unknown weekend shift %q", s.WeekendShift)
+	}
+	if !s.EndDate.IsZero() && s.EndDate.Before(s.StartDate) {
+		return fmt.Errorf("schedule ends before it starts")
+	}
+	return registry.validate(s.entry(s.StartDate))
+}
+
+// columns read by scanSchedule, in order
+var scheduleColumns = `id, source, destination, amount, commodity, payee, memo, started_at,
+	frequency, interval, weekend_shift, COALESCE(ended_at, ''), COALESCE(count, 0), ` + utils.TagsColumn("schedules", "schedule_id")
+
+// scan a single row of the schedules table into a Schedule
+func scanSchedule(row scanner) (Schedule, error) {
+	s := Schedule{}
+	var started, ended, tags string
+	if err := row.Scan(&s.ID, &s.Source, &s.Destination, &s.Amount, &s.Commodity, &s.Payee, &s.Memo, &started,
+		&s.Frequency, &s.Interval, &s.WeekendShift, &ended, &s.Count, &tags); err != nil {
+		return Schedule{}, err
+	}
+	s.Tags = utils.SplitTags(tags)
+	var err error
+	if s.StartDate, err = utils.ParseDate(started); err != nil {
+		return Schedule{}, err
+	}
+	if ended != "" {
+		if s.EndDate, err = utils.ParseDate(ended); err != nil {
+			return Schedule{}, err
+		}
+	}
+	return s, nil
+}
+
+// format an optional date for a nullable column
+func nullDate(d time.Time) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format("2006-01-02")
+}
+
+// insert a repeating entry and get its id
+func InsertSchedule(tx *sql.Tx, s Schedule) (int, error) {
+	s.Commodity = normalizeCommodity(s.Commodity)
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return 0, fmt.Errorf("InsertSchedule() - %w", err)
+	}
+	if err := s.validate(registry); err != nil {
+		return 0, fmt.Errorf("InsertSchedule() - %w", err)
+	}
+	if s.Interval == 0 {
+		s.Interval = 1
+	}
+	if err := checkScheduleOpen(tx, nil, &s); err != nil {
+		return 0, fmt.Errorf("InsertSchedule() - %w", err)
+	}
+	q := `INSERT INTO schedules
+		(source, destination, amount, commodity, payee, memo, started_at, frequency, interval, weekend_shift, ended_at, count)
+		VALUES ($1, $2, $3, $4, $5, $6, date($7), $8, $9, $10, NULLIF($11, ''), NULLIF($12, 0));`
+	res, err := tx.Exec(q, s.Source, s.Destination, s.Amount, s.Commodity, s.Payee, s.Memo,
+		s.StartDate.Format("2006-01-02"), s.Frequency, s.Interval, s.WeekendShift, nullDate(s.EndDate), s.Count)
+	if err != nil {
+		return 0, fmt.Errorf("InsertSchedule() - executing the insert: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("InsertSchedule() - getting schedule id: %w", err)
+	}
+	if err := utils.InsertTags(tx, "schedule_id", id, s.Tags); err != nil {
+		return 0, fmt.Errorf("InsertSchedule() - tagging schedule: %w", err)
+	}
+	return int(id), nil
+}
+
+// get a single repeating entry by its id
+func GetSchedule(tx *sql.Tx, id int) (Schedule, error) {
+	q := `SELECT ` + scheduleColumns + ` FROM schedules
+		WHERE id = $1;`
+	s, err := scanSchedule(tx.QueryRow(q, id))
+	if err == sql.ErrNoRows {
+		return Schedule{}, fmt.Errorf("GetSchedule() - no schedule with id %d", id)
+	} else if err != nil {
+		return Schedule{}, fmt.Errorf("GetSchedule() - querying schedule: %w", err)
+	}
+	return s, nil
+}
+
+// get every repeating entry, optionally only those carrying any of the given
+// tags
+func GetSchedules(tx *sql.Tx, tags ...string) ([]Schedule, error) {
+	tagFilter, tagArgs := utils.TagFilter("id", "schedule_id", tags, 1)
+	q := `SELECT ` + scheduleColumns + ` FROM schedules
+		WHERE 1` + tagFilter + `
+		ORDER BY id;`
+	rows, err := tx.Query(q, tagArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("GetSchedules() - querying schedules: %w", err)
+	}
+	defer rows.Close()
+	var schedules []Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("GetSchedules() - scanning schedule: %w", err)
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+// overwrite the repeating entry identified by s.ID, changing every one of
+// its occurrences
+func UpdateSchedule(tx *sql.Tx, s Schedule) error {
+	s.Commodity = normalizeCommodity(s.Commodity)
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return fmt.Errorf("UpdateSchedule() - %w", err)
+	}
+	if err := s.validate(registry); err != nil {
+		return fmt.Errorf("UpdateSchedule() - %w", err)
+	}
+	if s.Interval == 0 {
+		s.Interval = 1
+	}
+	before, err := GetSchedule(tx, s.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateSchedule() - %w", err)
+	}
+	if err := checkScheduleOpen(tx, &before, &s); err != nil {
+		return fmt.Errorf("UpdateSchedule() - %w", err)
+	}
+	q := `UPDATE schedules
+		SET source = $1, destination = $2, amount = $3, commodity = $4, payee = $5, memo = $6,
+			started_at = date($7), frequency = $8, interval = $9, weekend_shift = $10,
+			ended_at = NULLIF($11, ''), count = NULLIF($12, 0)
+		WHERE id = $13;`
+	res, err := tx.Exec(q, s.Source, s.Destination, s.Amount, s.Commodity, s.Payee, s.Memo,
+		s.StartDate.Format("2006-01-02"), s.Frequency, s.Interval, s.WeekendShift, nullDate(s.EndDate), s.Count, s.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateSchedule() - executing the update: %w", err)
+	}
+	if err := checkAffected(res, "schedule", s.ID); err != nil {
+		return fmt.Errorf("UpdateSchedule() - %w", err)
+	}
+	if err := utils.DeleteTags(tx, "schedule_id", int64(s.ID)); err != nil {
+		return fmt.Errorf("UpdateSchedule() - %w", err)
+	}
+	if err := utils.InsertTags(tx, "schedule_id", int64(s.ID), s.Tags); err != nil {
+		return fmt.Errorf("UpdateSchedule() - %w", err)
+	}
+	return nil
+}
+
+// end a repeating entry so that it no longer occurs after last
+func StopSchedule(tx *sql.Tx, id int, last time.Time) error {
+	before, err := GetSchedule(tx, id)
+	if err != nil {
+		return fmt.Errorf("StopSchedule() - %w", err)
+	}
+	after := before
+	after.EndDate = last
+	if err := checkScheduleOpen(tx, &before, &after); err != nil {
+		return fmt.Errorf("StopSchedule() - %w", err)
+	}
+	q := `UPDATE schedules SET ended_at = date($1) WHERE id = $2;`
+	res, err := tx.Exec(q, last.Format("2006-01-02"), id)
+	if err != nil {
+		return fmt.Errorf("StopSchedule() - executing the update: %w", err)
+	}
+	if err := checkAffected(res, "schedule", id); err != nil {
+		return fmt.Errorf("StopSchedule() - %w", err)
+	}
+	return nil
+}
+
+// remove a repeating entry along with all of its occurrences
+func DeleteSchedule(tx *sql.Tx, id int) error {
+	before, err := GetSchedule(tx, id)
+	if err != nil {
+		return fmt.Errorf("DeleteSchedule() - %w", err)
+	}
+	if err := checkScheduleOpen(tx, &before, nil); err != nil {
+		return fmt.Errorf("DeleteSchedule() - %w", err)
+	}
+	if err := utils.DeleteTags(tx, "schedule_id", int64(id)); err != nil {
+		return fmt.Errorf("DeleteSchedule() - %w", err)
+	}
+	q := `DELETE FROM schedules WHERE id = $1;`
+	res, err := tx.Exec(q, id)
+	if err != nil {
+		return fmt.Errorf("DeleteSchedule() - executing the delete: %w", err)
+	}
+	if err := checkAffected(res, "schedule", id); err != nil {
+		return fmt.Errorf("DeleteSchedule() - %w", err)
+	}
+	return nil
+}
+
+// get the occurrences of every schedule from start through end as entries,
+// optionally only for schedules carrying any of the given tags
+func scheduledEntries(tx *sql.Tx, start, end time.Time, tags ...string) ([]Entry, error) {
+	schedules, err := GetSchedules(tx, tags...)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, s := range schedules {
+		dates, err := s.Occurrences(start, end)
+		if err != nil {
+			return nil, fmt.Errorf("expanding schedule %d: %w", s.ID, err)
+		}
+		for _, d := range dates {
+			entries = append(entries, s.entry(d))
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].EntryDate.Before(entries[j].EntryDate)
+	})
+	return entries, nil
+}
+
+// get the net amount of each commodity that occurrences of repeating entries,
+// as expanded by scheduledEntries, add to a bucket and its children
+func scheduledAmount(entries []Entry, bucket string) Holdings {
+	sum := Holdings{}
+	for _, e := range entries {
+		if withinBucket(e.Destination, bucket) {
+			sum.add(e.Commodity, e.Amount)
+		}
+		if withinBucket(e.Source, bucket) {
+			sum.add(e.Commodity, -e.Amount)
+		}
+	}
+	return sum
+}
+
+// parse a repeating entry from a form
+func PrepareScheduleForInsert(r *http.Request) (Schedule, error) {
+	r.ParseForm()
+	start, err := utils.ParseDate(r.PostForm.Get("started_at"))
+	if err != nil {
+		return Schedule{}, fmt.Errorf("Could not parse started_at (%v)", err)
+	}
+	amount, err := strconv.Atoi(r.PostForm.Get("amount"))
+	if err != nil {
+		return Schedule{}, fmt.Errorf("Could not convert amount field to int (%v)", err)
+	}
+	s := Schedule{
+		Source:       r.PostForm.Get("source"),
+		Destination:  r.PostForm.Get("destination"),
+		Amount:       amount,
+		Commodity:    r.PostForm.Get("commodity"),
+		Payee:        r.PostForm.Get("payee"),
+		Memo:         r.PostForm.Get("memo"),
+		Tags:         utils.ParseTags(r.PostForm.Get("tags")),
+		StartDate:    start,
+		Frequency:    r.PostForm.Get("frequency"),
+		WeekendShift: r.PostForm.Get("weekend_shift"),
+	}
+	if v := r.PostForm.Get("interval"); v != "" {
+		if s.Interval, err = strconv.Atoi(v); err != nil {
+			return Schedule{}, fmt.Errorf("Could not convert interval field to int (%v)", err)
+		}
+	}
+	if v := r.PostForm.Get("ended_at"); v != "" {
+		if s.EndDate, err = utils.ParseDate(v); err != nil {
+			return Schedule{}, fmt.Errorf("Could not parse ended_at (%v)", err)
+		}
+	}
+	if v := r.PostForm.Get("count"); v != "" {
+		if s.Count, err = strconv.Atoi(v); err != nil {
+			return Schedule{}, fmt.Errorf("Could not convert count field to int (%v)", err)
+		}
+	}
+	return s, nil
+}
+
+// parse an edited repeating entry, including its id, from a form
+func PrepareScheduleForUpdate(r *http.Request) (Schedule, error) {
+	id, err := PrepareEntryID(r)
+	if err != nil {
+		return Schedule{}, err
+	}
+	s, err := PrepareScheduleForInsert(r)
+	if err != nil {
+		return Schedule{}, err
+	}
+	s.ID = id
+	return s, nil
+}
@@ -0,0 +1,161 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestOccurrences(t *testing.T) {
+	start := time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC)
+	day := func(m time.Month, d int) time.Time { return time.Date(2021, m, d, 0, 0, 0, 0, time.UTC) }
+	t.Run("monthly keeps to the end of short months", func(t *testing.T) {
+		s := ledger.Schedule{StartDate: start, Frequency: "monthly"}
+		want := []time.Time{day(1, 31), day(2, 28), day(3, 31), day(4, 30)}
+		got, err := s.Occurrences(start, day(4, 30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, want, got)
+	})
+	t.Run("every other week, limited by count", func(t *testing.T) {
+		s := ledger.Schedule{StartDate: start, Frequency: "weekly", Interval: 2, Count: 3}
+		want := []time.Time{day(2, 14), day(2, 28)}
+		got, err := s.Occurrences(day(2, 1), day(12, 31))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, want, got)
+	})
+	t.Run("semimonthly from the middle of a month", func(t *testing.T) {
+		s := ledger.Schedule{StartDate: day(1, 5), Frequency: "semimonthly", Count: 3}
+		want := []time.Time{day(1, 15), day(2, 1), day(2, 15)}
+		got, err := s.Occurrences(day(1, 1), day(12, 31))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, want, got)
+	})
+	t.Run("last business day of the month", func(t *testing.T) {
+		s := ledger.Schedule{StartDate: day(1, 1), Frequency: "last-business-day"}
+		// Jan 31 is a Sunday and Feb 28 is a Sunday
+		want := []time.Time{day(1, 29), day(2, 26), day(3, 31)}
+		got, err := s.Occurrences(day(1, 1), day(3, 31))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, want, got)
+	})
+	t.Run("quarterly, moved off weekends to monday", func(t *testing.T) {
+		s := ledger.Schedule{StartDate: day(2, 15), Frequency: "quarterly", WeekendShift: "monday"}
+		// May 15 is a Saturday and Aug 15 is a Sunday
+		want := []time.Time{day(2, 15), day(5, 17), day(8, 16), day(11, 15)}
+		got, err := s.Occurrences(start, day(12, 31))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, want, got)
+	})
+	t.Run("yearly from a leap day", func(t *testing.T) {
+		leap := time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)
+		s := ledger.Schedule{StartDate: leap, Frequency: "yearly"}
+		want := []time.Time{leap, time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC)}
+		got, err := s.Occurrences(leap, day(12, 31))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, want, got)
+	})
+	t.Run("limited by end date", func(t *testing.T) {
+		s := ledger.Schedule{StartDate: start, Frequency: "weekly", EndDate: day(2, 13)}
+		want := []time.Time{day(1, 31), day(2, 7)}
+		got, err := s.Occurrences(start, day(12, 31))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, want, got)
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	db := testutils.Db(t)
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	yearEnd := time.Date(2021, 12, 31, 0, 0, 0, 0, time.UTC)
+	rent := ledger.Schedule{
+		Source:      "checking",
+		Destination: "expenses:rent",
+		Amount:      1500,
+		Payee:       "landlord",
+		Tags:        []string{"home"},
+		StartDate:   start,
+		Frequency:   "monthly",
+	}
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		rent.ID, err = ledger.InsertSchedule(tx, rent)
+		return err
+	})
+	summarize := func(t *testing.T, tags ...string) map[string]int {
+		t.Helper()
+		var got map[string]int
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			got, err = ledger.SummarizeBalance(tx, []string{"checking", "expenses"}, testutils.BigBang, yearEnd, tags...)
+			return err
+		})
+		return got
+	}
+	t.Run("unknown frequency or weekend shift", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("starting tx: %v", err)
+		}
+		defer tx.Rollback()
+		if _, err := ledger.InsertSchedule(tx, ledger.Schedule{StartDate: start, Frequency: "fortnightly"}); err == nil {
+			t.Fatalf("want error for unknown frequency, got nil")
+		}
+		if _, err := ledger.InsertSchedule(tx, ledger.Schedule{StartDate: start, Frequency: "biweekly", WeekendShift: "sunday"}); err == nil {
+			t.Fatalf("want error for unknown weekend shift, got nil")
+		}
+	})
+	t.Run("summaries expand occurrences", func(t *testing.T) {
+		testutils.AssertEqual(t, map[string]int{"checking": -18000, "expenses": 18000}, summarize(t))
+		testutils.AssertEqual(t, map[string]int{"checking": -18000, "expenses": 18000}, summarize(t, "home"))
+		testutils.AssertEqual(t, map[string]int{"checking": 0, "expenses": 0}, summarize(t, "work"))
+	})
+	t.Run("ledger lists occurrences", func(t *testing.T) {
+		var got []ledger.Entry
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			got, err = ledger.GetLedger(tx, start, start.AddDate(0, 2, 0))
+			return err
+		})
+		testutils.AssertEqual(t, 2, len(got))
+		testutils.AssertEqual(t, rent.ID, got[1].ScheduleID)
+		testutils.AssertEqual(t, "landlord", got[1].Payee)
+	})
+	t.Run("rent change is a single update", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			rent.Amount = 1600
+			return ledger.UpdateSchedule(tx, rent)
+		})
+		testutils.AssertEqual(t, map[string]int{"checking": -19200, "expenses": 19200}, summarize(t))
+	})
+	t.Run("stopping a series", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			return ledger.StopSchedule(tx, rent.ID, time.Date(2021, 6, 15, 0, 0, 0, 0, time.UTC))
+		})
+		testutils.AssertEqual(t, map[string]int{"checking": -9600, "expenses": 9600}, summarize(t))
+		var buckets []string
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			buckets, err = ledger.GetBuckets(tx, "home")
+			return err
+		})
+		testutils.AssertEqual(t, []string{"checking", "expenses:rent"}, buckets)
+	})
+	t.Run("deleting a series", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			return ledger.DeleteSchedule(tx, rent.ID)
+		})
+		testutils.AssertEqual(t, map[string]int{"checking": 0, "expenses": 0}, summarize(t))
+	})
+}
@@ -0,0 +1,457 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reports are the financial statements GenerateStatement can build
+var Reports = []string{"income", "balance", "cashflow"}
+
+// Comparison picks an extra column to compare a statement against
+type Comparison string
+
+const (
+	PreviousPeriod Comparison = "previous"   // the period of the same length just before
+	PriorYear      Comparison = "prior-year" // the same period a year earlier
+)
+
+// Column is the period one column of a statement covers. Balance sheets
+// only use End.
+type Column struct {
+	Label      string
+	Start, End time.Time
+}
+
+// StatementLine is one bucket, or a total, with an amount for each column.
+// Amounts are normal balances, e.g. income earned is positive.
+type StatementLine struct {
+	Label   string
+	Bucket  string // empty for totals
+	Depth   int    // 1 for a top-level bucket
+	Amounts []int
+}
+
+// Section is a titled group of lines with their total, e.g. "Expenses"
+type Section struct {
+	Title string
+	Lines []StatementLine
+	Total StatementLine
+}
+
+// Statement is a financial statement: sections of bucket lines, then totals
+// drawn from them, e.g. net income
+type Statement struct {
+	Title    string
+	Columns  []Column
+	Sections []Section
+	Totals   []StatementLine
+}
+
+// StatementOptions choose a report, the period it covers and the columns it
+// is compared against. Cash lists the buckets a cash flow statement follows.
+// Amounts are converted into Commodity, DefaultCommodity when empty, at the
+// rates on the last day of each column.
+type StatementOptions struct {
+	Report    string
+	Start     time.Time
+	End       time.Time
+	Compare   []Comparison
+	Cash      []string
+	Commodity string
+}
+
+// get the column for start through end, followed by a column for each
+// comparison
+func StatementColumns(start, end time.Time, comparisons ...Comparison) []Column {
+	columns := []Column{periodColumn(start, end)}
+	for _, c := range comparisons {
+		switch c {
+		case PreviousPeriod:
+			if isWholeMonths(start, end) {
+				months := monthsBetween(start, end.AddDate(0, 0, 1))
+				columns = append(columns, periodColumn(start.AddDate(0, -months, 0), start.AddDate(0, 0, -1)))
+			} else {
+				days := int(end.Sub(start).Hours()/24+0.5) + 1
+				columns = append(columns, periodColumn(start.AddDate(0, 0, -days), start.AddDate(0, 0, -1)))
+			}
+		case PriorYear:
+			last := end.AddDate(-1, 0, 0)
+			if isWholeMonths(start, end) {
+				// keep month ends, e.g. Feb 29 becomes Feb 28
+				last = end.AddDate(0, 0, 1).AddDate(-1, 0, 0).AddDate(0, 0, -1)
+			}
+			columns = append(columns, periodColumn(start.AddDate(-1, 0, 0), last))
+		}
+	}
+	return columns
+}
+
+func periodColumn(start, end time.Time) Column {
+	return Column{Label: dayKey(start) + " to " + dayKey(end), Start: start, End: end}
+}
+
+// report whether start through end is a run of whole calendar months
+func isWholeMonths(start, end time.Time) bool {
+	return start.Day() == 1 && end.AddDate(0, 0, 1).Day() == 1
+}
+
+func monthsBetween(start, end time.Time) int {
+	return (end.Year()-start.Year())*12 + int(end.Month()-start.Month())
+}
+
+// build the statement the options ask for
+func GenerateStatement(tx *sql.Tx, o StatementOptions) (Statement, error) {
+	columns := StatementColumns(o.Start, o.End, o.Compare...)
+	base := normalizeCommodity(o.Commodity)
+	switch o.Report {
+	case "income":
+		return IncomeStatement(tx, base, columns)
+	case "balance":
+		return BalanceSheet(tx, base, columns)
+	case "cashflow":
+		return CashFlowStatement(tx, base, columns, o.Cash)
+	}
+	return Statement{}, fmt.Errorf("GenerateStatement() - unknown report %q, want one of %s", o.Report, strings.Join(Reports, ", "))
+}
+
+// get income and expenses in base over each column's period, leaving out the
+// entries that closed them into equity
+func IncomeStatement(tx *sql.Tx, base string, columns []Column) (Statement, error) {
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return Statement{}, fmt.Errorf("IncomeStatement() - %w", err)
+	}
+	prices, err := GetPrices(tx)
+	if err != nil {
+		return Statement{}, fmt.Errorf("IncomeStatement() - %w", err)
+	}
+	var changes []map[string]int
+	for _, c := range columns {
+		m, err := bucketChanges(tx, c.Start, c.End, false)
+		if err != nil {
+			return Statement{}, fmt.Errorf("IncomeStatement() - %w", err)
+		}
+		values, err := prices.values(m, base, c.End)
+		if err != nil {
+			return Statement{}, fmt.Errorf("IncomeStatement() - %w", err)
+		}
+		changes = append(changes, values)
+	}
+	income := makeSection("Income", changes, registry.normalSign, registry.ofType(Income))
+	expenses := makeSection("Expenses", changes, registry.normalSign, registry.ofType(Expense))
+	return Statement{
+		Title:    "Income statement",
+		Columns:  columns,
+		Sections: []Section{income, expenses},
+		Totals:   []StatementLine{difference("Net income", income.Total, expenses.Total)},
+	}, nil
+}
+
+// get assets, liabilities and equity in base at the end of each column.
+// Income and expenses not yet closed into equity show in it as net income.
+func BalanceSheet(tx *sql.Tx, base string, columns []Column) (Statement, error) {
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return Statement{}, fmt.Errorf("BalanceSheet() - %w", err)
+	}
+	prices, err := GetPrices(tx)
+	if err != nil {
+		return Statement{}, fmt.Errorf("BalanceSheet() - %w", err)
+	}
+	for i := range columns {
+		columns[i].Label = dayKey(columns[i].End)
+	}
+	var balances []map[string]int
+	for _, c := range columns {
+		m, err := bucketChanges(tx, utils.BigBang, c.End, true)
+		if err != nil {
+			return Statement{}, fmt.Errorf("BalanceSheet() - %w", err)
+		}
+		values, err := prices.values(m, base, c.End)
+		if err != nil {
+			return Statement{}, fmt.Errorf("BalanceSheet() - %w", err)
+		}
+		balances = append(balances, values)
+	}
+	assets := makeSection("Assets", balances, registry.normalSign, registry.ofType(Asset))
+	liabilities := makeSection("Liabilities", balances, registry.normalSign, registry.ofType(Liability))
+	equity := makeSection("Equity", balances, registry.normalSign, registry.ofType(Equity))
+	// earnings not yet closed, as a normal equity balance
+	earnings := StatementLine{Label: "Net income", Depth: 1}
+	for _, m := range balances {
+		net := 0
+		for b, v := range m {
+			if t := registry.Type(b); t == Income || t == Expense {
+				net -= v
+			}
+		}
+		earnings.Amounts = append(earnings.Amounts, net)
+	}
+	if !allZero(earnings.Amounts) {
+		equity.Lines = append(equity.Lines, earnings)
+		equity.Total = sum(equity.Total.Label, equity.Total, earnings)
+	}
+	return Statement{
+		Title:    "Balance sheet",
+		Columns:  columns,
+		Sections: []Section{assets, liabilities, equity},
+		Totals:   []StatementLine{sum("Liabilities and equity", liabilities.Total, equity.Total)},
+	}, nil
+}
+
+// get the money moving in and out of the cash buckets over each column's
+// period, by what it moved to or from: income and expenses are operating,
+// other assets are investing, and liabilities and equity are financing.
+// With no cash buckets given, every registered top-level asset bucket is
+// cash. Cash held in other commodities than base is valued at the rates on
+// each day it is counted, and the change in its worth shows as the effect of
+// exchange rates.
+func CashFlowStatement(tx *sql.Tx, base string, columns []Column, cash []string) (Statement, error) {
+	registry, err := GetRegistry(tx)
+	if err != nil {
+		return Statement{}, fmt.Errorf("CashFlowStatement() - %w", err)
+	}
+	prices, err := GetPrices(tx)
+	if err != nil {
+		return Statement{}, fmt.Errorf("CashFlowStatement() - %w", err)
+	}
+	if len(cash) == 0 {
+		for name, b := range registry {
+			outermost := true
+			for _, a := range BucketAncestors(name) {
+				if p, ok := registry[a]; ok && p.Type == Asset {
+					outermost = false
+				}
+			}
+			if b.Type == Asset && outermost {
+				cash = append(cash, name)
+			}
+		}
+		sort.Strings(cash)
+	}
+	if len(cash) == 0 {
+		return Statement{}, fmt.Errorf("CashFlowStatement() - no cash buckets given or registered")
+	}
+	isCash := func(name string) bool {
+		for _, c := range cash {
+			if withinBucket(name, c) {
+				return true
+			}
+		}
+		return false
+	}
+	var flows []map[string]int
+	opening := StatementLine{Label: "Cash at start", Depth: 1}
+	closing := StatementLine{Label: "Cash at end"}
+	for _, c := range columns {
+		entries, err := GetLedger(tx, c.Start, c.End.AddDate(0, 0, 1))
+		if err != nil {
+			return Statement{}, fmt.Errorf("CashFlowStatement() - %w", err)
+		}
+		m := map[string]int{}
+		for _, e := range entries {
+			if e.ClosingID != 0 || isCash(e.Source) == isCash(e.Destination) {
+				continue
+			}
+			amount, err := prices.Convert(e.Amount, e.Commodity, base, c.End)
+			if err != nil {
+				return Statement{}, fmt.Errorf("CashFlowStatement() - %w", err)
+			}
+			if isCash(e.Destination) {
+				m[e.Source] += amount
+			} else {
+				m[e.Destination] -= amount
+			}
+		}
+		flows = append(flows, m)
+		before, err := SummarizeBalanceIn(tx, base, cash, utils.BigBang, c.Start.AddDate(0, 0, -1))
+		if err != nil {
+			return Statement{}, fmt.Errorf("CashFlowStatement() - %w", err)
+		}
+		after, err := SummarizeBalanceIn(tx, base, cash, utils.BigBang, c.End)
+		if err != nil {
+			return Statement{}, fmt.Errorf("CashFlowStatement() - %w", err)
+		}
+		start, end := 0, 0
+		for _, b := range cash {
+			start += before[b]
+			end += after[b]
+		}
+		opening.Amounts = append(opening.Amounts, start)
+		closing.Amounts = append(closing.Amounts, end)
+	}
+	inflow := func(string) int { return 1 }
+	operating := makeSection("Operating", flows, inflow, registry.ofType(Income, Expense))
+	investing := makeSection("Investing", flows, inflow, registry.ofType(Asset))
+	financing := makeSection("Financing", flows, inflow, registry.ofType(Liability, Equity))
+	change := sum("Net change in cash", operating.Total, investing.Total, financing.Total)
+	totals := []StatementLine{change, opening}
+	if rates := difference("Effect of exchange rates", closing, sum("", opening, change)); !allZero(rates.Amounts) {
+		totals = append(totals, rates)
+	}
+	return Statement{
+		Title:    "Cash flow statement",
+		Columns:  columns,
+		Sections: []Section{operating, investing, financing},
+		Totals:   append(totals, closing),
+	}, nil
+}
+
+// get the sign that turns a stored amount in a bucket into a normal balance
+func (r Registry) normalSign(name string) int {
+	return r.Type(name).Sign()
+}
+
+// get a filter for the buckets of the given types
+func (r Registry) ofType(types ...BucketType) func(string) bool {
+	return func(name string) bool {
+		t := r.Type(name)
+		for _, want := range types {
+			if t == want {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// build a section from the amounts each bucket gained in each column, not
+// counting children, keeping the buckets that pass keep. Parents are rolled
+// up from their children, and buckets that are zero in every column are
+// left out.
+func makeSection(title string, columns []map[string]int, sign func(string) int, keep func(string) bool) Section {
+	rolled := map[string][]int{}
+	for i, m := range columns {
+		for b, v := range m {
+			if !keep(b) || v == 0 {
+				continue
+			}
+			for _, name := range append(BucketAncestors(b), b) {
+				if !keep(name) {
+					continue
+				}
+				if rolled[name] == nil {
+					rolled[name] = make([]int, len(columns))
+				}
+				rolled[name][i] += sign(name) * v
+			}
+		}
+	}
+	s := Section{Title: title, Total: StatementLine{Label: "Total " + strings.ToLower(title), Amounts: make([]int, len(columns))}}
+	names := []string{}
+	for name := range rolled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if allZero(rolled[name]) {
+			continue
+		}
+		label := name[strings.LastIndex(name, BucketSeparator)+1:]
+		s.Lines = append(s.Lines, StatementLine{Label: label, Bucket: name, Depth: BucketDepth(name), Amounts: rolled[name]})
+	}
+	// total the outermost buckets kept, so that nothing counts twice
+	for _, name := range names {
+		outermost := true
+		for _, a := range BucketAncestors(name) {
+			if _, ok := rolled[a]; ok {
+				outermost = false
+			}
+		}
+		if outermost {
+			for i, v := range rolled[name] {
+				s.Total.Amounts[i] += v
+			}
+		}
+	}
+	return s
+}
+
+// add up lines column by column
+func sum(label string, lines ...StatementLine) StatementLine {
+	total := StatementLine{Label: label}
+	for _, l := range lines {
+		for i, v := range l.Amounts {
+			if i == len(total.Amounts) {
+				total.Amounts = append(total.Amounts, 0)
+			}
+			total.Amounts[i] += v
+		}
+	}
+	return total
+}
+
+// subtract b from a column by column
+func difference(label string, a, b StatementLine) StatementLine {
+	total := StatementLine{Label: label, Amounts: make([]int, len(a.Amounts))}
+	for i := range a.Amounts {
+		total.Amounts[i] = a.Amounts[i] - b.Amounts[i]
+	}
+	return total
+}
+
+func allZero(amounts []int) bool {
+	for _, v := range amounts {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// parse a comma-separated list of comparisons
+func ParseComparisons(s string) ([]Comparison, error) {
+	var comparisons []Comparison
+	for _, c := range utils.ParseTags(s) {
+		switch Comparison(c) {
+		case PreviousPeriod, PriorYear:
+			comparisons = append(comparisons, Comparison(c))
+		default:
+			return nil, fmt.Errorf("unknown comparison %q, want %s or %s", c, PreviousPeriod, PriorYear)
+		}
+	}
+	return comparisons, nil
+}
+
+// parse statement options from a form or query string. The period defaults
+// to the year to date, the report to an income statement, and the commodity
+// to DefaultCommodity.
+func PrepareStatement(r *http.Request) (StatementOptions, error) {
+	r.ParseForm()
+	now := time.Now()
+	o := StatementOptions{
+		Report:    r.Form.Get("report"),
+		Start:     time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.Local),
+		End:       time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local),
+		Cash:      utils.ParseTags(r.Form.Get("cash")),
+		Commodity: normalizeCommodity(r.Form.Get("commodity")),
+	}
+	if o.Report == "" {
+		o.Report = "income"
+	}
+	var err error
+	if v := r.Form.Get("start"); v != "" {
+		if o.Start, err = time.Parse("2006-01-02", v); err != nil {
+			return StatementOptions{}, fmt.Errorf("Could not parse start (%v)", err)
+		}
+	}
+	if v := r.Form.Get("end"); v != "" {
+		if o.End, err = time.Parse("2006-01-02", v); err != nil {
+			return StatementOptions{}, fmt.Errorf("Could not parse end (%v)", err)
+		}
+	}
+	for _, v := range r.Form["compare"] {
+		c, err := ParseComparisons(v)
+		if err != nil {
+			return StatementOptions{}, err
+		}
+		o.Compare = append(o.Compare, c...)
+	}
+	return o, nil
+}
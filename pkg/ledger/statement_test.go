@@ -0,0 +1,108 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func TestStatementColumns(t *testing.T) {
+	labels := func(columns []ledger.Column) []string {
+		output := []string{}
+		for _, c := range columns {
+			output = append(output, c.Label)
+		}
+		return output
+	}
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	t.Run("whole months compare against whole months", func(t *testing.T) {
+		got := ledger.StatementColumns(date(2024, 1, 1), date(2024, 2, 29), ledger.PreviousPeriod, ledger.PriorYear)
+		testutils.AssertEqual(t, []string{"2024-01-01 to 2024-02-29", "2023-11-01 to 2023-12-31", "2023-01-01 to 2023-02-28"}, labels(got))
+	})
+	t.Run("other periods compare against the same number of days", func(t *testing.T) {
+		got := ledger.StatementColumns(date(2024, 3, 10), date(2024, 3, 16), ledger.PreviousPeriod)
+		testutils.AssertEqual(t, []string{"2024-03-10 to 2024-03-16", "2024-03-03 to 2024-03-09"}, labels(got))
+	})
+}
+
+func TestStatements(t *testing.T) {
+	db := testutils.Db(t)
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2021, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		for name, bt := range map[string]ledger.BucketType{
+			"assets":      ledger.Asset,
+			"liabilities": ledger.Liability,
+			"income":      ledger.Income,
+			"expenses":    ledger.Expense,
+			"equity":      ledger.Equity,
+		} {
+			if err := ledger.RegisterBucket(tx, ledger.Bucket{Name: name, Type: bt, OpenedAt: testutils.BigBang}); err != nil {
+				return err
+			}
+		}
+		for _, e := range []ledger.Entry{
+			{Source: "equity", Destination: "assets:checking", EntryDate: date(1, 1), Amount: 100},
+			{Source: "income:salary", Destination: "assets:checking", EntryDate: date(1, 15), Amount: 1000},
+			{Source: "assets:checking", Destination: "expenses:food", EntryDate: date(2, 1), Amount: 200},
+			{Source: "liabilities:visa", Destination: "expenses:fun", EntryDate: date(4, 2), Amount: 50},
+			{Source: "assets:checking", Destination: "assets:house", EntryDate: date(4, 3), Amount: 300},
+			{Source: "income:salary", Destination: "assets:checking", EntryDate: date(4, 15), Amount: 1000},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		// closing entries stay out of the income statement
+		_, err := ledger.ClosePeriod(tx, date(3, 31), "equity")
+		return err
+	})
+	generate := func(t *testing.T, o ledger.StatementOptions) ledger.Statement {
+		t.Helper()
+		var s ledger.Statement
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			s, err = ledger.GenerateStatement(tx, o)
+			return err
+		})
+		return s
+	}
+	total := func(s ledger.Statement, label string) []int {
+		for _, l := range s.Totals {
+			if l.Label == label {
+				return l.Amounts
+			}
+		}
+		t.Fatalf("no total %q in %s", label, s.Title)
+		return nil
+	}
+	t.Run("income statement with the previous quarter", func(t *testing.T) {
+		s := generate(t, ledger.StatementOptions{Report: "income", Start: date(4, 1), End: date(6, 30), Compare: []ledger.Comparison{ledger.PreviousPeriod}})
+		testutils.AssertEqual(t, []int{1000, 1000}, s.Sections[0].Total.Amounts)
+		testutils.AssertEqual(t, []int{50, 200}, s.Sections[1].Total.Amounts)
+		testutils.AssertEqual(t, []int{950, 800}, total(s, "Net income"))
+	})
+	t.Run("balance sheet balances", func(t *testing.T) {
+		s := generate(t, ledger.StatementOptions{Report: "balance", Start: date(4, 1), End: date(6, 30), Compare: []ledger.Comparison{ledger.PreviousPeriod}})
+		testutils.AssertEqual(t, []string{"2021-06-30", "2021-03-31"}, []string{s.Columns[0].Label, s.Columns[1].Label})
+		testutils.AssertEqual(t, []int{1900, 900}, s.Sections[0].Total.Amounts)
+		testutils.AssertEqual(t, s.Sections[0].Total.Amounts, total(s, "Liabilities and equity"))
+		// the closed quarter's earnings are in equity, the open quarter's
+		// still show as net income
+		equity := s.Sections[2]
+		testutils.AssertEqual(t, "Net income", equity.Lines[len(equity.Lines)-1].Label)
+		testutils.AssertEqual(t, []int{950, 0}, equity.Lines[len(equity.Lines)-1].Amounts)
+	})
+	t.Run("cash flow by activity", func(t *testing.T) {
+		s := generate(t, ledger.StatementOptions{Report: "cashflow", Start: date(4, 1), End: date(6, 30), Cash: []string{"assets:checking"}})
+		testutils.AssertEqual(t, []int{1000}, s.Sections[0].Total.Amounts)
+		testutils.AssertEqual(t, []int{-300}, s.Sections[1].Total.Amounts)
+		testutils.AssertEqual(t, []int{0}, s.Sections[2].Total.Amounts)
+		testutils.AssertEqual(t, []int{900}, total(s, "Cash at start"))
+		testutils.AssertEqual(t, []int{1600}, total(s, "Cash at end"))
+	})
+}
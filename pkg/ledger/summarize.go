@@ -3,6 +3,7 @@ package ledger
 import (
 	"database/sql"
 	"fmt"
+	"ledger/pkg/period"
 	"ledger/pkg/utils"
 	"sort"
 	"time"
@@ -14,54 +15,158 @@ type PlotData struct {
 	Data          [][]int
 }
 
-// get all entries in the ledger from start through finish
-func GetLedger(tx *sql.Tx, start, end time.Time) ([]Entry, error) {
-	q := `SELECT * FROM entries
-		WHERE date(happened_at) >= date($1) AND date(happened_at) < date($2)
-		ORDER BY happened_at;`
+// get all entries in the ledger from start through finish, including the
+// occurrences of repeating entries, optionally only those carrying any of the
+// given tags. Entries that void another follow it directly when both are in
+// range.
+func GetLedger(tx *sql.Tx, start, end time.Time, tags ...string) ([]Entry, error) {
+	tagFilter, tagArgs := utils.TagFilter("id", "entry_id", tags, 3)
+	q := `SELECT ` + entryColumns + ` FROM entries
+		WHERE happened_at >= $1 AND happened_at < $2` + tagFilter + `
+		ORDER BY happened_at, id;`
 
-	rows, err := tx.Query(q, start, end)
+	rows, err := tx.Query(q, append([]interface{}{utils.FormatDate(start), utils.FormatDate(end)}, tagArgs...)...)
 	if err != nil {
 		return nil, fmt.Errorf("Could not Query sql (%v)", err)
 	}
 	defer rows.Close()
 	var ledger []Entry
 	for rows.Next() {
-		e := Entry{}
-		var datestring string
-		if err := rows.Scan(&e.Source, &e.Destination, &datestring, &e.Amount); err != nil {
-			return nil, err
-		}
-		if e.EntryDate, err = time.Parse("2006-01-02 15:04:05-07:00", datestring); err != nil {
+		e, err := scanEntry(rows)
+		if err != nil {
 			return nil, err
 		}
 		ledger = append(ledger, e)
 	}
-	return ledger, nil
+	scheduled, err := scheduledEntries(tx, start, end.AddDate(0, 0, -1), tags...)
+	if err != nil {
+		return nil, fmt.Errorf("GetLedger() - %w", err)
+	}
+	ledger = append(ledger, scheduled...)
+	sort.SliceStable(ledger, func(i, j int) bool {
+		return ledger[i].EntryDate.Before(ledger[j].EntryDate)
+	})
+	return groupVoids(ledger), nil
+}
+
+// get net amount of a single bucket over a given time in DefaultCommodity,
+// see SummarizeBucketIn
+func SummarizeBucket(tx *sql.Tx, bucket string, start, end time.Time, tags ...string) (int, error) {
+	return SummarizeBucketIn(tx, DefaultCommodity, bucket, start, end, tags...)
+}
+
+// get net amount of a single bucket over a given time, with every commodity
+// it holds converted into base at the rates on end. See SummarizeHoldings.
+func SummarizeBucketIn(tx *sql.Tx, base, bucket string, start, end time.Time, tags ...string) (int, error) {
+	holdings, err := SummarizeHoldings(tx, bucket, start, end, tags...)
+	if err != nil {
+		return -1, err
+	}
+	v, err := valueIn(tx, holdings, normalizeCommodity(base), end)
+	if err != nil {
+		return -1, fmt.Errorf("summarizeBucket() - %w", err)
+	}
+	return v, nil
 }
 
-// get net amount of a single bucket over a given time
-func SummarizeBucket(tx *sql.Tx, bucket string, start, end time.Time) (int, error) {
-	q := `SELECT COALESCE(sum(amount), 0) FROM (
-		SELECT amount, happened_at FROM entries WHERE destination = $1
+// get the net amount of each commodity a single bucket gained over a given
+// time, optionally counting only entries that carry any of the given tags. A
+// parent bucket such as "assets:bank" includes every bucket below it, e.g.
+// "assets:bank:checking". Repeating entries count once for each occurrence.
+// Untagged balances from utils.BigBang start from the opening balance of the
+// last closed period.
+func SummarizeHoldings(tx *sql.Tx, bucket string, start, end time.Time, tags ...string) (Holdings, error) {
+	holdings, err := summarizeHoldings(tx, []string{bucket}, start, end, tags...)
+	if err != nil {
+		return nil, err
+	}
+	return holdings[bucket], nil
+}
+
+// get the holdings of each of buckets over a given time, see
+// SummarizeHoldings. Repeating entries are expanded once for all of them.
+func summarizeHoldings(tx *sql.Tx, buckets []string, start, end time.Time, tags ...string) (map[string]Holdings, error) {
+	output := map[string]Holdings{}
+	for _, b := range buckets {
+		output[b] = Holdings{}
+	}
+	if len(tags) == 0 && dayKey(start) == dayKey(utils.BigBang) {
+		c, closed, err := latestClosing(tx)
+		if err != nil {
+			return nil, fmt.Errorf("summarizeBucket() - %w", err)
+		}
+		if closed && dayKey(end) >= dayKey(c.ClosedThrough) {
+			q := `SELECT commodity, amount FROM opening_balances WHERE closing_id = $1 AND bucket = $2;`
+			for _, b := range buckets {
+				if err := scanHoldings(tx, output[b], q, c.ID, b); err != nil {
+					return nil, fmt.Errorf("summarizeBucket() - querying opening balance: %w", err)
+				}
+			}
+			start = c.ClosedThrough.AddDate(0, 0, 1)
+		}
+	}
+	tagFilter, tagArgs := utils.TagFilter("id", "entry_id", tags, 4)
+	q := `SELECT commodity, sum(amount) FROM (
+		SELECT id, amount, commodity, happened_at FROM entries
+		WHERE destination = $1 OR substr(destination, 1, length($1) + 1) = $1 || ':'
 		UNION ALL
-		SELECT -amount, happened_at from entries where source = $1
+		SELECT id, -amount, commodity, happened_at from entries
+		WHERE source = $1 OR substr(source, 1, length($1) + 1) = $1 || ':'
 		)
-		WHERE date(happened_at) BETWEEN date($2) AND date($3)
-		ORDER BY sum(amount) DESC;`
-	row := tx.QueryRow(q, bucket, start, end)
-	var sum int
-	if err := row.Scan(&sum); err != nil {
-		return -1, fmt.Errorf("summarizeBucket() - querying rows: %w", err)
+		WHERE happened_at BETWEEN $2 AND $3` + tagFilter + `
+		GROUP BY commodity;`
+	for _, b := range buckets {
+		args := append([]interface{}{b, utils.FormatDate(start), utils.FormatDate(end)}, tagArgs...)
+		if err := scanHoldings(tx, output[b], q, args...); err != nil {
+			return nil, fmt.Errorf("summarizeBucket() - querying rows: %w", err)
+		}
+	}
+	scheduled, err := scheduledEntries(tx, start, end, tags...)
+	if err != nil {
+		return nil, fmt.Errorf("summarizeBucket() - %w", err)
+	}
+	for _, b := range buckets {
+		output[b].addAll(scheduledAmount(scheduled, b))
+	}
+	return output, nil
+}
+
+// add up the commodity and amount rows of a query into holdings
+func scanHoldings(tx *sql.Tx, holdings Holdings, q string, args ...interface{}) error {
+	rows, err := tx.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var commodity string
+		var amount int
+		if err := rows.Scan(&commodity, &amount); err != nil {
+			return err
+		}
+		holdings.add(commodity, amount)
 	}
-	return sum, nil
+	return rows.Err()
 }
 
-// get net amounts of provided buckets over a given time
-func SummarizeBalance(tx *sql.Tx, buckets []string, from, through time.Time) (map[string]int, error) {
+// get net amounts of provided buckets over a given time in DefaultCommodity,
+// see SummarizeBalanceIn
+func SummarizeBalance(tx *sql.Tx, buckets []string, from, through time.Time, tags ...string) (map[string]int, error) {
+	return SummarizeBalanceIn(tx, DefaultCommodity, buckets, from, through, tags...)
+}
+
+// get net amounts of provided buckets over a given time, optionally counting
+// only entries that carry any of the given tags, converted into base at the
+// rates on through. Parent buckets are rolled up from their children, see
+// SummarizeBucket.
+func SummarizeBalanceIn(tx *sql.Tx, base string, buckets []string, from, through time.Time, tags ...string) (map[string]int, error) {
+	holdings, err := summarizeHoldings(tx, buckets, from, through, tags...)
+	if err != nil {
+		return nil, fmt.Errorf("calling SummarizeCategory() (%v)", err)
+	}
 	output := map[string]int{}
 	for _, b := range buckets {
-		val, err := SummarizeBucket(tx, b, from, through)
+		val, err := valueIn(tx, holdings[b], normalizeCommodity(base), through)
 		if err != nil {
 			return nil, fmt.Errorf("calling SummarizeCategory() (%v)", err)
 		}
@@ -70,36 +175,174 @@ func SummarizeBalance(tx *sql.Tx, buckets []string, from, through time.Time) (ma
 	return output, nil
 }
 
-// get daily balances (starting from bigBang) of provided buckets over a given time
+// get daily balances of provided buckets over a given time in
+// DefaultCommodity, see SummarizeBalanceOverTimeIn
 func SummarizeBalanceOverTime(tx *sql.Tx, buckets []string, start, end time.Time) ([]map[string]int, error) {
-	bigBang := utils.BigBang
+	return SummarizeBalanceOverTimeIn(tx, DefaultCommodity, buckets, start, end)
+}
+
+// get daily balances (starting from bigBang) of provided buckets over a given
+// time, each converted into base at the rates on its day; buckets may be
+// parents, which are rolled up from their children. The ledger is read once,
+// with balances kept as a running sum, from the opening balances of the last
+// period closed before start.
+func SummarizeBalanceOverTimeIn(tx *sql.Tx, base string, buckets []string, start, end time.Time) ([]map[string]int, error) {
+	base = normalizeCommodity(base)
+	from, opening := utils.BigBang, map[string]Holdings{}
+	c, closed, err := latestClosing(tx)
+	if err != nil {
+		return nil, fmt.Errorf("ledger.SummarizeBalanceOverTime() (%w)", err)
+	}
+	if closed && dayKey(c.ClosedThrough) < dayKey(start) {
+		if opening, err = openingBalances(tx, c.ID); err != nil {
+			return nil, fmt.Errorf("ledger.SummarizeBalanceOverTime() (%w)", err)
+		}
+		from = c.ClosedThrough.AddDate(0, 0, 1)
+	}
+	changes, err := dailyChanges(tx, buckets, from, end)
+	if err != nil {
+		return nil, fmt.Errorf("ledger.SummarizeBalanceOverTime() summarizing ledger (%w)", err)
+	}
+	prices, err := GetPrices(tx)
+	if err != nil {
+		return nil, fmt.Errorf("ledger.SummarizeBalanceOverTime() (%w)", err)
+	}
+	// open with everything before the start date
+	balance := map[string]Holdings{}
+	for _, b := range buckets {
+		balance[b] = Holdings{}
+		balance[b].addAll(opening[b])
+	}
+	startDay := dayKey(start)
+	for day, c := range changes {
+		if day < startDay {
+			for b, h := range c {
+				balance[b].addAll(h)
+			}
+		}
+	}
 	output := []map[string]int{}
 	for d := start; d.Before(end.AddDate(0, 0, 1)); d = d.AddDate(0, 0, 1) {
-		// summarize from beginning of time through date iterator
-		balance, err := SummarizeBalance(tx, buckets, bigBang, d)
-		if err != nil {
-			return nil, fmt.Errorf("ledger.SummarizeBalanceOverTime() summarizing ledger (%w)", err)
+		for b, h := range changes[dayKey(d)] {
+			balance[b].addAll(h)
+		}
+		day := map[string]int{}
+		for b, h := range balance {
+			if day[b], err = prices.Value(h, base, d); err != nil {
+				return nil, fmt.Errorf("ledger.SummarizeBalanceOverTime() valuing %s (%w)", b, err)
+			}
 		}
-		output = append(output, balance)
+		output = append(output, day)
 	}
 	return output, nil
 }
 
-// get totals over time, grouped into provided intervals of time
-func SummarizeLedgerOverTime(tx *sql.Tx, buckets []string, start, end time.Time, interval int) ([]map[string]int, error) {
+// get totals over time in DefaultCommodity, see SummarizeLedgerOverTimeIn
+func SummarizeLedgerOverTime(tx *sql.Tx, buckets []string, start, end time.Time, interval period.Interval) ([]map[string]int, error) {
+	return SummarizeLedgerOverTimeIn(tx, DefaultCommodity, buckets, start, end, interval)
+}
+
+// get totals over time, grouped into periods of interval, with each day's
+// amounts converted into base at that day's rates. Periods are whole, so
+// calendar intervals may reach back before start, and the last period runs
+// its full length, even past end.
+func SummarizeLedgerOverTimeIn(tx *sql.Tx, base string, buckets []string, start, end time.Time, interval period.Interval) ([]map[string]int, error) {
+	base = normalizeCommodity(base)
+	periods := interval.Periods(start, end)
 	output := []map[string]int{}
-	for d := start; d.Before(end.AddDate(0, 0, 1)); d = d.AddDate(0, 0, interval) {
+	if len(periods) == 0 {
+		return output, nil
+	}
+	changes, err := dailyChanges(tx, buckets, periods[0].Start, periods[len(periods)-1].Last)
+	if err != nil {
+		return nil, fmt.Errorf("ledger.SummarizeEntriesOverTime() summarizing ledger (%w)", err)
+	}
+	prices, err := GetPrices(tx)
+	if err != nil {
+		return nil, fmt.Errorf("ledger.SummarizeEntriesOverTime() (%w)", err)
+	}
+	for _, p := range periods {
 		// summarize from the start to end of an interval period
-		l, err := SummarizeBalance(tx, buckets, d, d.AddDate(0, 0, interval-1))
-		if err != nil {
-			return nil, fmt.Errorf("ledger.SummarizeEntriesOverTime() summarizing ledger (%w)", err)
+		l := map[string]int{}
+		for _, b := range buckets {
+			l[b] = 0
+		}
+		for d := p.Start; !d.After(p.Last); d = d.AddDate(0, 0, 1) {
+			for b, h := range changes[dayKey(d)] {
+				v, err := prices.Value(h, base, d)
+				if err != nil {
+					return nil, fmt.Errorf("ledger.SummarizeEntriesOverTime() valuing %s (%w)", b, err)
+				}
+				l[b] += v
+			}
 		}
 		output = append(output, l)
 	}
 	return output, nil
 }
 
-func MakePlot(summary []map[string]int, start time.Time, interval int) *PlotData {
+// get the net amount of each commodity each of buckets gained on each day
+// from from through through, keyed by day and then bucket, with a single scan
+// of the ledger. Parent buckets include their children, and repeating entries
+// count on each occurrence.
+func dailyChanges(tx *sql.Tx, buckets []string, from, through time.Time) (map[string]map[string]Holdings, error) {
+	wanted := map[string]bool{}
+	for _, b := range buckets {
+		wanted[b] = true
+	}
+	changes := map[string]map[string]Holdings{}
+	add := func(day, name, commodity string, amount int) {
+		for _, b := range append(BucketAncestors(name), name) {
+			if !wanted[b] {
+				continue
+			}
+			if changes[day] == nil {
+				changes[day] = map[string]Holdings{}
+			}
+			if changes[day][b] == nil {
+				changes[day][b] = Holdings{}
+			}
+			changes[day][b].add(commodity, amount)
+		}
+	}
+	q := `SELECT happened_at, source, destination, amount, commodity FROM entries
+		WHERE happened_at BETWEEN $1 AND $2;`
+	rows, err := tx.Query(q, utils.FormatDate(from), utils.FormatDate(through))
+	if err != nil {
+		return nil, fmt.Errorf("dailyChanges() - querying entries: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day, source, destination, commodity string
+		var amount int
+		if err := rows.Scan(&day, &source, &destination, &amount, &commodity); err != nil {
+			return nil, fmt.Errorf("dailyChanges() - scanning entry: %w", err)
+		}
+		add(day, destination, commodity, amount)
+		add(day, source, commodity, -amount)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("dailyChanges() - reading entries: %w", err)
+	}
+	scheduled, err := scheduledEntries(tx, from, through)
+	if err != nil {
+		return nil, fmt.Errorf("dailyChanges() - %w", err)
+	}
+	for _, e := range scheduled {
+		add(dayKey(e.EntryDate), e.Destination, e.Commodity, e.Amount)
+		add(dayKey(e.EntryDate), e.Source, e.Commodity, -e.Amount)
+	}
+	return changes, nil
+}
+
+// get the day a time falls on as stored in the entries table
+func dayKey(t time.Time) string {
+	return utils.FormatDate(t)
+}
+
+// prepare data to be used in html template, with a row for each period of
+// interval from start
+func MakePlot(summary []map[string]int, start time.Time, interval period.Interval) *PlotData {
 	output := &PlotData{}
 
 	if len(summary) > 0 {
@@ -108,8 +351,10 @@ func MakePlot(summary []map[string]int, start time.Time, interval int) *PlotData
 		}
 		sort.Strings(output.BucketHeaders)
 
-		for i, day := range summary {
-			output.DateHeaders = append(output.DateHeaders, start.AddDate(0, 0, i*interval).Format("2006-01-02"))
+		d := interval.Start(start)
+		for _, day := range summary {
+			output.DateHeaders = append(output.DateHeaders, interval.Label(d))
+			d = interval.Next(d)
 			row := []int{}
 			for _, b := range output.BucketHeaders {
 				row = append(row, day[b])
@@ -121,14 +366,30 @@ func MakePlot(summary []map[string]int, start time.Time, interval int) *PlotData
 	return output
 }
 
-func GetBuckets(tx *sql.Tx) ([]string, error) {
+// get the names of all buckets used by entries, repeating entries or
+// registered, optionally only those touched by entries that carry any of the
+// given tags
+func GetBuckets(tx *sql.Tx, tags ...string) ([]string, error) {
+	// both filters number their placeholders from $1, sharing the same args
+	entryFilter, tagArgs := utils.TagFilter("id", "entry_id", tags, 1)
+	scheduleFilter, _ := utils.TagFilter("id", "schedule_id", tags, 1)
+	registered := `
+		    UNION
+		    SELECT name FROM buckets`
+	if len(tags) > 0 {
+		registered = ""
+	}
 	q := `SELECT DISTINCT buckets FROM (
-		    SELECT source AS buckets FROM entries
+		    SELECT source AS buckets FROM entries WHERE 1` + entryFilter + `
+		    UNION
+		    SELECT destination FROM entries WHERE 1` + entryFilter + `
+		    UNION
+		    SELECT source FROM schedules WHERE 1` + scheduleFilter + `
 		    UNION
-		    SELECT destination AS buckets FROM entries
+		    SELECT destination FROM schedules WHERE 1` + scheduleFilter + registered + `
 		) ORDER BY buckets
 	;`
-	rows, err := tx.Query(q)
+	rows, err := tx.Query(q, tagArgs...)
 	if err != nil {
 		return nil, err
 	}
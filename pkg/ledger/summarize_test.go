@@ -3,6 +3,7 @@ package ledger_test
 import (
 	"database/sql"
 	"ledger/pkg/ledger"
+	"ledger/pkg/period"
 	"ledger/pkg/testutils"
 	"testing"
 	"time"
@@ -15,15 +16,18 @@ func TestGetLedger(t *testing.T) {
 			start := time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local)
 			end := start.AddDate(0, 0, 1)
 			input := ledger.Entry{
-				"savings",
-				"checking",
-				start,
-				100,
+				Source:      "savings",
+				Destination: "checking",
+				EntryDate:   start,
+				Amount:      100,
 			}
 			testutils.Tx(t, db, func(tx *sql.Tx) error {
 				err := ledger.InsertEntry(tx, input)
 				return err
 			})
+			input.ID = 1
+			input.Commodity = ledger.DefaultCommodity
+			input.Status = ledger.Pending
 			want := []ledger.Entry{input}
 			var got []ledger.Entry
 			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
@@ -32,6 +36,72 @@ func TestGetLedger(t *testing.T) {
 			})
 			testutils.AssertEqual(t, want, got)
 		})
+	t.Run("entry with payee and memo",
+		func(t *testing.T) {
+			start := time.Date(2005, 3, 1, 0, 0, 0, 0, time.Local)
+			end := start.AddDate(0, 0, 1)
+			input := ledger.Entry{
+				Source:      "savings",
+				Destination: "checking",
+				EntryDate:   start,
+				Amount:      2500,
+				Payee:       "landlord",
+				Memo:        "cover march rent",
+			}
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.InsertEntry(tx, input)
+			})
+			input.ID = 2
+			input.Commodity = ledger.DefaultCommodity
+			input.Status = ledger.Pending
+			want := []ledger.Entry{input}
+			var got []ledger.Entry
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.GetLedger(tx, start, end)
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+		})
+	t.Run("filter by tag",
+		func(t *testing.T) {
+			start := time.Date(2006, 7, 1, 0, 0, 0, 0, time.Local)
+			end := start.AddDate(0, 0, 1)
+			tagged := ledger.Entry{
+				Source:      "checking",
+				Destination: "travel",
+				EntryDate:   start,
+				Amount:      800,
+				Tags:        []string{"reimbursable", "vacation-2026"},
+			}
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				if err := ledger.InsertEntry(tx, tagged); err != nil {
+					return err
+				}
+				return ledger.InsertEntry(tx, ledger.Entry{
+					Source:      "checking",
+					Destination: "groceries",
+					EntryDate:   start,
+					Amount:      60,
+				})
+			})
+			tagged.ID = 3
+			tagged.Commodity = ledger.DefaultCommodity
+			tagged.Status = ledger.Pending
+			want := []ledger.Entry{tagged}
+			var got []ledger.Entry
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.GetLedger(tx, start, end, "vacation-2026", "wedding")
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+			// summarize only the tagged spending out of checking
+			var balance map[string]int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				balance, err = ledger.SummarizeBalance(tx, []string{"checking"}, start, end, "reimbursable")
+				return err
+			})
+			testutils.AssertEqual(t, map[string]int{"checking": -800}, balance)
+		})
 }
 
 func TestSummarizeBalance(t *testing.T) {
@@ -94,9 +164,9 @@ func TestSummarizeBalanceOverTime(t *testing.T) {
 		start := time.Now()
 
 		input := []ledger.Entry{
-			{bucket1, bucket2, start, 100},
-			{bucket1, bucket2, start.AddDate(0, 0, 1), 100},
-			{bucket1, bucket2, start.AddDate(0, 0, 2), 100},
+			{Source: bucket1, Destination: bucket2, EntryDate: start, Amount: 100},
+			{Source: bucket1, Destination: bucket2, EntryDate: start.AddDate(0, 0, 1), Amount: 100},
+			{Source: bucket1, Destination: bucket2, EntryDate: start.AddDate(0, 0, 2), Amount: 100},
 		}
 
 		testutils.Tx(t, db, func(tx *sql.Tx) error {
@@ -124,6 +194,35 @@ func TestSummarizeBalanceOverTime(t *testing.T) {
 		})
 		testutils.AssertEqual(t, want, got)
 	})
+	t.Run("matches daily balances, with parents and repeating entries", func(t *testing.T) {
+		db := testutils.Db(t)
+		start := testutils.JanOne
+		end := start.AddDate(0, 2, 0)
+		buckets := []string{"assets", "assets:checking", "income", "expenses:rent", "unused"}
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			if err := insertSeries(tx, 300, start.AddDate(0, 0, -30)); err != nil {
+				return err
+			}
+			_, err := ledger.InsertSchedule(tx, ledger.Schedule{
+				Source:      "assets:checking",
+				Destination: "expenses:rent",
+				Amount:      700,
+				StartDate:   start.AddDate(0, 0, -10),
+				Frequency:   "monthly",
+			})
+			return err
+		})
+		var want, got []map[string]int
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			want, err = dailyBalances(tx, buckets, start, end)
+			if err != nil {
+				return err
+			}
+			got, err = ledger.SummarizeBalanceOverTime(tx, buckets, start, end)
+			return err
+		})
+		testutils.AssertEqual(t, want, got)
+	})
 }
 
 func TestSummarizeLedgerOverTime(t *testing.T) {
@@ -139,7 +238,7 @@ func TestSummarizeLedgerOverTime(t *testing.T) {
 					[]string{},
 					today,
 					today,
-					1, // interval: summarize daily
+					period.Days(1), // interval: summarize daily
 				)
 				return err
 			})
@@ -167,7 +266,7 @@ func TestSummarizeLedgerOverTime(t *testing.T) {
 				return err
 			})
 			// summarize transactions daily
-			interval := 1 // group daily
+			interval := period.Days(1) // group daily
 			want := []map[string]int{
 				{"checking": 100, "savings": -100},
 				{"checking": 100, "savings": -100},
@@ -188,12 +287,136 @@ func TestSummarizeLedgerOverTime(t *testing.T) {
 		})
 }
 
+func TestSummarizeLedgerOverTimeCalendar(t *testing.T) {
+	db := testutils.Db(t)
+	jan31 := time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC)
+	input := []ledger.Entry{
+		{Source: "income", Destination: "checking", EntryDate: testutils.Dec31, Amount: 100},
+		{Source: "income", Destination: "checking", EntryDate: jan31, Amount: 200},
+		{Source: "income", Destination: "checking", EntryDate: jan31.AddDate(0, 0, 1), Amount: 400},
+	}
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		for _, e := range input {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	// a start mid-month still covers the whole month
+	start := time.Date(2021, 1, 15, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 2, 3, 0, 0, 0, 0, time.UTC)
+	month := period.Interval{Unit: period.Month}
+	var got []map[string]int
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		got, err = ledger.SummarizeLedgerOverTime(tx, []string{"checking"}, start, end, month)
+		return err
+	})
+	testutils.AssertEqual(t, []map[string]int{{"checking": 200}, {"checking": 400}}, got)
+	want := &ledger.PlotData{
+		[]string{"checking"},
+		[]string{"2021-01", "2021-02"},
+		[][]int{{200}, {400}},
+	}
+	testutils.AssertEqual(t, want, ledger.MakePlot(got, start, month))
+}
+
+func TestSummarizeLedgerOverTimeIntervals(t *testing.T) {
+	db := testutils.Db(t)
+	start := testutils.JanOne
+	end := start.AddDate(0, 1, 0)
+	buckets := []string{"assets", "assets:savings", "expenses"}
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		return insertSeries(tx, 200, start)
+	})
+	for _, days := range []int{1, 7, 30, 45} {
+		interval := period.Days(days)
+		var want, got []map[string]int
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			for d := start; d.Before(end.AddDate(0, 0, 1)); d = d.AddDate(0, 0, days) {
+				l, err := ledger.SummarizeBalance(tx, buckets, d, d.AddDate(0, 0, days-1))
+				if err != nil {
+					return err
+				}
+				want = append(want, l)
+			}
+			got, err = ledger.SummarizeLedgerOverTime(tx, buckets, start, end, interval)
+			return err
+		})
+		testutils.AssertEqual(t, want, got)
+	}
+}
+
+func BenchmarkSummarizeBalanceOverTime(b *testing.B) {
+	db := testutils.Db(b)
+	start := testutils.JanOne
+	testutils.Tx(b, db, func(tx *sql.Tx) error {
+		return insertSeries(tx, 100000, start.AddDate(-1, 0, 0))
+	})
+	buckets := []string{"assets", "assets:checking", "assets:savings", "income", "expenses", "expenses:rent"}
+	end := start.AddDate(0, 0, 29)
+	b.Run("single scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			testutils.Tx(b, db, func(tx *sql.Tx) error {
+				_, err := ledger.SummarizeBalanceOverTime(tx, buckets, start, end)
+				return err
+			})
+		}
+	})
+	b.Run("query per day", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			testutils.Tx(b, db, func(tx *sql.Tx) error {
+				_, err := dailyBalances(tx, buckets, start, end)
+				return err
+			})
+		}
+	})
+}
+
+// insert n entries, one every few hours from start, moving money between a
+// handful of buckets at several levels
+func insertSeries(tx *sql.Tx, n int, start time.Time) error {
+	moves := [][2]string{
+		{"income", "assets:checking"},
+		{"assets:checking", "assets:savings"},
+		{"assets:checking", "expenses:rent"},
+		{"assets:savings", "expenses:food"},
+		{"assets:checking", "expenses"},
+	}
+	for i := 0; i < n; i++ {
+		m := moves[i%len(moves)]
+		e := ledger.Entry{
+			Source:      m[0],
+			Destination: m[1],
+			EntryDate:   start.Add(time.Duration(i*5) * time.Hour),
+			Amount:      i%97 + 1,
+		}
+		if err := ledger.InsertEntry(tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// balances computed the straightforward way, one query per day
+func dailyBalances(tx *sql.Tx, buckets []string, start, end time.Time) ([]map[string]int, error) {
+	output := []map[string]int{}
+	for d := start; d.Before(end.AddDate(0, 0, 1)); d = d.AddDate(0, 0, 1) {
+		balance, err := ledger.SummarizeBalance(tx, buckets, testutils.BigBang, d)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, balance)
+	}
+	return output, nil
+}
+
 func TestMakePlot(t *testing.T) {
 	t.Run("empty summary (zero entries)", func(t *testing.T) {
 		summary := []map[string]int{}
 		start := time.Now()
 		want := &ledger.PlotData{}
-		got := ledger.MakePlot(summary, start, 1)
+		got := ledger.MakePlot(summary, start, period.Days(1))
 		testutils.AssertEqual(t, want, got)
 	})
 	t.Run("one entry", func(t *testing.T) {
@@ -206,7 +429,7 @@ func TestMakePlot(t *testing.T) {
 			[][]int{{100, -100}},
 		}
 
-		got := ledger.MakePlot(summary, start, 1)
+		got := ledger.MakePlot(summary, start, period.Days(1))
 		testutils.AssertEqual(t, want, got)
 	})
 	t.Run("two entries over two days", func(t *testing.T) {
@@ -219,7 +442,7 @@ func TestMakePlot(t *testing.T) {
 			[]string{startString, tomorrowString},
 			[][]int{{0, 100, -100}, {50, 50, -100}},
 		}
-		got := ledger.MakePlot(summary, start, 1)
+		got := ledger.MakePlot(summary, start, period.Days(1))
 		testutils.AssertEqual(t, want, got)
 	})
 }
@@ -228,10 +451,10 @@ func TestGetBuckets(t *testing.T) {
 	db := testutils.Db(t)
 	t.Run("one transaction, two buckets", func(t *testing.T) {
 		input := ledger.Entry{
-			"savings",
-			"checking",
-			time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local),
-			100,
+			Source:      "savings",
+			Destination: "checking",
+			EntryDate:   time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local),
+			Amount:      100,
 		}
 		testutils.Tx(t, db, func(tx *sql.Tx) error {
 			return ledger.InsertEntry(tx, input)
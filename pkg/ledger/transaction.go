@@ -0,0 +1,351 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/audit"
+	"ledger/pkg/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Posting is one leg of a split transaction. A positive amount flows into the
+// bucket and a negative amount flows out of it.
+type Posting struct {
+	Bucket string
+	Amount int
+}
+
+// Transaction groups any number of postings that happen together and must
+// sum to zero, e.g. a paycheck split into taxes, 401k and a net deposit.
+// Every posting is counted in the same commodity.
+type Transaction struct {
+	ID        int
+	EntryDate time.Time
+	Commodity string // DefaultCommodity when left empty
+	Status    Status // status of every posting; Pending when left empty
+	Payee     string
+	Memo      string
+	Tags      []string
+	Postings  []Posting
+}
+
+// check that a transaction's postings balance and name each bucket once
+func (t Transaction) validate() error {
+	if len(t.Postings) < 2 {
+		return fmt.Errorf("transaction needs at least two postings, got %d", len(t.Postings))
+	}
+	seen := map[string]bool{}
+	sum := 0
+	for _, p := range t.Postings {
+		if p.Bucket == "" {
+			return fmt.Errorf("posting is missing a bucket")
+		}
+		if seen[p.Bucket] {
+			return fmt.Errorf("bucket %s appears in more than one posting", p.Bucket)
+		}
+		seen[p.Bucket] = true
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("postings must sum to zero, got %d", sum)
+	}
+	return nil
+}
+
+// split the postings into source -> destination entries; money flows from
+// the negative postings into the positive ones in the order they were given
+func (t Transaction) Entries() []Entry {
+	var credits, debits []Posting
+	for _, p := range t.Postings {
+		if p.Amount < 0 {
+			credits = append(credits, Posting{p.Bucket, -p.Amount})
+		} else if p.Amount > 0 {
+			debits = append(debits, p)
+		}
+	}
+	var entries []Entry
+	for i, j := 0, 0; i < len(credits) && j < len(debits); {
+		amount := credits[i].Amount
+		if debits[j].Amount < amount {
+			amount = debits[j].Amount
+		}
+		entries = append(entries, Entry{
+			Source:        credits[i].Bucket,
+			Destination:   debits[j].Bucket,
+			EntryDate:     t.EntryDate,
+			Amount:        amount,
+			Commodity:     t.Commodity,
+			Status:        t.Status,
+			Payee:         t.Payee,
+			Memo:          t.Memo,
+			TransactionID: t.ID,
+			Tags:          t.Tags,
+		})
+		credits[i].Amount -= amount
+		debits[j].Amount -= amount
+		if credits[i].Amount == 0 {
+			i++
+		}
+		if debits[j].Amount == 0 {
+			j++
+		}
+	}
+	return entries
+}
+
+// Insert a split transaction. All of its entries are written in tx, so they
+// are committed or rolled back together. Returns the new transaction id.
+func InsertTransaction(tx *sql.Tx, t Transaction) (int, error) {
+	var id int
+	err := audit.ChangeSet(tx, func() (err error) {
+		id, err = insertTransaction(tx, t)
+		return err
+	})
+	return id, err
+}
+
+// see InsertTransaction
+func insertTransaction(tx *sql.Tx, t Transaction) (int, error) {
+	if err := t.validate(); err != nil {
+		return -1, fmt.Errorf("InsertTransaction() - %w", err)
+	}
+	q := `INSERT INTO transactions (happened_at) VALUES ($1);`
+	res, err := tx.Exec(q, utils.FormatDate(t.EntryDate))
+	if err != nil {
+		return -1, fmt.Errorf("InsertTransaction() - inserting transaction: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return -1, fmt.Errorf("InsertTransaction() - getting transaction id: %w", err)
+	}
+	t.ID = int(id)
+	if err := audit.Inserted(tx, "transactions", t.ID); err != nil {
+		return -1, fmt.Errorf("InsertTransaction() - %w", err)
+	}
+	for _, e := range t.Entries() {
+		if err := InsertEntry(tx, e); err != nil {
+			return -1, fmt.Errorf("InsertTransaction() - inserting postings: %w", err)
+		}
+	}
+	return t.ID, nil
+}
+
+// insert entries in order, taking those that share a TransactionID as the
+// legs of one split transaction, inserted whole where its first leg falls.
+// The ids only group the entries, so the split gets a new id when inserted.
+func InsertEntries(tx *sql.Tx, entries []Entry) error {
+	return audit.ChangeSet(tx, func() error {
+		splits := map[int][]Entry{}
+		for _, e := range entries {
+			if e.TransactionID != 0 {
+				splits[e.TransactionID] = append(splits[e.TransactionID], e)
+			}
+		}
+		for _, e := range entries {
+			if e.TransactionID == 0 {
+				if err := InsertEntry(tx, e); err != nil {
+					return fmt.Errorf("InsertEntries() - %w", err)
+				}
+				continue
+			}
+			group, ok := splits[e.TransactionID]
+			if !ok {
+				continue
+			}
+			delete(splits, e.TransactionID)
+			t := EntriesToTransaction(group)
+			t.ID = 0
+			if _, err := InsertTransaction(tx, t); err != nil {
+				return fmt.Errorf("InsertEntries() - %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// get a split transaction by its id, with one posting per bucket in the
+// order the buckets first appear in its entries
+func GetTransaction(tx *sql.Tx, id int) (Transaction, error) {
+	q := `SELECT ` + entryColumns + ` FROM entries
+		WHERE transaction_id = $1
+		ORDER BY id;`
+	rows, err := tx.Query(q, id)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("GetTransaction() - querying entries: %w", err)
+	}
+	defer rows.Close()
+	var entries []Entry
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return Transaction{}, err
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return Transaction{}, fmt.Errorf("GetTransaction() - no transaction with id %d", id)
+	}
+	return EntriesToTransaction(entries), nil
+}
+
+// get all split transactions from start until end, ordered by date,
+// optionally only those carrying any of the given tags
+func GetTransactions(tx *sql.Tx, start, end time.Time, tags ...string) ([]Transaction, error) {
+	tagFilter, tagArgs := utils.TagFilter("id", "entry_id", tags, 3)
+	q := `SELECT ` + entryColumns + ` FROM entries
+		WHERE transaction_id IS NOT NULL
+		AND happened_at >= $1 AND happened_at < $2` + tagFilter + `
+		ORDER BY happened_at, transaction_id, id;`
+	rows, err := tx.Query(q, append([]interface{}{utils.FormatDate(start), utils.FormatDate(end)}, tagArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("GetTransactions() - querying entries: %w", err)
+	}
+	defer rows.Close()
+	var transactions []Transaction
+	var group []Entry
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(group) > 0 && group[0].TransactionID != e.TransactionID {
+			transactions = append(transactions, EntriesToTransaction(group))
+			group = nil
+		}
+		group = append(group, e)
+	}
+	if len(group) > 0 {
+		transactions = append(transactions, EntriesToTransaction(group))
+	}
+	return transactions, nil
+}
+
+// remove a split transaction and all of its entries
+func DeleteTransaction(tx *sql.Tx, id int) error {
+	return audit.ChangeSet(tx, func() error {
+		// keep the entries as they were for the audit log
+		rows, err := tx.Query(`SELECT id FROM entries WHERE transaction_id = $1 ORDER BY id;`, id)
+		if err != nil {
+			return fmt.Errorf("DeleteTransaction() - querying entries: %w", err)
+		}
+		var ids []int
+		for rows.Next() {
+			var entryID int
+			if err := rows.Scan(&entryID); err != nil {
+				rows.Close()
+				return fmt.Errorf("DeleteTransaction() - scanning entry id: %w", err)
+			}
+			ids = append(ids, entryID)
+		}
+		rows.Close()
+		for _, entryID := range ids {
+			if _, err := checkEditable(tx, entryID, id); err != nil {
+				return fmt.Errorf("DeleteTransaction() - %w", err)
+			}
+		}
+		// the transaction is logged after its entries, so that undoing the delete
+		// puts it back before them
+		transaction, err := audit.Snapshot(tx, "transactions", id)
+		if err != nil {
+			return fmt.Errorf("DeleteTransaction() - %w", err)
+		}
+		before := map[int]audit.Row{}
+		for _, entryID := range ids {
+			if before[entryID], err = audit.Snapshot(tx, "entries", entryID); err != nil {
+				return fmt.Errorf("DeleteTransaction() - %w", err)
+			}
+		}
+		q := `DELETE FROM tags WHERE entry_id IN (SELECT id FROM entries WHERE transaction_id = $1);`
+		if _, err := tx.Exec(q, id); err != nil {
+			return fmt.Errorf("DeleteTransaction() - deleting tags: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM entries WHERE transaction_id = $1;`, id); err != nil {
+			return fmt.Errorf("DeleteTransaction() - deleting entries: %w", err)
+		}
+		res, err := tx.Exec(`DELETE FROM transactions WHERE id = $1;`, id)
+		if err != nil {
+			return fmt.Errorf("DeleteTransaction() - deleting transaction: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("DeleteTransaction() - checking rows affected: %w", err)
+		}
+		if n != 1 {
+			return fmt.Errorf("DeleteTransaction() - no transaction with id %d", id)
+		}
+		for _, entryID := range ids {
+			if err := audit.Deleted(tx, "entries", entryID, before[entryID]); err != nil {
+				return fmt.Errorf("DeleteTransaction() - %w", err)
+			}
+		}
+		if err := audit.Deleted(tx, "transactions", id, transaction); err != nil {
+			return fmt.Errorf("DeleteTransaction() - %w", err)
+		}
+		return nil
+	})
+}
+
+// net the entries of one transaction back into its postings, the reverse of
+// Transaction.Entries
+func EntriesToTransaction(entries []Entry) Transaction {
+	t := Transaction{
+		ID:        entries[0].TransactionID,
+		EntryDate: entries[0].EntryDate,
+		Commodity: entries[0].Commodity,
+		Status:    entries[0].Status,
+		Payee:     entries[0].Payee,
+		Memo:      entries[0].Memo,
+		Tags:      entries[0].Tags,
+	}
+	index := map[string]int{}
+	add := func(bucket string, amount int) {
+		i, ok := index[bucket]
+		if !ok {
+			i = len(t.Postings)
+			index[bucket] = i
+			t.Postings = append(t.Postings, Posting{Bucket: bucket})
+		}
+		t.Postings[i].Amount += amount
+	}
+	for _, e := range entries {
+		add(e.Source, -e.Amount)
+		add(e.Destination, e.Amount)
+	}
+	return t
+}
+
+// parse a split transaction from a form with repeated bucket and amount
+// fields; rows with an empty bucket are ignored
+func PrepareTransactionForInsert(r *http.Request) (Transaction, error) {
+	r.ParseForm()
+	entrydate, err := time.Parse("2006-01-02", r.PostForm.Get("happened_at"))
+	if err != nil {
+		return Transaction{}, fmt.Errorf("Could not parse entrydate (%v)", err)
+	}
+	buckets := r.PostForm["bucket"]
+	amounts := r.PostForm["amount"]
+	if len(buckets) != len(amounts) {
+		return Transaction{}, fmt.Errorf("Got %d buckets but %d amounts", len(buckets), len(amounts))
+	}
+	t := Transaction{
+		EntryDate: entrydate,
+		Commodity: r.PostForm.Get("commodity"),
+		Payee:     r.PostForm.Get("payee"),
+		Memo:      r.PostForm.Get("memo"),
+		Tags:      utils.ParseTags(r.PostForm.Get("tags")),
+	}
+	for i, b := range buckets {
+		if strings.TrimSpace(b) == "" {
+			continue
+		}
+		amount, err := strconv.Atoi(amounts[i])
+		if err != nil {
+			return Transaction{}, fmt.Errorf("Could not convert amount field to int (%v)", err)
+		}
+		t.Postings = append(t.Postings, Posting{Bucket: strings.TrimSpace(b), Amount: amount})
+	}
+	return t, nil
+}
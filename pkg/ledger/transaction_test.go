@@ -0,0 +1,200 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func paycheck(d time.Time) ledger.Transaction {
+	return ledger.Transaction{
+		EntryDate: d,
+		Postings: []ledger.Posting{
+			{Bucket: "income", Amount: -5000},
+			{Bucket: "taxes", Amount: 1000},
+			{Bucket: "401k", Amount: 500},
+			{Bucket: "insurance", Amount: 200},
+			{Bucket: "checking", Amount: 3300},
+		},
+	}
+}
+
+func TestInsertTransaction(t *testing.T) {
+	db := testutils.Db(t)
+	bigBang := testutils.BigBang
+	entryDate := time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local)
+	t.Run("paycheck split five ways",
+		func(t *testing.T) {
+			input := paycheck(entryDate)
+			var id int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				id, err = ledger.InsertTransaction(tx, input)
+				return err
+			})
+			// every posting lands in its own bucket
+			for _, p := range input.Postings {
+				var got int
+				testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+					got, err = ledger.SummarizeBucket(tx, p.Bucket, bigBang, entryDate)
+					return err
+				})
+				testutils.AssertEqual(t, p.Amount, got)
+			}
+			// the postings can be read back as they were given
+			want := input
+			want.ID = id
+			want.Commodity = ledger.DefaultCommodity
+			want.Status = ledger.Pending
+			var got ledger.Transaction
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.GetTransaction(tx, id)
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+		})
+	t.Run("unbalanced postings are rejected",
+		func(t *testing.T) {
+			input := paycheck(entryDate)
+			input.Postings[4].Amount = 3000
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			defer tx.Rollback()
+			if _, err := ledger.InsertTransaction(tx, input); err == nil {
+				t.Fatalf("want error for unbalanced transaction, got nil")
+			}
+		})
+}
+
+func TestGetTransactions(t *testing.T) {
+	db := testutils.Db(t)
+	start := time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local)
+	t.Run("split transactions alongside a standalone entry",
+		func(t *testing.T) {
+			var ids []int
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				if err := ledger.InsertEntry(tx, ledger.Entry{
+					Source:      "savings",
+					Destination: "checking",
+					EntryDate:   start,
+					Amount:      100,
+				}); err != nil {
+					return err
+				}
+				for i := 0; i < 2; i++ {
+					id, err := ledger.InsertTransaction(tx, paycheck(start.AddDate(0, 0, i)))
+					if err != nil {
+						return err
+					}
+					ids = append(ids, id)
+				}
+				return nil
+			})
+			var got []ledger.Transaction
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.GetTransactions(tx, start, start.AddDate(0, 0, 2))
+				return err
+			})
+			testutils.AssertEqual(t, 2, len(got))
+			for i, id := range ids {
+				testutils.AssertEqual(t, id, got[i].ID)
+				testutils.AssertEqual(t, paycheck(start).Postings, got[i].Postings)
+			}
+			// each leg in the ledger points back to its transaction
+			var entries []ledger.Entry
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				entries, err = ledger.GetLedger(tx, start, start.AddDate(0, 0, 1))
+				return err
+			})
+			testutils.AssertEqual(t, 5, len(entries))
+			testutils.AssertEqual(t, 0, entries[0].TransactionID)
+			for _, e := range entries[1:] {
+				testutils.AssertEqual(t, ids[0], e.TransactionID)
+			}
+		})
+}
+
+func TestDeleteTransaction(t *testing.T) {
+	db := testutils.Db(t)
+	bigBang := testutils.BigBang
+	entryDate := time.Date(2004, 8, 16, 0, 0, 0, 0, time.Local)
+	t.Run("legs cannot be deleted one at a time",
+		func(t *testing.T) {
+			var id int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				id, err = ledger.InsertTransaction(tx, paycheck(entryDate))
+				return err
+			})
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			if err := ledger.DeleteEntry(tx, 1); err == nil {
+				t.Fatalf("want error deleting one leg of a transaction, got nil")
+			}
+			tx.Rollback()
+			// deleting the transaction removes every leg
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				return ledger.DeleteTransaction(tx, id)
+			})
+			var got int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = ledger.SummarizeBucket(tx, "income", bigBang, entryDate)
+				return err
+			})
+			testutils.AssertEqual(t, 0, got)
+		})
+	t.Run("transactions with a reconciled leg cannot be deleted",
+		func(t *testing.T) {
+			var id int
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				if id, err = ledger.InsertTransaction(tx, paycheck(entryDate)); err != nil {
+					return err
+				}
+				r, err := ledger.Reconcile(tx, "checking", entryDate, 3300)
+				if err != nil {
+					return err
+				}
+				if err := ledger.SetStatus(tx, ledger.Cleared, r.Uncleared[0].ID); err != nil {
+					return err
+				}
+				_, err = ledger.FinishReconciliation(tx, "checking", entryDate, 3300)
+				return err
+			})
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf("starting tx: %v", err)
+			}
+			defer tx.Rollback()
+			if err := ledger.DeleteTransaction(tx, id); err == nil {
+				t.Fatalf("want error deleting a transaction with a reconciled leg, got nil")
+			}
+		})
+}
+
+func TestInsertEntries(t *testing.T) {
+	db := testutils.Db(t)
+	// the legs of split 7 are inserted as one transaction, whatever its id
+	split := paycheck(testutils.JanTwo)
+	split.ID = 7
+	entries := append([]ledger.Entry{{Source: "checking", Destination: "food", EntryDate: testutils.JanOne, Amount: 100}}, split.Entries()...)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		return ledger.InsertEntries(tx, entries)
+	})
+	var got []ledger.Transaction
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		got, err = ledger.GetTransactions(tx, testutils.JanOne, testutils.JanTwo.AddDate(0, 0, 1))
+		return err
+	})
+	testutils.AssertEqual(t, 1, len(got))
+	testutils.AssertEqual(t, split.Postings, got[0].Postings)
+	var food int
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		food, err = ledger.SummarizeBucket(tx, "food", testutils.BigBang, testutils.JanTwo)
+		return err
+	})
+	testutils.AssertEqual(t, 100, food)
+}
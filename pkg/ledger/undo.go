@@ -0,0 +1,104 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/audit"
+	"strconv"
+)
+
+// undo the latest n change sets, see audit.Undo, holding the entries they
+// touch to the same rules as editing them by hand: entries that are
+// reconciled, half of a void or dated in a closed period stay as they are,
+// and every split transaction left behind must still balance. Closes are
+// undone by reopening the period instead.
+func Undo(tx *sql.Tx, n int) ([]audit.Change, error) {
+	changes, err := audit.Undoable(tx, n)
+	if err != nil {
+		return nil, fmt.Errorf("Undo() - %w", err)
+	}
+	// legs of a split transaction are undone with the rest of it, so they
+	// pass as part of their own transaction
+	legs := map[int]int{}
+	transactions := map[int]bool{}
+	for _, c := range changes {
+		switch c.Table {
+		case "entries":
+			for _, r := range []audit.Row{c.Before, c.After} {
+				if tid := rowInt(r, "transaction_id"); tid != 0 {
+					legs[c.RowID] = tid
+					transactions[tid] = true
+				}
+			}
+		case "transactions":
+			transactions[c.RowID] = true
+		}
+	}
+	check := func() error {
+		for _, c := range changes {
+			if c.Table != "entries" || !entryExists(tx, c.RowID) {
+				continue
+			}
+			if _, err := checkEditable(tx, c.RowID, legs[c.RowID]); err != nil {
+				return fmt.Errorf("change %d: %w", c.ID, err)
+			}
+		}
+		return nil
+	}
+	// entries must be editable both as they are and as they are put back
+	if err := check(); err != nil {
+		return nil, fmt.Errorf("Undo() - %w", err)
+	}
+	undone, err := audit.Undo(tx, n)
+	if err != nil {
+		return nil, fmt.Errorf("Undo() - %w", err)
+	}
+	if err := check(); err != nil {
+		return nil, fmt.Errorf("Undo() - %w", err)
+	}
+	for id := range transactions {
+		if err := checkTransaction(tx, id); err != nil {
+			return nil, fmt.Errorf("Undo() - %w", err)
+		}
+	}
+	return undone, nil
+}
+
+// report whether an entry is stored
+func entryExists(tx *sql.Tx, id int) bool {
+	var count int
+	tx.QueryRow(`SELECT count(id) FROM entries WHERE id = $1;`, id).Scan(&count)
+	return count > 0
+}
+
+// return an error unless a split transaction is either gone along with all
+// of its legs, or stored with legs that balance
+func checkTransaction(tx *sql.Tx, id int) error {
+	var stored, legs int
+	q := `SELECT (SELECT count(id) FROM transactions WHERE id = $1),
+		(SELECT count(id) FROM entries WHERE transaction_id = $1);`
+	if err := tx.QueryRow(q, id).Scan(&stored, &legs); err != nil {
+		return fmt.Errorf("querying transaction %d: %w", id, err)
+	}
+	if stored == 0 {
+		if legs > 0 {
+			return fmt.Errorf("transaction %d is gone but %d of its legs are not", id, legs)
+		}
+		return nil
+	}
+	t, err := GetTransaction(tx, id)
+	if err != nil {
+		return err
+	}
+	if err := t.validate(); err != nil {
+		return fmt.Errorf("transaction %d: %w", id, err)
+	}
+	return nil
+}
+
+// get an integer column of an audited row, or 0 if it is NULL or the row is
+// missing
+func rowInt(r audit.Row, column string) int {
+	n, _ := strconv.Atoi(fmt.Sprint(r[column]))
+	return n
+}
@@ -0,0 +1,98 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+)
+
+func TestUndo(t *testing.T) {
+	db := testutils.Db(t)
+	// run work, expecting it to fail, and roll it back
+	refused := func(t *testing.T, work func(tx *sql.Tx) error) {
+		t.Helper()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("starting tx: %v", err)
+		}
+		defer tx.Rollback()
+		if err := work(tx); err == nil {
+			t.Fatalf("want error, got nil")
+		}
+	}
+	undo := func(n int) func(tx *sql.Tx) error {
+		return func(tx *sql.Tx) error {
+			_, err := ledger.Undo(tx, n)
+			return err
+		}
+	}
+	t.Run("split transactions are undone whole", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			_, err := ledger.InsertTransaction(tx, paycheck(testutils.Date(2, 1)))
+			return err
+		})
+		testutils.Tx(t, db, undo(1))
+		var got []ledger.Entry
+		testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+			got, err = ledger.GetEntries(tx)
+			return err
+		})
+		testutils.AssertEqual(t, 0, len(got))
+	})
+	t.Run("reconciled entries stay reconciled", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			return ledger.InsertEntry(tx, ledger.Entry{Source: "income", Destination: "checking", EntryDate: testutils.Date(3, 1), Amount: 100})
+		})
+		var id int
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			r, err := ledger.Reconcile(tx, "checking", testutils.Date(3, 31), 100)
+			if err != nil {
+				return err
+			}
+			id = r.Uncleared[0].ID
+			if err := ledger.SetStatus(tx, ledger.Cleared, id); err != nil {
+				return err
+			}
+			_, err = ledger.FinishReconciliation(tx, "checking", testutils.Date(3, 31), 100)
+			return err
+		})
+		refused(t, undo(1))
+		got, err := func() (ledger.Entry, error) {
+			tx, err := db.Begin()
+			if err != nil {
+				return ledger.Entry{}, err
+			}
+			defer tx.Rollback()
+			return ledger.GetEntry(tx, id)
+		}()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.AssertEqual(t, ledger.Reconciled, got.Status)
+	})
+	t.Run("voids stay on the record", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			if err := ledger.InsertEntry(tx, ledger.Entry{Source: "checking", Destination: "fun", EntryDate: testutils.Date(4, 1), Amount: 20}); err != nil {
+				return err
+			}
+			entries, err := ledger.GetEntries(tx)
+			if err != nil {
+				return err
+			}
+			return ledger.VoidEntry(tx, entries[len(entries)-1].ID, testutils.Date(4, 2))
+		})
+		refused(t, undo(1))
+	})
+	t.Run("closes are undone by reopening instead", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			_, err := ledger.ClosePeriod(tx, testutils.Date(4, 30), "equity")
+			return err
+		})
+		refused(t, undo(1))
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			_, err := ledger.ReopenPeriod(tx)
+			return err
+		})
+	})
+}
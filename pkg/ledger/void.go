@@ -0,0 +1,101 @@
+package ledger
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// void an entry by inserting a linked entry that moves the same amount back
+// from its destination to its source on voidedAt, so that the mistake stays
+// on the record. The reversal carries the original's payee and tags, so it
+// nets out of tag reports too.
+func VoidEntry(tx *sql.Tx, id int, voidedAt time.Time) error {
+	e, err := GetEntry(tx, id)
+	if err != nil {
+		return fmt.Errorf("VoidEntry() - %w", err)
+	}
+	if e.TransactionID != 0 {
+		return fmt.Errorf("VoidEntry() - entry %d belongs to transaction %d", id, e.TransactionID)
+	}
+	if e.Voids != 0 {
+		return fmt.Errorf("VoidEntry() - entry %d already voids entry %d", id, e.Voids)
+	}
+	if e.VoidedBy != 0 {
+		return fmt.Errorf("VoidEntry() - entry %d is already voided by entry %d", id, e.VoidedBy)
+	}
+	if voidedAt.IsZero() {
+		voidedAt = e.EntryDate
+	}
+	reversal := Entry{
+		Source:      e.Destination,
+		Destination: e.Source,
+		EntryDate:   voidedAt,
+		Amount:      e.Amount,
+		Commodity:   e.Commodity,
+		Payee:       e.Payee,
+		Memo:        fmt.Sprintf("void of entry %d", id),
+		Voids:       id,
+		Tags:        e.Tags,
+	}
+	if err := InsertEntry(tx, reversal); err != nil {
+		return fmt.Errorf("VoidEntry() - %w", err)
+	}
+	return nil
+}
+
+// move each entry that voids another directly after it, when both are
+// present, keeping the rest in order
+func groupVoids(entries []Entry) []Entry {
+	present := map[int]bool{}
+	for _, e := range entries {
+		if e.ID != 0 {
+			present[e.ID] = true
+		}
+	}
+	reversals := map[int]Entry{}
+	for _, e := range entries {
+		if e.Voids != 0 && present[e.Voids] {
+			reversals[e.Voids] = e
+		}
+	}
+	grouped := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if e.Voids != 0 && present[e.Voids] {
+			continue
+		}
+		grouped = append(grouped, e)
+		if r, ok := reversals[e.ID]; ok && e.ID != 0 {
+			grouped = append(grouped, r)
+		}
+	}
+	return grouped
+}
+
+// drop voided entries and the entries voiding them
+func HideVoided(entries []Entry) []Entry {
+	var shown []Entry
+	for _, e := range entries {
+		if e.Voids == 0 && e.VoidedBy == 0 {
+			shown = append(shown, e)
+		}
+	}
+	return shown
+}
+
+// parse the id of an entry to void and the date to void it on, which
+// defaults to the entry's own date
+func PrepareVoid(r *http.Request) (int, time.Time, error) {
+	id, err := PrepareEntryID(r)
+	if err != nil {
+		return -1, time.Time{}, err
+	}
+	var voidedAt time.Time
+	if d := r.Form.Get("voided_at"); d != "" {
+		if voidedAt, err = time.Parse("2006-01-02", d); err != nil {
+			return -1, time.Time{}, fmt.Errorf("Could not parse voided_at (%v)", err)
+		}
+	}
+	return id, voidedAt, nil
+}
@@ -0,0 +1,92 @@
+package ledger_test
+
+import (
+	"database/sql"
+	"ledger/pkg/ledger"
+	"ledger/pkg/testutils"
+	"testing"
+)
+
+func TestVoidEntry(t *testing.T) {
+	db := testutils.Db(t)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		for _, e := range []ledger.Entry{
+			{Source: "checking", Destination: "savings", EntryDate: testutils.JanOne, Amount: 500, Tags: []string{"oops"}},
+			{Source: "income", Destination: "checking", EntryDate: testutils.JanOne, Amount: 1000},
+		} {
+			if err := ledger.InsertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		return ledger.VoidEntry(tx, 1, testutils.JanTwo)
+	})
+	t.Run("the reversal moves the amount back and links to the original", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			reversal, err := ledger.GetEntry(tx, 3)
+			if err != nil {
+				return err
+			}
+			testutils.AssertEqual(t, "savings", reversal.Source)
+			testutils.AssertEqual(t, "checking", reversal.Destination)
+			testutils.AssertEqual(t, 500, reversal.Amount)
+			testutils.AssertEqual(t, 1, reversal.Voids)
+			testutils.AssertEqual(t, []string{"oops"}, reversal.Tags)
+			original, err := ledger.GetEntry(tx, 1)
+			if err != nil {
+				return err
+			}
+			testutils.AssertEqual(t, 3, original.VoidedBy)
+			return nil
+		})
+	})
+	t.Run("balances net the pair out", func(t *testing.T) {
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			for bucket, want := range map[string]int{"checking": 1000, "savings": 0} {
+				got, err := ledger.SummarizeBucket(tx, bucket, testutils.BigBang, testutils.JanTwo)
+				if err != nil {
+					return err
+				}
+				testutils.AssertEqual(t, want, got)
+			}
+			got, err := ledger.SummarizeBucket(tx, "savings", testutils.BigBang, testutils.JanTwo, "oops")
+			if err != nil {
+				return err
+			}
+			testutils.AssertEqual(t, 0, got)
+			return nil
+		})
+	})
+	t.Run("the ledger shows the reversal right after the original", func(t *testing.T) {
+		var ids, shown []int
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			entries, err := ledger.GetLedger(tx, testutils.BigBang, testutils.JanTwo.AddDate(0, 0, 1))
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+			for _, e := range ledger.HideVoided(entries) {
+				shown = append(shown, e.ID)
+			}
+			return nil
+		})
+		testutils.AssertEqual(t, []int{1, 3, 2}, ids)
+		testutils.AssertEqual(t, []int{2}, shown)
+	})
+	t.Run("voided pairs can be neither voided again nor edited", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("starting tx: %v", err)
+		}
+		defer tx.Rollback()
+		for _, id := range []int{1, 3} {
+			if err := ledger.VoidEntry(tx, id, testutils.JanTwo); err == nil {
+				t.Errorf("want error voiding entry %d again, got nil", id)
+			}
+			if err := ledger.DeleteEntry(tx, id); err == nil {
+				t.Errorf("want error deleting entry %d, got nil", id)
+			}
+		}
+	})
+}
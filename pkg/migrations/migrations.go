@@ -0,0 +1,464 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Migration is one numbered change to the schema. Migrations only go up:
+// to change a table again, add a new migration rather than editing one that
+// may already have run.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	// optional query listing, as one description per row, the rows Up
+	// cannot convert; any row stops the migration
+	Check string
+	// query that only returns a row once Up has been applied, used to adopt
+	// databases made before the schema was versioned
+	probe string
+}
+
+// Status is a migration and when it was applied, which is empty while it is
+// pending.
+type Status struct {
+	Migration
+	AppliedAt string
+}
+
+// Migrations is every migration, in the order they run.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create entries and budget entries",
+		Up: `CREATE TABLE entries
+		(
+			source TEXT,
+			destination TEXT,
+			happened_at TEXT,
+			amount TEXT
+		);
+		CREATE TABLE budget_entries
+		(
+			happened_at TEXT,
+			amount INT,
+			category TEXT,
+			description TEXT
+		);`,
+		probe: `SELECT count(source) FROM entries;`,
+	},
+	{
+		Version: 2,
+		Name:    "add entry ids",
+		Up: `CREATE TABLE entries_new
+		(
+			id INTEGER PRIMARY KEY,
+			source TEXT,
+			destination TEXT,
+			happened_at TEXT,
+			amount TEXT
+		);
+		INSERT INTO entries_new (id, source, destination, happened_at, amount)
+			SELECT rowid, source, destination, happened_at, amount FROM entries;
+		DROP TABLE entries;
+		ALTER TABLE entries_new RENAME TO entries;`,
+		probe: `SELECT count(id) FROM entries;`,
+	},
+	{
+		Version: 3,
+		Name:    "add split transactions",
+		Up: `CREATE TABLE transactions
+		(
+			id INTEGER PRIMARY KEY,
+			happened_at TEXT
+		);
+		ALTER TABLE entries ADD COLUMN transaction_id INTEGER REFERENCES transactions(id);`,
+		probe: `SELECT count(transaction_id) FROM entries;`,
+	},
+	{
+		Version: 4,
+		Name:    "add payee and memo to entries",
+		Up: `ALTER TABLE entries ADD COLUMN payee TEXT NOT NULL DEFAULT '';
+		ALTER TABLE entries ADD COLUMN memo TEXT NOT NULL DEFAULT '';`,
+		probe: `SELECT count(memo) FROM entries;`,
+	},
+	{
+		Version: 5,
+		Name:    "add budget entry ids and tags",
+		Up: `CREATE TABLE budget_entries_new
+		(
+			id INTEGER PRIMARY KEY,
+			happened_at TEXT,
+			amount INT,
+			category TEXT,
+			description TEXT
+		);
+		INSERT INTO budget_entries_new (id, happened_at, amount, category, description)
+			SELECT rowid, happened_at, amount, category, description FROM budget_entries;
+		DROP TABLE budget_entries;
+		ALTER TABLE budget_entries_new RENAME TO budget_entries;
+		CREATE TABLE tags
+		(
+			name TEXT,
+			entry_id INTEGER REFERENCES entries(id),
+			budget_entry_id INTEGER REFERENCES budget_entries(id)
+		);`,
+		probe: `SELECT count(name) FROM tags;`,
+	},
+	{
+		Version: 6,
+		Name:    "add bucket registry",
+		Up: `CREATE TABLE buckets
+		(
+			name TEXT PRIMARY KEY,
+			type TEXT NOT NULL,
+			display_name TEXT NOT NULL DEFAULT '',
+			opened_at TEXT NOT NULL,
+			closed_at TEXT
+		);`,
+		probe: `SELECT count(name) FROM buckets;`,
+	},
+	{
+		Version: 7,
+		Name:    "add repeating entries",
+		Up: `CREATE TABLE schedules
+		(
+			id INTEGER PRIMARY KEY,
+			source TEXT,
+			destination TEXT,
+			amount INT,
+			payee TEXT NOT NULL DEFAULT '',
+			memo TEXT NOT NULL DEFAULT '',
+			started_at TEXT NOT NULL,
+			frequency TEXT NOT NULL,
+			interval INT NOT NULL DEFAULT 1,
+			ended_at TEXT,
+			count INT
+		);
+		ALTER TABLE tags ADD COLUMN schedule_id INTEGER REFERENCES schedules(id);`,
+		probe: `SELECT count(id) FROM schedules;`,
+	},
+	{
+		Version: 8,
+		Name:    "add weekend shifts to repeating entries",
+		Up:      `ALTER TABLE schedules ADD COLUMN weekend_shift TEXT NOT NULL DEFAULT '';`,
+		probe:   `SELECT count(weekend_shift) FROM schedules;`,
+	},
+	{
+		Version: 9,
+		Name:    "add entry status",
+		Up:      `ALTER TABLE entries ADD COLUMN status TEXT NOT NULL DEFAULT 'pending';`,
+		probe:   `SELECT count(status) FROM entries;`,
+	},
+	{
+		Version: 10,
+		Name:    "add balance assertions",
+		Up: `CREATE TABLE assertions
+		(
+			id INTEGER PRIMARY KEY,
+			bucket TEXT NOT NULL,
+			asserted_at TEXT NOT NULL,
+			amount INT NOT NULL
+		);`,
+		probe: `SELECT count(id) FROM assertions;`,
+	},
+	{
+		Version: 11,
+		Name:    "store entry amounts as integers and dates as YYYY-MM-DD",
+		Check: `SELECT 'entry ' || id || ' has happened_at ' || quote(happened_at) || ', which is not a date'
+			FROM entries WHERE date(substr(happened_at, 1, 10)) IS NULL
+		UNION ALL
+		SELECT 'entry ' || id || ' has amount ' || quote(amount) || ', which is not a whole number of cents'
+			FROM entries WHERE amount IS NULL OR CAST(amount AS INTEGER) || '' <> trim(amount);`,
+		Up: `CREATE TABLE entries_new
+		(
+			id INTEGER PRIMARY KEY,
+			source TEXT,
+			destination TEXT,
+			happened_at TEXT NOT NULL CHECK (happened_at IS date(happened_at)),
+			amount INTEGER NOT NULL CHECK (typeof(amount) = 'integer'),
+			payee TEXT NOT NULL DEFAULT '',
+			memo TEXT NOT NULL DEFAULT '',
+			transaction_id INTEGER REFERENCES transactions(id),
+			status TEXT NOT NULL DEFAULT 'pending'
+		);
+		INSERT INTO entries_new
+			(id, source, destination, happened_at, amount, payee, memo, transaction_id, status)
+			SELECT id, source, destination, date(substr(happened_at, 1, 10)), CAST(amount AS INTEGER),
+				payee, memo, transaction_id, status
+			FROM entries;
+		DROP TABLE entries;
+		ALTER TABLE entries_new RENAME TO entries;
+		UPDATE transactions SET happened_at = date(substr(happened_at, 1, 10));`,
+		probe: `SELECT 1 FROM sqlite_master WHERE name = 'entries' AND sql LIKE '%amount INTEGER NOT NULL%';`,
+	},
+	{
+		Version: 12,
+		Name:    "add append-only audit log",
+		Up: `CREATE TABLE audit_log
+		(
+			id INTEGER PRIMARY KEY,
+			changed_at TEXT NOT NULL,
+			actor TEXT NOT NULL,
+			source TEXT NOT NULL,
+			table_name TEXT NOT NULL,
+			row_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			before TEXT,
+			after TEXT,
+			undoes INTEGER REFERENCES audit_log(id)
+		);
+		CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END;
+		CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END;`,
+		probe: `SELECT count(id) FROM audit_log;`,
+	},
+	{
+		Version: 13,
+		Name:    "add voiding entries",
+		Up: `ALTER TABLE entries ADD COLUMN voids INTEGER REFERENCES entries(id);
+		CREATE UNIQUE INDEX entries_voids ON entries(voids);`,
+		probe: `SELECT count(voids) FROM entries;`,
+	},
+	{
+		Version: 14,
+		Name:    "add period closes",
+		Up: `CREATE TABLE closings
+		(
+			id INTEGER PRIMARY KEY,
+			closed_through TEXT NOT NULL CHECK (closed_through IS date(closed_through)),
+			equity TEXT NOT NULL,
+			closed_at TEXT NOT NULL
+		);
+		CREATE TABLE opening_balances
+		(
+			closing_id INTEGER NOT NULL REFERENCES closings(id),
+			bucket TEXT NOT NULL,
+			amount INTEGER NOT NULL,
+			PRIMARY KEY (closing_id, bucket)
+		);
+		ALTER TABLE entries ADD COLUMN closing_id INTEGER REFERENCES closings(id);
+		CREATE TRIGGER entries_closed_insert BEFORE INSERT ON entries
+		WHEN NEW.closing_id IS NULL AND NEW.happened_at <= (SELECT max(closed_through) FROM closings)
+		BEGIN
+			SELECT RAISE(ABORT, 'entries dated in a closed period are read-only');
+		END;
+		CREATE TRIGGER entries_closed_update BEFORE UPDATE ON entries
+		WHEN OLD.happened_at <= (SELECT max(closed_through) FROM closings)
+			OR NEW.happened_at <= (SELECT max(closed_through) FROM closings)
+		BEGIN
+			SELECT RAISE(ABORT, 'entries dated in a closed period are read-only');
+		END;
+		CREATE TRIGGER entries_closed_delete BEFORE DELETE ON entries
+		WHEN OLD.happened_at <= (SELECT max(closed_through) FROM closings)
+		BEGIN
+			SELECT RAISE(ABORT, 'entries dated in a closed period are read-only');
+		END;`,
+		probe: `SELECT count(id) FROM closings;`,
+	},
+	{
+		Version: 15,
+		Name:    "add commodities and prices",
+		Up: `ALTER TABLE entries ADD COLUMN commodity TEXT NOT NULL DEFAULT 'USD';
+		ALTER TABLE schedules ADD COLUMN commodity TEXT NOT NULL DEFAULT 'USD';
+		ALTER TABLE assertions ADD COLUMN commodity TEXT NOT NULL DEFAULT 'USD';
+		CREATE TABLE opening_balances_new
+		(
+			closing_id INTEGER NOT NULL REFERENCES closings(id),
+			bucket TEXT NOT NULL,
+			commodity TEXT NOT NULL DEFAULT 'USD',
+			amount INTEGER NOT NULL,
+			PRIMARY KEY (closing_id, bucket, commodity)
+		);
+		INSERT INTO opening_balances_new (closing_id, bucket, amount)
+			SELECT closing_id, bucket, amount FROM opening_balances;
+		DROP TABLE opening_balances;
+		ALTER TABLE opening_balances_new RENAME TO opening_balances;
+		CREATE TABLE prices
+		(
+			id INTEGER PRIMARY KEY,
+			commodity TEXT NOT NULL,
+			quote TEXT NOT NULL,
+			priced_at TEXT NOT NULL CHECK (priced_at IS date(priced_at)),
+			rate REAL NOT NULL CHECK (rate > 0),
+			UNIQUE (commodity, quote, priced_at)
+		);`,
+		probe: `SELECT count(id) FROM prices;`,
+	},
+	{
+		Version: 16,
+		Name:    "add bank transaction ids",
+		Up: `ALTER TABLE entries ADD COLUMN fitid TEXT;
+		ALTER TABLE budget_entries ADD COLUMN fitid TEXT;
+		CREATE INDEX entries_fitid ON entries(fitid);
+		CREATE INDEX budget_entries_fitid ON budget_entries(fitid);`,
+		probe: `SELECT count(fitid) FROM entries;`,
+	},
+	{
+		Version: 17,
+		Name:    "group audit log changes into change sets",
+		Up: `ALTER TABLE audit_log ADD COLUMN change_set INTEGER REFERENCES audit_log(id);
+		CREATE INDEX audit_log_change_set ON audit_log(change_set);`,
+		probe: `SELECT count(change_set) FROM audit_log;`,
+	},
+}
+
+// Latest is the version of the schema once every migration has run.
+func Latest() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// get every migration and when it was applied
+func GetStatus(tx *sql.Tx) ([]Status, error) {
+	if err := versionTable(tx); err != nil {
+		return nil, fmt.Errorf("GetStatus() - %w", err)
+	}
+	applied := map[int]string{}
+	rows, err := tx.Query(`SELECT version, applied_at FROM schema_version;`)
+	if err != nil {
+		return nil, fmt.Errorf("GetStatus() - querying versions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at string
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("GetStatus() - scanning version: %w", err)
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetStatus() - reading versions: %w", err)
+	}
+	output := []Status{}
+	for _, m := range Migrations {
+		output = append(output, Status{Migration: m, AppliedAt: applied[m.Version]})
+	}
+	return output, nil
+}
+
+// apply every pending migration in order and get the ones applied. Nothing
+// is applied unless tx commits, so rolling back makes a dry run.
+func Migrate(tx *sql.Tx) ([]Migration, error) {
+	status, err := GetStatus(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Migrate() - %w", err)
+	}
+	applied := []Migration{}
+	for _, s := range status {
+		if s.AppliedAt != "" {
+			continue
+		}
+		if err := check(tx, s.Migration); err != nil {
+			return nil, fmt.Errorf("Migrate() - %w", err)
+		}
+		if _, err := tx.Exec(s.Up); err != nil {
+			return nil, fmt.Errorf("Migrate() - applying %d %s: %w", s.Version, s.Name, err)
+		}
+		if err := record(tx, s.Version, s.Name); err != nil {
+			return nil, fmt.Errorf("Migrate() - %w", err)
+		}
+		applied = append(applied, s.Migration)
+	}
+	return applied, nil
+}
+
+// bring the database up to date in a transaction of its own
+func Run(db *sql.DB) ([]Migration, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Run() - beginning transaction: %w", err)
+	}
+	applied, err := Migrate(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Run() - %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Run() - committing: %w", err)
+	}
+	return applied, nil
+}
+
+// create the schema_version table if it is missing. A database that already
+// has tables was made by the old initdb script, before versioning, so the
+// migrations it already has are recorded as applied.
+func versionTable(tx *sql.Tx) error {
+	var n int
+	q := `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version';`
+	if err := tx.QueryRow(q).Scan(&n); err != nil {
+		return fmt.Errorf("versionTable() - looking for schema_version: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+	q = `CREATE TABLE schema_version
+		(
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		);`
+	if _, err := tx.Exec(q); err != nil {
+		return fmt.Errorf("versionTable() - creating schema_version: %w", err)
+	}
+	for _, m := range Migrations {
+		if !probe(tx, m.probe) {
+			break
+		}
+		if err := record(tx, m.Version, m.Name); err != nil {
+			return fmt.Errorf("versionTable() - %w", err)
+		}
+	}
+	return nil
+}
+
+// run a migration's check, failing with every row it finds
+func check(tx *sql.Tx, m Migration) error {
+	if m.Check == "" {
+		return nil
+	}
+	rows, err := tx.Query(m.Check)
+	if err != nil {
+		return fmt.Errorf("check() - checking %d %s: %w", m.Version, m.Name, err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return fmt.Errorf("check() - scanning problem: %w", err)
+		}
+		problems = append(problems, p)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("check() - reading problems: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("cannot apply %d %s: %s", m.Version, m.Name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// check whether a probe query returns a row
+func probe(tx *sql.Tx, q string) bool {
+	var v interface{}
+	return tx.QueryRow(q).Scan(&v) == nil
+}
+
+func record(tx *sql.Tx, version int, name string) error {
+	q := `INSERT INTO schema_version (version, name, applied_at) VALUES ($1, $2, $3);`
+	if _, err := tx.Exec(q, version, name, time.Now().UTC().Format("2006-01-02 15:04:05")); err != nil {
+		return fmt.Errorf("record() - recording version %d: %w", version, err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package migrations_test
+
+import (
+	"database/sql"
+	"ledger/pkg/migrations"
+	"ledger/pkg/testutils"
+	"testing"
+)
+
+func open(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	// keep one connection so the in-memory database lives across transactions
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func versions(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+	var output []int
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		status, err := migrations.GetStatus(tx)
+		for _, s := range status {
+			if s.AppliedAt != "" {
+				output = append(output, s.Version)
+			}
+		}
+		return err
+	})
+	return output
+}
+
+func all() []int {
+	var output []int
+	for _, m := range migrations.Migrations {
+		output = append(output, m.Version)
+	}
+	return output
+}
+
+func TestRun(t *testing.T) {
+	t.Run("empty database gets every migration, once", func(t *testing.T) {
+		db := open(t)
+		applied, err := migrations.Run(db)
+		if err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+		testutils.AssertEqual(t, len(migrations.Migrations), len(applied))
+		testutils.AssertEqual(t, all(), versions(t, db))
+		applied, err = migrations.Run(db)
+		if err != nil {
+			t.Fatalf("migrating again: %v", err)
+		}
+		testutils.AssertEqual(t, 0, len(applied))
+	})
+	t.Run("database from the original schema keeps its rows", func(t *testing.T) {
+		db := open(t)
+		q := `CREATE TABLE IF NOT EXISTS entries (source TEXT, destination TEXT, happened_at TEXT, amount TEXT);
+			CREATE TABLE IF NOT EXISTS budget_entries (happened_at TEXT, amount INT, category TEXT, description TEXT);
+			INSERT INTO entries VALUES ('savings', 'checking', '2021-01-01 00:00:00+00:00', '100');
+			INSERT INTO entries VALUES ('checking', 'rent', '2021-01-02 23:30:00-07:00', 60);
+			INSERT INTO budget_entries VALUES ('2021-01-01', 3000, 'rent', '-');`
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("loading old schema: %v", err)
+		}
+		applied, err := migrations.Run(db)
+		if err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+		// the tables already exist, so only later migrations run
+		testutils.AssertEqual(t, 2, applied[0].Version)
+		testutils.AssertEqual(t, all(), versions(t, db))
+		// dates keep the day they were written on, and amounts become integers
+		var got []string
+		testutils.Tx(t, db, func(tx *sql.Tx) error {
+			rows, err := tx.Query(`SELECT id || ' ' || happened_at || ' ' || typeof(amount) || ' ' || amount || ' ' || status
+				FROM entries ORDER BY id;`)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var row string
+				if err := rows.Scan(&row); err != nil {
+					return err
+				}
+				got = append(got, row)
+			}
+			return rows.Err()
+		})
+		want := []string{"1 2021-01-01 integer 100 pending", "2 2021-01-02 integer 60 pending"}
+		testutils.AssertEqual(t, want, got)
+	})
+	t.Run("unversioned database part way through history", func(t *testing.T) {
+		db := open(t)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("beginning: %v", err)
+		}
+		// build the schema as it was before assertions, then forget its
+		// versions
+		for _, m := range migrations.Migrations[:9] {
+			if _, err := tx.Exec(m.Up); err != nil {
+				t.Fatalf("applying %d: %v", m.Version, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("committing: %v", err)
+		}
+		applied, err := migrations.Run(db)
+		if err != nil {
+			t.Fatalf("migrating: %v", err)
+		}
+		var got []int
+		for _, m := range applied {
+			got = append(got, m.Version)
+		}
+		testutils.AssertEqual(t, all()[9:], got)
+	})
+}
+
+func TestMigrateRejectsUnconvertibleRows(t *testing.T) {
+	db := open(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("beginning: %v", err)
+	}
+	for _, m := range migrations.Migrations[:10] {
+		if _, err := tx.Exec(m.Up); err != nil {
+			t.Fatalf("applying %d: %v", m.Version, err)
+		}
+	}
+	q := `INSERT INTO entries (source, destination, happened_at, amount) VALUES
+		('a', 'b', 'last tuesday', '5'),
+		('a', 'b', '2021-01-01', '1.50');`
+	if _, err := tx.Exec(q); err != nil {
+		t.Fatalf("inserting: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("committing: %v", err)
+	}
+	_, err = migrations.Run(db)
+	want := "Run() - Migrate() - cannot apply 11 store entry amounts as integers and dates as YYYY-MM-DD: " +
+		"entry 1 has happened_at 'last tuesday', which is not a date; " +
+		"entry 2 has amount '1.50', which is not a whole number of cents"
+	if err == nil || err.Error() != want {
+		t.Fatalf("want error %q, got %v", want, err)
+	}
+	// the failed migration left the data alone
+	testutils.AssertEqual(t, all()[:10], versions(t, db))
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	db := open(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("beginning: %v", err)
+	}
+	applied, err := migrations.Migrate(tx)
+	if err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	testutils.AssertEqual(t, len(migrations.Migrations), len(applied))
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rolling back: %v", err)
+	}
+	var tables int
+	if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table';`).Scan(&tables); err != nil {
+		t.Fatalf("counting tables: %v", err)
+	}
+	testutils.AssertEqual(t, 0, tables)
+}
+
+func TestEntriesRejectBadRows(t *testing.T) {
+	db := open(t)
+	if _, err := migrations.Run(db); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	q := `INSERT INTO entries (source, destination, happened_at, amount) VALUES ('a', 'b', $1, $2);`
+	if _, err := db.Exec(q, "2021-01-01", 100); err != nil {
+		t.Fatalf("inserting a good row: %v", err)
+	}
+	for _, row := range [][2]interface{}{
+		{"2021-01-01 00:00:00+00:00", 100},
+		{"01/01/2021", 100},
+		{"2021-01-01", "1.50"},
+		{"2021-01-01", nil},
+	} {
+		if _, err := db.Exec(q, row[0], row[1]); err == nil {
+			t.Errorf("inserting %v: want error", row)
+		}
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"database/sql"
 	"fmt"
 	"ledger/pkg/budget"
+	"ledger/pkg/period"
 	"ledger/pkg/utils"
 	"net/url"
-	"strconv"
 	"time"
 )
 
@@ -46,20 +46,36 @@ func SetEndDate(tx *sql.Tx, values url.Values) (time.Time, error) {
 	}
 }
 
-func SetTimeInterval(values url.Values) (int, error) {
+// get the interval to group by, either a number of days or a calendar
+// interval such as month or quarter; one day by default
+func SetTimeInterval(values url.Values) (period.Interval, error) {
 	formInterval := values.Get("interval")
-	if formInterval == "" && formInterval != "undefined" {
-		return 1, nil
+	if formInterval == "" || formInterval == "undefined" {
+		return period.Days(1), nil
 	}
-	interval, err := strconv.Atoi(formInterval)
+	interval, err := period.Parse(formInterval)
 	if err != nil {
-		return -1, fmt.Errorf("Could not convert form interval %s to number: %v", formInterval, err)
+		return period.Interval{}, fmt.Errorf("Could not parse form interval %s: %v", formInterval, err)
 	}
 	return interval, nil
 }
 
+// get the tags to filter by, given either as repeated tags values or as a
+// comma-separated list
+func SetTags(values url.Values) []string {
+	var tags []string
+	for _, v := range values["tags"] {
+		if v != "undefined" {
+			tags = append(tags, utils.ParseTags(v)...)
+		}
+	}
+	return tags
+}
+
+// get the categories to summarize and all categories to choose from; when
+// tags are given, only categories with tagged entries are offered
 func SetBudgetCategories(tx *sql.Tx, values url.Values) ([]string, []string, error) {
-	allCategories, err := budget.GetCategories(tx)
+	allCategories, err := budget.GetCategories(tx, SetTags(values)...)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Calling budget.GetCategories (%v)", err)
 	}
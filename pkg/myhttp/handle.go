@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"ledger/pkg/budget"
 	"ledger/pkg/mytemplate"
+	"ledger/pkg/period"
 	"net/http"
 	"time"
 )
@@ -31,8 +32,10 @@ func HandleBudgetOverTime(tx *sql.Tx, r *http.Request, w http.ResponseWriter) er
 	if err != nil {
 		return fmt.Errorf("Calling SetBudgetCategories: %v", err)
 	}
+	// set tags
+	tags := SetTags(r.Form)
 	// get summary of spending over time
-	spendSummary, err := budget.SummarizeSpendsOverTime(tx, filterCategories, startDate, endDate, timeInterval)
+	spendSummary, err := budget.SummarizeSpendsOverTime(tx, filterCategories, startDate, endDate, timeInterval, tags...)
 	if err != nil {
 		return fmt.Errorf("Calling ledger.SummarizeBalanceOverTime (%v)", err)
 	}
@@ -41,14 +44,16 @@ func HandleBudgetOverTime(tx *sql.Tx, r *http.Request, w http.ResponseWriter) er
 	// construct data for html template
 	htmlTemplateData := struct {
 		Start, End    time.Time
-		TimeInterval  int
+		TimeInterval  period.Interval
 		AllCategories []string
+		Tags          []string
 		Plot          budget.PlotData
 	}{
 		startDate,
 		endDate,
 		timeInterval,
 		allCategories,
+		tags,
 		*plot,
 	}
 	// call template function
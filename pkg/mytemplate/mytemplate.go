@@ -4,16 +4,34 @@ import (
 	"database/sql"
 	"fmt"
 	"html/template"
+	"ledger/pkg/audit"
 	"ledger/pkg/ledger"
+	"ledger/pkg/period"
+	"ledger/pkg/qifreader"
+	"ledger/pkg/usd"
+	"ledger/pkg/utils"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// helpers available to every template
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	"inc":  func(n int) int { return n + 1 },
+	"usd": func(cents int) string {
+		amount := usd.USD(cents)
+		return amount.String()
+	},
+	"money": usd.Format,
+}
+
 // display a ledger on a single day
 func Ledger(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
 	// parse html template
-	t, err := template.ParseFiles("pkg/mytemplate/ledger.html")
+	t, err := template.New("ledger.html").Funcs(funcs).ParseFiles("pkg/mytemplate/ledger.html")
 	if err != nil {
 		return fmt.Errorf("Could not parse ledger.html (%v)", err)
 	}
@@ -21,6 +39,8 @@ func Ledger(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
 	r.ParseForm()
 	formStart := r.PostForm["start"]
 	formEnd := r.PostForm["end"]
+	tags := utils.ParseTags(r.PostForm.Get("tags"))
+	hideVoided := r.PostForm.Get("hide_voided") != ""
 	// set start date
 	start := time.Now().AddDate(0, -1, 0)
 	if len(formStart) > 0 && formStart[0] != "" {
@@ -38,17 +58,131 @@ func Ledger(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 	// get ledger data
-	myledger, err := ledger.GetLedger(tx, start, end)
+	myledger, err := ledger.GetLedger(tx, start, end, tags...)
 	if err != nil {
 		return fmt.Errorf("Calling ledger.GetLedger() (%v)", err)
 	}
+	if hideVoided {
+		myledger = ledger.HideVoided(myledger)
+	}
+	transactions, err := ledger.GetTransactions(tx, start, end, tags...)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetTransactions() (%v)", err)
+	}
+	schedules, err := ledger.GetSchedules(tx, tags...)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetSchedules() (%v)", err)
+	}
 	data := struct {
-		Start, End time.Time
-		Ledger     []ledger.Entry
+		Start, End   time.Time
+		Tags         []string
+		HideVoided   bool
+		Ledger       []ledger.Entry
+		Transactions []ledger.Transaction
+		Schedules    []ledger.Schedule
 	}{
 		start,
 		end,
+		tags,
+		hideVoided,
 		myledger,
+		transactions,
+		schedules,
+	}
+	if err = t.Execute(w, data); err != nil {
+		return fmt.Errorf("Could not Execute template (%v)", err)
+	}
+	return nil
+}
+
+// display a form to edit a single ledger entry
+func EditLedgerEntry(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
+	// parse html template
+	t, err := template.New("edit_ledger_entry.html").Funcs(funcs).ParseFiles("pkg/mytemplate/edit_ledger_entry.html")
+	if err != nil {
+		return fmt.Errorf("Could not parse edit_ledger_entry.html (%v)", err)
+	}
+	// get the entry to edit
+	id, err := ledger.PrepareEntryID(r)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.PrepareEntryID() (%v)", err)
+	}
+	entry, err := ledger.GetEntry(tx, id)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetEntry() (%v)", err)
+	}
+	if err = t.Execute(w, entry); err != nil {
+		return fmt.Errorf("Could not Execute template (%v)", err)
+	}
+	return nil
+}
+
+// display a form to edit a repeating entry
+func EditSchedule(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
+	// parse html template
+	t, err := template.New("edit_schedule.html").Funcs(funcs).ParseFiles("pkg/mytemplate/edit_schedule.html")
+	if err != nil {
+		return fmt.Errorf("Could not parse edit_schedule.html (%v)", err)
+	}
+	// get the repeating entry to edit
+	id, err := ledger.PrepareEntryID(r)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.PrepareEntryID() (%v)", err)
+	}
+	schedule, err := ledger.GetSchedule(tx, id)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetSchedule() (%v)", err)
+	}
+	data := struct {
+		ledger.Schedule
+		Frequencies   []string
+		WeekendShifts []string
+	}{
+		schedule,
+		ledger.Frequencies,
+		ledger.WeekendShifts,
+	}
+	if err = t.Execute(w, data); err != nil {
+		return fmt.Errorf("Could not Execute template (%v)", err)
+	}
+	return nil
+}
+
+// display the entries of a bucket not yet reconciled against a statement,
+// once a bucket, statement date and statement balance are given
+func Reconcile(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
+	// parse html template
+	t, err := template.ParseFiles("pkg/mytemplate/reconcile.html")
+	if err != nil {
+		return fmt.Errorf("Could not parse reconcile.html (%v)", err)
+	}
+	allBuckets, err := ledger.GetBuckets(tx)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetBuckets() (%v)", err)
+	}
+	r.ParseForm()
+	data := struct {
+		AllBuckets       []string
+		Bucket           string
+		StatementDate    string
+		StatementBalance string
+		Reconciliation   *ledger.Reconciliation
+	}{
+		AllBuckets:       allBuckets,
+		Bucket:           r.Form.Get("bucket"),
+		StatementDate:    r.Form.Get("statement_date"),
+		StatementBalance: r.Form.Get("statement_balance"),
+	}
+	if data.Bucket != "" {
+		bucket, date, balance, err := ledger.PrepareReconciliation(r)
+		if err != nil {
+			return fmt.Errorf("Calling ledger.PrepareReconciliation() (%v)", err)
+		}
+		rec, err := ledger.Reconcile(tx, bucket, date, balance)
+		if err != nil {
+			return fmt.Errorf("Calling ledger.Reconcile() (%v)", err)
+		}
+		data.Reconciliation = &rec
 	}
 	if err = t.Execute(w, data); err != nil {
 		return fmt.Errorf("Could not Execute template (%v)", err)
@@ -68,6 +202,8 @@ func BalanceOverTime(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
 	formStart := r.PostForm["start"]
 	formEnd := r.PostForm["end"]
 	formBuckets := r.PostForm["buckets"]
+	formDepth := r.PostForm["depth"]
+	base := r.PostForm.Get("commodity")
 	// set start date
 	start := time.Now().AddDate(0, -1, 0)
 	if len(formStart) > 0 && formStart[0] != "" {
@@ -84,26 +220,58 @@ func BalanceOverTime(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
 			return fmt.Errorf("Parsing end time (%v)", err)
 		}
 	}
-	// get all buckets
-	allBuckets, err := ledger.GetBuckets(tx)
+	// set depth
+	depth := 0
+	if len(formDepth) > 0 && formDepth[0] != "" {
+		depth, err = strconv.Atoi(formDepth[0])
+		if err != nil {
+			return fmt.Errorf("calling strconv.Atoi() (%v)", err)
+		}
+	}
+	// get all buckets, including parent levels
+	leafBuckets, err := ledger.GetBuckets(tx)
 	if err != nil {
 		return fmt.Errorf("Calling ledger.GetBuckets() (%v)", err)
 	}
-	// if we don't get buckets from user input, show all buckets
+	allBuckets := ledger.ExpandBuckets(leafBuckets)
+	// if we don't get buckets from user input, show all buckets at the given depth
 	if len(formBuckets) == 0 {
-		formBuckets = allBuckets
+		formBuckets = ledger.BucketsAtDepth(leafBuckets, depth)
+	}
+	// get registered bucket types to show normal balances
+	registry, err := ledger.GetRegistry(tx)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetRegistry() (%v)", err)
 	}
 	// get summary data and format for html
-	summary, err := ledger.SummarizeBalanceOverTime(tx, formBuckets, start, end)
+	summary, err := ledger.SummarizeBalanceOverTimeIn(tx, base, formBuckets, start, end)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.SummarizeBalanceOverTimeIn (%v)", err)
+	}
+	plot := ledger.MakePlot(registry.NormalizeSeries(summary), start, period.Days(1))
+	// get the bucket tree as of the end date
+	balances, err := ledger.SummarizeBalanceIn(tx, base, allBuckets, utils.BigBang, end)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.SummarizeBalanceIn (%v)", err)
+	}
+	tree := ledger.MakeBucketTree(registry.Normalize(balances))
+	registry.AnnotateIn(tree, base)
+	// warn about asset buckets projected to run dry by the end date
+	forecasts, err := ledger.ForecastAssets(tx, 0, time.Now(), end)
 	if err != nil {
-		return fmt.Errorf("Calling ledger.SummarizeBalanceOverTime (%v)", err)
+		return fmt.Errorf("Calling ledger.ForecastAssets (%v)", err)
 	}
-	plot := ledger.MakePlot(summary, start, 1)
 	data := struct {
 		AllBuckets []string
+		End        time.Time
+		Forecasts  []ledger.Forecast
+		Tree       []*ledger.BucketNode
 		Plot       ledger.PlotData
 	}{
 		allBuckets,
+		end,
+		forecasts,
+		tree,
 		*plot,
 	}
 	// execute template
@@ -126,6 +294,7 @@ func LedgerOverTime(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
 	formEnd := r.PostForm["end"]
 	formBuckets := r.PostForm["buckets"]
 	formInterval := r.PostForm["interval"]
+	base := r.PostForm.Get("commodity")
 	// set start date
 	start := time.Now().AddDate(0, -1, 0)
 	if len(formStart) > 0 && formStart[0] != "" {
@@ -143,11 +312,11 @@ func LedgerOverTime(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 	// set interval
-	interval := 1
+	interval := period.Days(1)
 	if len(formInterval) > 0 && formInterval[0] != "" {
-		interval, err = strconv.Atoi(formInterval[0])
+		interval, err = period.Parse(formInterval[0])
 		if err != nil {
-			return fmt.Errorf("calling strconv.Atoi() (%v)", err)
+			return fmt.Errorf("Calling period.Parse() (%v)", err)
 		}
 	}
 	// get all buckets
@@ -159,12 +328,17 @@ func LedgerOverTime(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
 	if len(formBuckets) == 0 {
 		formBuckets = allBuckets
 	}
+	// get registered bucket types to show normal balances
+	registry, err := ledger.GetRegistry(tx)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetRegistry() (%v)", err)
+	}
 	// get summary data and format for html
-	summary, err := ledger.SummarizeLedgerOverTime(tx, formBuckets, start, end, interval)
+	summary, err := ledger.SummarizeLedgerOverTimeIn(tx, base, formBuckets, start, end, interval)
 	if err != nil {
-		return fmt.Errorf("Calling ledger.SummarizeBalanceOverTime (%v)", err)
+		return fmt.Errorf("Calling ledger.SummarizeLedgerOverTimeIn (%v)", err)
 	}
-	plot := ledger.MakePlot(summary, start, interval)
+	plot := ledger.MakePlot(registry.NormalizeSeries(summary), start, interval)
 	data := struct {
 		AllBuckets []string
 		Plot       ledger.PlotData
@@ -179,6 +353,210 @@ func LedgerOverTime(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// display the bucket registry and balance assertions, with forms to register
+// and close buckets and to add and remove assertions
+func Buckets(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
+	// parse html template
+	t, err := template.ParseFiles("pkg/mytemplate/buckets.html")
+	if err != nil {
+		return fmt.Errorf("Could not parse buckets.html (%v)", err)
+	}
+	registry, err := ledger.GetRegistry(tx)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetRegistry() (%v)", err)
+	}
+	buckets := []ledger.Bucket{}
+	for _, b := range keys(registry) {
+		buckets = append(buckets, registry[b])
+	}
+	assertions, err := ledger.GetAssertions(tx)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetAssertions() (%v)", err)
+	}
+	failures, err := ledger.CheckAssertions(tx)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.CheckAssertions() (%v)", err)
+	}
+	closings, err := ledger.GetClosings(tx)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetClosings() (%v)", err)
+	}
+	data := struct {
+		Buckets    []ledger.Bucket
+		Types      []ledger.BucketType
+		Assertions []ledger.Assertion
+		Failures   []ledger.AssertionFailure
+		Closings   []ledger.Closing
+	}{
+		buckets,
+		[]ledger.BucketType{ledger.Asset, ledger.Liability, ledger.Income, ledger.Expense, ledger.Equity},
+		assertions,
+		failures,
+		closings,
+	}
+	if err = t.Execute(w, data); err != nil {
+		return fmt.Errorf("Could not Execute template (%v)", err)
+	}
+	return nil
+}
+
+// display the latest changes to ledger and budget entries, with a form to
+// undo them
+func History(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
+	// parse html template
+	t, err := template.New("history.html").Funcs(template.FuncMap{
+		"changed": audit.ChangedColumns,
+	}).ParseFiles("pkg/mytemplate/history.html")
+	if err != nil {
+		return fmt.Errorf("Could not parse history.html (%v)", err)
+	}
+	// parse html form
+	r.ParseForm()
+	limit := 50
+	if l := r.Form.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return fmt.Errorf("Could not convert limit field to int (%v)", err)
+		}
+	}
+	changes, err := audit.GetChanges(tx, limit)
+	if err != nil {
+		return fmt.Errorf("Calling audit.GetChanges() (%v)", err)
+	}
+	data := struct {
+		Limit   int
+		Changes []audit.Change
+	}{
+		limit,
+		changes,
+	}
+	if err = t.Execute(w, data); err != nil {
+		return fmt.Errorf("Could not Execute template (%v)", err)
+	}
+	return nil
+}
+
+// display a financial statement along with a form to pick another
+func Statement(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
+	// parse html template
+	t, err := template.New("statement.html").Funcs(funcs).ParseFiles("pkg/mytemplate/statement.html")
+	if err != nil {
+		return fmt.Errorf("Could not parse statement.html (%v)", err)
+	}
+	options, err := ledger.PrepareStatement(r)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.PrepareStatement() (%v)", err)
+	}
+	statement, err := ledger.GenerateStatement(tx, options)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GenerateStatement() (%v)", err)
+	}
+	data := struct {
+		Reports          []string
+		Options          ledger.StatementOptions
+		ComparePrevious  bool
+		ComparePriorYear bool
+		Query            template.URL
+		Statement        ledger.Statement
+	}{
+		Reports:   ledger.Reports,
+		Options:   options,
+		Query:     template.URL(r.Form.Encode()),
+		Statement: statement,
+	}
+	for _, c := range options.Compare {
+		data.ComparePrevious = data.ComparePrevious || c == ledger.PreviousPeriod
+		data.ComparePriorYear = data.ComparePriorYear || c == ledger.PriorYear
+	}
+	if err = t.Execute(w, data); err != nil {
+		return fmt.Errorf("Could not Execute template (%v)", err)
+	}
+	return nil
+}
+
+// display net worth over time, with a projection past today
+func NetWorth(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
+	// parse html template
+	t, err := template.New("networth.html").Funcs(funcs).ParseFiles("pkg/mytemplate/networth.html")
+	if err != nil {
+		return fmt.Errorf("Could not parse networth.html (%v)", err)
+	}
+	start, end, interval, base, err := ledger.PrepareNetWorth(r)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.PrepareNetWorth() (%v)", err)
+	}
+	worth, err := ledger.SummarizeNetWorthIn(tx, base, start, end, time.Now(), interval)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.SummarizeNetWorthIn() (%v)", err)
+	}
+	data := struct {
+		Start     time.Time
+		End       time.Time
+		Interval  string
+		Commodity string
+		Query     template.URL
+		NetWorth  ledger.NetWorth
+	}{
+		Start:     start,
+		End:       end,
+		Interval:  interval.String(),
+		Commodity: base,
+		Query:     template.URL(r.Form.Encode()),
+		NetWorth:  worth,
+	}
+	if err = t.Execute(w, data); err != nil {
+		return fmt.Errorf("Could not Execute template (%v)", err)
+	}
+	return nil
+}
+
+// display recorded prices and the unrealized gains they imply, with forms to
+// add and remove prices
+func Prices(tx *sql.Tx, w http.ResponseWriter, r *http.Request) error {
+	// parse html template
+	t, err := template.New("prices.html").Funcs(funcs).ParseFiles("pkg/mytemplate/prices.html")
+	if err != nil {
+		return fmt.Errorf("Could not parse prices.html (%v)", err)
+	}
+	base, through, err := ledger.PrepareFXGains(r)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.PrepareFXGains() (%v)", err)
+	}
+	prices, err := ledger.GetPrices(tx)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.GetPrices() (%v)", err)
+	}
+	gains, err := ledger.UnrealizedGains(tx, base, through)
+	if err != nil {
+		return fmt.Errorf("Calling ledger.UnrealizedGains() (%v)", err)
+	}
+	data := struct {
+		Commodity string
+		Through   time.Time
+		Prices    ledger.Prices
+		Gains     []ledger.FXGain
+	}{
+		base,
+		through,
+		prices,
+		gains,
+	}
+	if err = t.Execute(w, data); err != nil {
+		return fmt.Errorf("Could not Execute template (%v)", err)
+	}
+	return nil
+}
+
+// get the sorted names in a registry
+func keys(registry ledger.Registry) []string {
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func BudgetOverTime(w http.ResponseWriter, templateData interface{}) error {
 	// parse html template
 	t, err := template.ParseFiles("pkg/mytemplate/budgetseries.html")
@@ -192,11 +570,57 @@ func BudgetOverTime(w http.ResponseWriter, templateData interface{}) error {
 	return nil
 }
 
+// display the entries a QIF file comes to, with a form that posts the file
+// and its mapping back to commit them
+func QIFPreview(w http.ResponseWriter, r *http.Request, content string, batch qifreader.Batch) error {
+	// parse html template
+	t, err := template.New("qif_preview.html").Funcs(funcs).ParseFiles("pkg/mytemplate/qif_preview.html")
+	if err != nil {
+		return fmt.Errorf("Could not parse qif_preview.html (%v)", err)
+	}
+	data := struct {
+		EntryType string
+		Bucket    string
+		Mapping   string
+		Content   string
+		Batch     qifreader.Batch
+	}{
+		r.PostForm.Get("entry_type"),
+		r.PostForm.Get("bucket"),
+		r.PostForm.Get("mapping"),
+		content,
+		batch,
+	}
+	if err = t.Execute(w, data); err != nil {
+		return fmt.Errorf("Could not Execute template (%v)", err)
+	}
+	return nil
+}
+
 func Insert(w http.ResponseWriter, r *http.Request) {
+	InsertAfterUpload(w, r, "", nil)
+}
+
+// display the insert page along with a notice about an upload and any
+// balance assertions that failed after it
+func InsertAfterUpload(w http.ResponseWriter, r *http.Request, notice string, failures []ledger.AssertionFailure) {
 	t, err := template.ParseFiles("pkg/mytemplate/insert.html")
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Could not parse insert.html (%v)", err), http.StatusInternalServerError)
 		return
 	}
-	t.Execute(w, nil)
+	data := struct {
+		PostingRows   []int
+		Frequencies   []string
+		WeekendShifts []string
+		Notice        string
+		Failures      []ledger.AssertionFailure
+	}{
+		make([]int, 6),
+		ledger.Frequencies,
+		ledger.WeekendShifts,
+		notice,
+		failures,
+	}
+	t.Execute(w, data)
 }
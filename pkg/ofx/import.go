@@ -0,0 +1,110 @@
+package ofx
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/audit"
+	"ledger/pkg/budget"
+	"ledger/pkg/ledger"
+	"ledger/pkg/usd"
+	"strings"
+)
+
+// record each transaction of a statement as a ledger entry between bucket,
+// the account the statement is for, and counter: money into the account
+// flows from counter, money out of it flows to counter. Transactions already
+// imported into bucket are skipped; get how many were.
+func Import(tx *sql.Tx, s Statement, bucket, counter string) (int, error) {
+	var skipped int
+	err := audit.ChangeSet(tx, func() (err error) {
+		skipped, err = importStatement(tx, s, bucket, counter)
+		return err
+	})
+	return skipped, err
+}
+
+// see Import
+func importStatement(tx *sql.Tx, s Statement, bucket, counter string) (int, error) {
+	if bucket == "" || counter == "" {
+		return 0, fmt.Errorf("Import() - both a bucket and a counter bucket are needed")
+	}
+	skipped := 0
+	for _, t := range s.Transactions {
+		imported, err := ledger.Imported(tx, bucket, t.FITID)
+		if err != nil {
+			return 0, fmt.Errorf("Import() - %w", err)
+		}
+		if imported {
+			skipped++
+			continue
+		}
+		e := ledger.Entry{
+			Source:      counter,
+			Destination: bucket,
+			EntryDate:   t.Date,
+			Amount:      t.Amount,
+			Commodity:   s.Currency,
+			Payee:       t.Name,
+			Memo:        t.Memo,
+			FITID:       t.FITID,
+		}
+		if t.Amount < 0 {
+			e.Source, e.Destination, e.Amount = bucket, counter, -t.Amount
+		}
+		if err := ledger.InsertEntry(tx, e); err != nil {
+			return 0, fmt.Errorf("Import() - transaction %s: %w", t.FITID, err)
+		}
+	}
+	return skipped, nil
+}
+
+// record each transaction of a statement as a budget entry in category,
+// counting money out of the account as spending and money into it as a
+// refund. Transactions already imported into category are skipped; get how
+// many were.
+func ImportBudget(tx *sql.Tx, s Statement, category string) (int, error) {
+	var skipped int
+	err := audit.ChangeSet(tx, func() (err error) {
+		skipped, err = importBudget(tx, s, category)
+		return err
+	})
+	return skipped, err
+}
+
+// see ImportBudget
+func importBudget(tx *sql.Tx, s Statement, category string) (int, error) {
+	if category == "" {
+		return 0, fmt.Errorf("ImportBudget() - a category is needed")
+	}
+	if s.Currency != "" && !strings.EqualFold(s.Currency, ledger.DefaultCommodity) {
+		return 0, fmt.Errorf("ImportBudget() - the budget is kept in %s, not %s", ledger.DefaultCommodity, s.Currency)
+	}
+	skipped := 0
+	for _, t := range s.Transactions {
+		imported, err := budget.Imported(tx, category, t.FITID)
+		if err != nil {
+			return 0, fmt.Errorf("ImportBudget() - %w", err)
+		}
+		if imported {
+			skipped++
+			continue
+		}
+		description := t.Name
+		if t.Memo != "" && description != "" {
+			description += " - " + t.Memo
+		} else if t.Memo != "" {
+			description = t.Memo
+		}
+		e := budget.Entry{
+			EntryDate:   t.Date,
+			Amount:      usd.USD(-t.Amount),
+			Category:    category,
+			Description: description,
+			FITID:       t.FITID,
+		}
+		if err := budget.InsertEntry(tx, e); err != nil {
+			return 0, fmt.Errorf("ImportBudget() - transaction %s: %w", t.FITID, err)
+		}
+	}
+	return skipped, nil
+}
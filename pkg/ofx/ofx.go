@@ -0,0 +1,174 @@
+// Package ofx reads the bank and credit card statements banks offer for
+// download as OFX or QFX files, in both the SGML of OFX 1.x, where elements
+// are left unclosed, and the XML of OFX 2.x. Only the statement
+// transactions are read: their date posted, amount, FITID, name and memo.
+//
+// Imported entries keep the FITID the bank gave them, so importing an
+// overlapping statement again skips the transactions already recorded.
+package ofx
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"ledger/pkg/usd"
+	"os"
+	"strings"
+	"time"
+)
+
+// Transaction is a single STMTTRN record of a statement
+type Transaction struct {
+	Type   string // TRNTYPE, e.g. DEBIT or CREDIT
+	Date   time.Time
+	Amount int // in cents, negative when money left the account
+	FITID  string
+	Name   string
+	Memo   string
+}
+
+// Statement is the transactions of one account
+type Statement struct {
+	Account      string // ACCTID, or empty if the file gives none
+	Currency     string // CURDEF, or empty if the file gives none
+	Transactions []Transaction
+}
+
+// read the statement in an OFX or QFX file
+func ReadFile(filepath string) (Statement, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return Statement{}, fmt.Errorf("ReadFile() - %w", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// entities that may stand in for characters in element values
+var entities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&nbsp;", " ", "&amp;", "&")
+
+// read a statement from OFX, SGML or XML, ignoring the headers before the
+// OFX element and every element that is not part of a transaction, the
+// account id or the currency
+func Parse(r io.Reader) (Statement, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Statement{}, fmt.Errorf("Parse() - %w", err)
+	}
+	text := string(data)
+	start := strings.Index(strings.ToUpper(text), "<OFX>")
+	if start < 0 {
+		return Statement{}, fmt.Errorf("Parse() - no OFX element found")
+	}
+	text = text[start:]
+	var s Statement
+	var t *Transaction
+	for {
+		open := strings.Index(text, "<")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(text[open:], ">")
+		if end < 0 {
+			return Statement{}, fmt.Errorf("Parse() - unterminated tag %q", text[open:])
+		}
+		tag := strings.ToUpper(strings.TrimSpace(text[open+1 : open+end]))
+		text = text[open+end+1:]
+		value := text
+		if next := strings.Index(text, "<"); next >= 0 {
+			value = text[:next]
+		}
+		value = entities.Replace(strings.TrimSpace(value))
+		switch {
+		case tag == "STMTTRN":
+			if t != nil {
+				return Statement{}, fmt.Errorf("Parse() - STMTTRN %s holds another STMTTRN", t.FITID)
+			}
+			t = &Transaction{}
+		case tag == "/STMTTRN":
+			if t == nil {
+				return Statement{}, fmt.Errorf("Parse() - unexpected </STMTTRN>")
+			}
+			if err := t.check(); err != nil {
+				return Statement{}, fmt.Errorf("Parse() - %w", err)
+			}
+			s.Transactions = append(s.Transactions, *t)
+			t = nil
+		case t != nil:
+			if err := t.set(tag, value); err != nil {
+				return Statement{}, fmt.Errorf("Parse() - %w", err)
+			}
+		case tag == "ACCTID":
+			if s.Account != "" && s.Account != value {
+				return Statement{}, fmt.Errorf("Parse() - statements of more than one account, %s and %s", s.Account, value)
+			}
+			s.Account = value
+		case tag == "CURDEF":
+			if s.Currency != "" && s.Currency != value {
+				return Statement{}, fmt.Errorf("Parse() - statements in more than one currency, %s and %s", s.Currency, value)
+			}
+			s.Currency = value
+		}
+	}
+	if t != nil {
+		return Statement{}, fmt.Errorf("Parse() - STMTTRN %s is never closed", t.FITID)
+	}
+	return s, nil
+}
+
+// set a field of a transaction from one of its elements
+func (t *Transaction) set(tag, value string) error {
+	var err error
+	switch tag {
+	case "TRNTYPE":
+		t.Type = value
+	case "DTPOSTED":
+		if t.Date, err = parseDate(value); err != nil {
+			return err
+		}
+	case "TRNAMT":
+		if t.Amount, err = parseAmount(value); err != nil {
+			return err
+		}
+	case "FITID":
+		t.FITID = value
+	case "NAME":
+		t.Name = value
+	case "MEMO":
+		t.Memo = value
+	}
+	return nil
+}
+
+// check that a transaction has the elements every import relies on
+func (t *Transaction) check() error {
+	if t.FITID == "" {
+		return fmt.Errorf("STMTTRN without a FITID")
+	}
+	if t.Date.IsZero() {
+		return fmt.Errorf("STMTTRN %s without a DTPOSTED", t.FITID)
+	}
+	return nil
+}
+
+// parse the day of an OFX datetime, e.g. 20210405 or
+// 20210405120000.000[-5:EST], ignoring the time and time zone
+func parseDate(s string) (time.Time, error) {
+	if len(s) < 8 {
+		return time.Time{}, fmt.Errorf("date %q is too short", s)
+	}
+	d, err := time.Parse("20060102", s[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing date %q: %w", s, err)
+	}
+	return d, nil
+}
+
+// parse an amount into cents, e.g. -1234.5, +1,234.50 or 12,34 where a
+// comma marks the decimals
+func parseAmount(s string) (int, error) {
+	if !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return usd.ParseCents(s)
+}
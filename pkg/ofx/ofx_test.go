@@ -0,0 +1,178 @@
+package ofx_test
+
+import (
+	"database/sql"
+	"ledger/pkg/budget"
+	"ledger/pkg/ledger"
+	"ledger/pkg/ofx"
+	"ledger/pkg/testutils"
+	"ledger/pkg/utils"
+	"strings"
+	"testing"
+)
+
+// an OFX 1.x statement, with its leaf elements left unclosed
+const sgml = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+CHARSET:1252
+
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>USD
+<BANKACCTFROM><BANKID>121000248<ACCTID>0001234<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>20210401<DTEND>20210430
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20210405120000.000[-5:EST]
+<TRNAMT>-12.5
+<FITID>2021040501
+<NAME>Corner shop
+<MEMO>Groceries &amp; more
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20210415
+<TRNAMT>+1,500.00
+<FITID>2021041501
+<NAME>ACME PAYROLL
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+// an OFX 2.x credit card statement
+const xml = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+  <CREDITCARDMSGSRSV1>
+    <CCSTMTTRNRS>
+      <CCSTMTRS>
+        <CURDEF>USD</CURDEF>
+        <CCACCTFROM><ACCTID>4111</ACCTID></CCACCTFROM>
+        <BANKTRANLIST>
+          <STMTTRN>
+            <TRNTYPE>DEBIT</TRNTYPE>
+            <DTPOSTED>20210402</DTPOSTED>
+            <TRNAMT>-40.00</TRNAMT>
+            <FITID>ab-1</FITID>
+            <NAME>Pizza &amp; Co</NAME>
+          </STMTTRN>
+        </BANKTRANLIST>
+      </CCSTMTRS>
+    </CCSTMTTRNRS>
+  </CREDITCARDMSGSRSV1>
+</OFX>
+`
+
+func TestParse(t *testing.T) {
+	got, err := ofx.Parse(strings.NewReader(sgml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ofx.Statement{Account: "0001234", Currency: "USD", Transactions: []ofx.Transaction{
+		{Type: "DEBIT", Date: testutils.Date(4, 5), Amount: -1250, FITID: "2021040501", Name: "Corner shop", Memo: "Groceries & more"},
+		{Type: "CREDIT", Date: testutils.Date(4, 15), Amount: 150000, FITID: "2021041501", Name: "ACME PAYROLL"},
+	}}
+	testutils.AssertEqual(t, want, got)
+
+	got, err = ofx.Parse(strings.NewReader(xml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = ofx.Statement{Account: "4111", Currency: "USD", Transactions: []ofx.Transaction{
+		{Type: "DEBIT", Date: testutils.Date(4, 2), Amount: -4000, FITID: "ab-1", Name: "Pizza & Co"},
+	}}
+	testutils.AssertEqual(t, want, got)
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"no ofx":             "OFXHEADER:100\n",
+		"no fitid":           "<OFX><STMTTRN><DTPOSTED>20210401<TRNAMT>-1.00</STMTTRN></OFX>",
+		"no date":            "<OFX><STMTTRN><FITID>1<TRNAMT>-1.00</STMTTRN></OFX>",
+		"bad amount":         "<OFX><STMTTRN><FITID>1<DTPOSTED>20210401<TRNAMT>ten</STMTTRN></OFX>",
+		"fractions of cents": "<OFX><STMTTRN><FITID>1<DTPOSTED>20210401<TRNAMT>-1.005</STMTTRN></OFX>",
+		"two accounts":       "<OFX><ACCTID>1<ACCTID>2</OFX>",
+		"unclosed":           "<OFX><STMTTRN><FITID>1<DTPOSTED>20210401</OFX>",
+	} {
+		if _, err := ofx.Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	s, err := ofx.Parse(strings.NewReader(sgml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := testutils.Db(t)
+	var first, again int
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		if first, err = ofx.Import(tx, s, "assets:checking", "uncategorized"); err != nil {
+			return err
+		}
+		again, err = ofx.Import(tx, s, "assets:checking", "uncategorized")
+		return err
+	})
+	testutils.AssertEqual(t, 0, first)
+	testutils.AssertEqual(t, 2, again)
+	var entries []ledger.Entry
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		entries, err = ledger.GetEntries(tx)
+		return err
+	})
+	testutils.AssertEqual(t, 2, len(entries))
+	for i, want := range []ledger.Entry{
+		{Source: "assets:checking", Destination: "uncategorized", Amount: 1250, Payee: "Corner shop", Memo: "Groceries & more", FITID: "2021040501"},
+		{Source: "uncategorized", Destination: "assets:checking", Amount: 150000, Payee: "ACME PAYROLL", FITID: "2021041501"},
+	} {
+		got := entries[i]
+		testutils.AssertEqual(t, want.Source, got.Source)
+		testutils.AssertEqual(t, want.Destination, got.Destination)
+		testutils.AssertEqual(t, want.Amount, got.Amount)
+		testutils.AssertEqual(t, want.Payee, got.Payee)
+		testutils.AssertEqual(t, want.Memo, got.Memo)
+		testutils.AssertEqual(t, want.FITID, got.FITID)
+	}
+	testutils.AssertEqual(t, "2021-04-05", utils.FormatDate(entries[0].EntryDate))
+}
+
+func TestImportBudget(t *testing.T) {
+	s, err := ofx.Parse(strings.NewReader(sgml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := testutils.Db(t)
+	var skipped int
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		if _, err = ofx.ImportBudget(tx, s, "groceries"); err != nil {
+			return err
+		}
+		skipped, err = ofx.ImportBudget(tx, s, "groceries")
+		return err
+	})
+	testutils.AssertEqual(t, 2, skipped)
+	var got []budget.Entry
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		got, err = budget.GetBudgetEntries(tx, testutils.Date(4, 1), testutils.Date(4, 30))
+		return err
+	})
+	want := []budget.Entry{
+		{EntryDate: testutils.Date(4, 5), Amount: 1250, Category: "groceries", Description: "Corner shop - Groceries & more"},
+		{EntryDate: testutils.Date(4, 15), Amount: -150000, Category: "groceries", Description: "ACME PAYROLL"},
+	}
+	testutils.AssertEqual(t, want, got)
+
+	s.Currency = "EUR"
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		if _, err := ofx.ImportBudget(tx, s, "groceries"); err == nil {
+			t.Error("want an error importing euros into the budget")
+		}
+		return nil
+	})
+}
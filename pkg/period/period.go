@@ -0,0 +1,245 @@
+package period
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unit is the kind of span an Interval steps by.
+type Unit string
+
+const (
+	Day     Unit = "day"
+	Week    Unit = "week"
+	Month   Unit = "month"
+	Quarter Unit = "quarter"
+	Year    Unit = "year"
+)
+
+// Interval groups days into periods for time series.
+//
+// Day intervals count from the first day asked for, so an interval of 7 days
+// starting on a Wednesday runs Wednesday to Tuesday. Every other unit follows
+// the calendar: weeks begin on FirstWeekday, and quarters and years begin in
+// FirstMonth, which makes a fiscal year when it is not January.
+type Interval struct {
+	Unit         Unit
+	Length       int          // units per period; 0 means 1
+	FirstWeekday time.Weekday // first day of a week; Sunday by default
+	FirstMonth   time.Month   // first month of a year; 0 means January
+}
+
+// Period is one span of days, from Start through Last inclusive.
+type Period struct {
+	Start, Last time.Time
+	Label       string
+}
+
+// Days returns an interval of n days.
+func Days(n int) Interval {
+	return Interval{Unit: Day, Length: n}
+}
+
+// Parse reads an interval such as "7" (days), "month", "2month",
+// "week:monday", "quarter" or "year:april" (a fiscal year starting in April).
+// An empty string means one day.
+func Parse(s string) (Interval, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return Days(1), nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 {
+			return Interval{}, fmt.Errorf("period.Parse() - interval must be at least 1 day, got %d", n)
+		}
+		return Days(n), nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	unit, start := parts[0], ""
+	if len(parts) == 2 {
+		start = parts[1]
+	}
+	digits := len(unit) - len(strings.TrimLeft(unit, "0123456789"))
+	i := Interval{Unit: Unit(strings.TrimSuffix(unit[digits:], "s")), Length: 1}
+	if digits > 0 {
+		i.Length, _ = strconv.Atoi(unit[:digits])
+	}
+	if i.Length < 1 {
+		return Interval{}, fmt.Errorf("period.Parse() - interval %q must be at least 1 %s", s, i.Unit)
+	}
+	switch i.Unit {
+	case Day:
+		if start != "" {
+			return Interval{}, fmt.Errorf("period.Parse() - days have no start: %q", s)
+		}
+	case Week:
+		if start != "" {
+			d, ok := weekdays[start]
+			if !ok {
+				return Interval{}, fmt.Errorf("period.Parse() - unknown weekday %q", start)
+			}
+			i.FirstWeekday = d
+		}
+	case Month:
+		if start != "" {
+			return Interval{}, fmt.Errorf("period.Parse() - months have no start: %q", s)
+		}
+	case Quarter, Year:
+		if start != "" {
+			m, ok := months[start]
+			if !ok {
+				return Interval{}, fmt.Errorf("period.Parse() - unknown month %q", start)
+			}
+			i.FirstMonth = m
+		}
+	default:
+		return Interval{}, fmt.Errorf("period.Parse() - unknown interval %q", s)
+	}
+	return i, nil
+}
+
+var weekdays = map[string]time.Weekday{}
+var months = map[string]time.Month{}
+
+func init() {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		weekdays[name] = d
+		weekdays[name[:3]] = d
+	}
+	for m := time.January; m <= time.December; m++ {
+		name := strings.ToLower(m.String())
+		months[name] = m
+		months[name[:3]] = m
+	}
+}
+
+// format the interval the way Parse reads it, as the flag.Value interface
+// and the JSON of calendar intervals expect
+func (i Interval) String() string {
+	if i.Unit == "" {
+		return "1"
+	}
+	if i.Unit == Day {
+		return strconv.Itoa(i.length())
+	}
+	s := string(i.Unit)
+	if i.length() > 1 {
+		s = strconv.Itoa(i.length()) + s
+	}
+	switch {
+	case i.Unit == Week && i.FirstWeekday != time.Sunday:
+		s += ":" + strings.ToLower(i.FirstWeekday.String())
+	case (i.Unit == Quarter || i.Unit == Year) && i.firstMonth() != time.January:
+		s += ":" + strings.ToLower(i.firstMonth().String())
+	}
+	return s
+}
+
+// Set implements the flag.Value interface
+func (i *Interval) Set(s string) error {
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*i = v
+	return nil
+}
+
+// MarshalJSON writes day intervals as a number of days, as they were before
+// calendar intervals, and any other interval as its string form.
+func (i Interval) MarshalJSON() ([]byte, error) {
+	if i.Unit == "" || i.Unit == Day {
+		return json.Marshal(i.length())
+	}
+	return json.Marshal(i.String())
+}
+
+func (i Interval) length() int {
+	if i.Length < 1 {
+		return 1
+	}
+	return i.Length
+}
+
+func (i Interval) firstMonth() time.Month {
+	if i.FirstMonth == 0 {
+		return time.January
+	}
+	return i.FirstMonth
+}
+
+// Start returns the start of the period holding t. Day intervals start at t
+// itself.
+func (i Interval) Start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch i.Unit {
+	case Week:
+		back := (int(t.Weekday()) - int(i.FirstWeekday) + 7) % 7
+		return time.Date(y, m, d-back, 0, 0, 0, 0, t.Location())
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case Quarter, Year:
+		back := (int(m) - int(i.firstMonth()) + 12) % 12
+		if i.Unit == Quarter {
+			back %= 3
+		}
+		return time.Date(y, m-time.Month(back), 1, 0, 0, 0, 0, t.Location())
+	}
+	return t
+}
+
+// Next returns the start of the period after the one starting at t.
+func (i Interval) Next(t time.Time) time.Time {
+	n := i.length()
+	switch i.Unit {
+	case Week:
+		return t.AddDate(0, 0, 7*n)
+	case Month:
+		return t.AddDate(0, n, 0)
+	case Quarter:
+		return t.AddDate(0, 3*n, 0)
+	case Year:
+		return t.AddDate(0, 12*n, 0)
+	}
+	return t.AddDate(0, 0, n)
+}
+
+// Label names the period starting at t: the start date for days and weeks,
+// "2026-03" for months, "2026-Q1" for quarters and "2026" for years. Fiscal
+// quarters and years are named for the calendar year they end in, as in
+// "FY2027-Q1".
+func (i Interval) Label(t time.Time) string {
+	switch i.Unit {
+	case Month:
+		return t.Format("2006-01")
+	case Quarter, Year:
+		year := t.Year()
+		prefix := ""
+		if i.firstMonth() != time.January {
+			prefix = "FY"
+			if t.Month() >= i.firstMonth() {
+				year++
+			}
+		}
+		if i.Unit == Year {
+			return fmt.Sprintf("%s%d", prefix, year)
+		}
+		quarter := (int(t.Month())-int(i.firstMonth())+12)%12/3 + 1
+		return fmt.Sprintf("%s%d-Q%d", prefix, year, quarter)
+	}
+	return t.Format("2006-01-02")
+}
+
+// Periods splits start through end into whole periods. The first period is
+// the one holding start, and the last runs its full length even past end.
+func (i Interval) Periods(start, end time.Time) []Period {
+	var output []Period
+	for d := i.Start(start); d.Before(end.AddDate(0, 0, 1)); d = i.Next(d) {
+		output = append(output, Period{Start: d, Last: i.Next(d).AddDate(0, 0, -1), Label: i.Label(d)})
+	}
+	return output
+}
@@ -0,0 +1,85 @@
+package period_test
+
+import (
+	"ledger/pkg/period"
+	"ledger/pkg/testutils"
+	"testing"
+	"time"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]period.Interval{
+		"":              period.Days(1),
+		"7":             period.Days(7),
+		"month":         {Unit: period.Month, Length: 1},
+		"2months":       {Unit: period.Month, Length: 2},
+		"week:monday":   {Unit: period.Week, Length: 1, FirstWeekday: time.Monday},
+		"Quarter":       {Unit: period.Quarter, Length: 1},
+		"year:apr":      {Unit: period.Year, Length: 1, FirstMonth: time.April},
+		"quarter:april": {Unit: period.Quarter, Length: 1, FirstMonth: time.April},
+	}
+	for s, want := range tests {
+		t.Run(s, func(t *testing.T) {
+			got, err := period.Parse(s)
+			if err != nil {
+				t.Fatalf("parsing %q: %v", s, err)
+			}
+			testutils.AssertEqual(t, want, got)
+			// and back again
+			again, err := period.Parse(got.String())
+			if err != nil {
+				t.Fatalf("parsing %q: %v", got.String(), err)
+			}
+			testutils.AssertEqual(t, want, again)
+		})
+	}
+	for _, s := range []string{"0", "-3", "fortnight", "week:someday", "year:smarch", "month:monday", "0month"} {
+		if _, err := period.Parse(s); err == nil {
+			t.Errorf("parsing %q: want error", s)
+		}
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	labels := func(ps []period.Period) []string {
+		output := []string{}
+		for _, p := range ps {
+			output = append(output, p.Label)
+		}
+		return output
+	}
+	t.Run("months are whole calendar months", func(t *testing.T) {
+		ps := period.Interval{Unit: period.Month}.Periods(day(2026, 1, 15), day(2026, 3, 2))
+		testutils.AssertEqual(t, []string{"2026-01", "2026-02", "2026-03"}, labels(ps))
+		testutils.AssertEqual(t, day(2026, 1, 1), ps[0].Start)
+		testutils.AssertEqual(t, day(2026, 2, 28), ps[1].Last)
+		testutils.AssertEqual(t, day(2026, 3, 31), ps[2].Last)
+	})
+	t.Run("quarters", func(t *testing.T) {
+		ps := period.Interval{Unit: period.Quarter}.Periods(day(2025, 12, 1), day(2026, 5, 1))
+		testutils.AssertEqual(t, []string{"2025-Q4", "2026-Q1", "2026-Q2"}, labels(ps))
+		testutils.AssertEqual(t, day(2026, 3, 31), ps[1].Last)
+	})
+	t.Run("fiscal years and quarters starting in April", func(t *testing.T) {
+		years := period.Interval{Unit: period.Year, FirstMonth: time.April}.Periods(day(2026, 3, 31), day(2026, 4, 1))
+		testutils.AssertEqual(t, []string{"FY2026", "FY2027"}, labels(years))
+		testutils.AssertEqual(t, day(2025, 4, 1), years[0].Start)
+		quarters := period.Interval{Unit: period.Quarter, FirstMonth: time.April}.Periods(day(2026, 3, 1), day(2026, 7, 1))
+		testutils.AssertEqual(t, []string{"FY2026-Q4", "FY2027-Q1", "FY2027-Q2"}, labels(quarters))
+	})
+	t.Run("weeks start on the chosen weekday", func(t *testing.T) {
+		// 2026-03-04 is a Wednesday
+		ps := period.Interval{Unit: period.Week, FirstWeekday: time.Monday}.Periods(day(2026, 3, 4), day(2026, 3, 9))
+		testutils.AssertEqual(t, []string{"2026-03-02", "2026-03-09"}, labels(ps))
+		testutils.AssertEqual(t, day(2026, 3, 8), ps[0].Last)
+	})
+	t.Run("days count from start", func(t *testing.T) {
+		ps := period.Days(7).Periods(day(2026, 3, 4), day(2026, 3, 12))
+		testutils.AssertEqual(t, []string{"2026-03-04", "2026-03-11"}, labels(ps))
+		testutils.AssertEqual(t, day(2026, 3, 17), ps[1].Last)
+	})
+}
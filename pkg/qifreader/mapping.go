@@ -0,0 +1,252 @@
+package qifreader
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/audit"
+	"ledger/pkg/budget"
+	"ledger/pkg/ledger"
+	"ledger/pkg/usd"
+	"net/http"
+	"strings"
+)
+
+// bucket or category of transactions that name none
+const uncategorized = "uncategorized"
+
+// Mapping says where the accounts and categories of a QIF file are recorded
+type Mapping struct {
+	// bucket of transactions outside any named !Account block
+	Account string
+	// bucket or budget category of each QIF account or category name, e.g.
+	// Food:Groceries; names left out are lowercased, e.g. food:groceries
+	Names map[string]string
+}
+
+// parse a mapping from lines or comma-separated pairs of a QIF name and a
+// bucket, e.g. "Food:Groceries = expenses:groceries, Checking = assets:checking"
+func ParseMapping(account, pairs string) (Mapping, error) {
+	m := Mapping{Account: strings.TrimSpace(account), Names: map[string]string{}}
+	for _, pair := range strings.FieldsFunc(pairs, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return Mapping{}, fmt.Errorf("ParseMapping() - %q is not name = bucket", strings.TrimSpace(pair))
+		}
+		name, bucket := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		if name == "" || bucket == "" {
+			return Mapping{}, fmt.Errorf("ParseMapping() - %q is not name = bucket", strings.TrimSpace(pair))
+		}
+		m.Names[name] = bucket
+	}
+	return m, nil
+}
+
+// get the bucket or category a QIF account or category name is recorded in
+func (m Mapping) bucket(name string) string {
+	if b, ok := m.Names[name]; ok {
+		return b
+	}
+	if name == "" {
+		return uncategorized
+	}
+	return strings.ToLower(name)
+}
+
+// get the bucket a transaction's own account is recorded in
+func (m Mapping) account(t Transaction) (string, error) {
+	if t.Account != "" {
+		return m.bucket(t.Account), nil
+	}
+	if m.Account == "" {
+		return "", fmt.Errorf("no bucket chosen for transactions outside a named account")
+	}
+	return m.Account, nil
+}
+
+// get the bucket a category is recorded in, following transfers to the
+// bucket of the account they name
+func (m Mapping) category(category string) string {
+	if name, ok := transfer(category); ok {
+		return m.bucket(name)
+	}
+	return m.bucket(category)
+}
+
+// Batch is what a QIF file comes to under a mapping, to preview before it is
+// committed. Entries of a split transaction share a TransactionID numbered
+// within the batch.
+type Batch struct {
+	Entries []ledger.Entry
+	Budget  []budget.Entry
+}
+
+// map transactions into ledger entries between each transaction's account
+// and its category, or the categories of its splits. When a file holds both
+// sides of a transfer, the second is left out.
+func ToLedger(transactions []Transaction, m Mapping) (Batch, error) {
+	var b Batch
+	// transfers already mapped, by the accounts money left and entered, each
+	// counted under the QIF account whose record it came from
+	type key struct {
+		day      string
+		from, to string
+		amount   int
+	}
+	mirrors := map[key]map[string]int{}
+	splits := 0
+	for _, t := range transactions {
+		bucket, err := m.account(t)
+		if err != nil {
+			return Batch{}, fmt.Errorf("ToLedger() - %w", err)
+		}
+		status := ledger.Pending
+		switch t.Cleared {
+		case "*", "c":
+			status = ledger.Cleared
+		case "X", "R":
+			status = ledger.Reconciled
+		}
+		if len(t.Splits) == 0 {
+			if name, ok := transfer(t.Category); ok && t.Account != "" {
+				day := t.Date.Format("2006-01-02")
+				mirror := key{day, name, t.Account, t.Amount}
+				if t.Amount < 0 {
+					mirror = key{day, t.Account, name, -t.Amount}
+				}
+				if mirrors[mirror][name] > 0 {
+					mirrors[mirror][name]--
+					continue
+				}
+				if mirrors[mirror] == nil {
+					mirrors[mirror] = map[string]int{}
+				}
+				mirrors[mirror][t.Account]++
+			}
+			e := ledger.Entry{
+				Source:      m.category(t.Category),
+				Destination: bucket,
+				EntryDate:   t.Date,
+				Amount:      t.Amount,
+				Status:      status,
+				Payee:       t.Payee,
+				Memo:        t.Memo,
+			}
+			if t.Amount < 0 {
+				e.Source, e.Destination, e.Amount = bucket, e.Source, -t.Amount
+			}
+			b.Entries = append(b.Entries, e)
+			continue
+		}
+		// postings in the order their buckets first appear, with the
+		// account's own first
+		sum := 0
+		order := []string{bucket}
+		amounts := map[string]int{bucket: t.Amount}
+		var memos []string
+		for _, s := range t.Splits {
+			sum += s.Amount
+			c := m.category(s.Category)
+			if _, ok := amounts[c]; !ok {
+				order = append(order, c)
+			}
+			amounts[c] -= s.Amount
+			if s.Memo != "" {
+				memos = append(memos, s.Memo)
+			}
+		}
+		if sum != t.Amount {
+			return Batch{}, fmt.Errorf("ToLedger() - splits of %s on %s add up to %s, not %s",
+				t.Payee, t.Date.Format("2006-01-02"), usd.Format(sum, ""), usd.Format(t.Amount, ""))
+		}
+		memo := t.Memo
+		if memo == "" {
+			memo = strings.Join(memos, "; ")
+		}
+		split := ledger.Transaction{EntryDate: t.Date, Status: status, Payee: t.Payee, Memo: memo}
+		for _, c := range order {
+			split.Postings = append(split.Postings, ledger.Posting{Bucket: c, Amount: amounts[c]})
+		}
+		entries := split.Entries()
+		if len(entries) > 1 {
+			splits++
+			for i := range entries {
+				entries[i].TransactionID = splits
+			}
+		}
+		b.Entries = append(b.Entries, entries...)
+	}
+	return b, nil
+}
+
+// map transactions into budget entries, one for each transaction or each of
+// its splits, in the category they name. Money out of an account counts as
+// spending and money in as a refund, while transfers are left out.
+func ToBudget(transactions []Transaction, m Mapping) Batch {
+	var b Batch
+	add := func(t Transaction, category, memo string, amount int) {
+		if _, ok := transfer(category); ok {
+			return
+		}
+		description := t.Payee
+		if memo != "" && description != "" {
+			description += " - " + memo
+		} else if memo != "" {
+			description = memo
+		}
+		b.Budget = append(b.Budget, budget.Entry{
+			EntryDate:   t.Date,
+			Amount:      usd.USD(-amount),
+			Category:    m.bucket(category),
+			Description: description,
+		})
+	}
+	for _, t := range transactions {
+		if len(t.Splits) == 0 {
+			add(t, t.Category, t.Memo, t.Amount)
+			continue
+		}
+		for _, s := range t.Splits {
+			memo := s.Memo
+			if memo == "" {
+				memo = t.Memo
+			}
+			add(t, s.Category, memo, s.Amount)
+		}
+	}
+	return b
+}
+
+// record every entry of a batch, inserting each split transaction whole
+func Commit(tx *sql.Tx, b Batch) error {
+	return audit.ChangeSet(tx, func() error {
+		if err := ledger.InsertEntries(tx, b.Entries); err != nil {
+			return fmt.Errorf("Commit() - %w", err)
+		}
+		for _, e := range b.Budget {
+			if err := budget.InsertEntry(tx, e); err != nil {
+				return fmt.Errorf("Commit() - %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// read a QIF file with the mapping posted in a form, as ledger entries or,
+// when the entry type is qif_budget, as budget entries
+func PrepareBatch(r *http.Request, content string) (Batch, error) {
+	transactions, err := Parse(strings.NewReader(content))
+	if err != nil {
+		return Batch{}, err
+	}
+	m, err := ParseMapping(r.PostForm.Get("bucket"), r.PostForm.Get("mapping"))
+	if err != nil {
+		return Batch{}, err
+	}
+	if r.PostForm.Get("entry_type") == "qif_budget" {
+		return ToBudget(transactions, m), nil
+	}
+	return ToLedger(transactions, m)
+}
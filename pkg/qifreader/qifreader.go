@@ -0,0 +1,246 @@
+// Package qifreader reads the Quicken Interchange Format files that older
+// Quicken versions and some credit unions export. It reads the transactions
+// of !Type:Bank, CCard, Cash, Oth A and Oth L sections, with their splits,
+// categories and memos, and the account names of !Account blocks. Category,
+// class and memorized lists are skipped, while investment sections are
+// rejected.
+//
+// Dates are read month first, as Quicken writes them, e.g. 1/5'21 or
+// 01/05/2021, unless the month cannot be one. A transfer's category names
+// the other account in brackets, e.g. [Savings].
+package qifreader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"ledger/pkg/usd"
+	"ledger/pkg/utils"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Split is one S line of a split transaction with its E memo and $ amount
+type Split struct {
+	Category string
+	Memo     string
+	Amount   int // in cents, negative when money left the account
+}
+
+// Transaction is a single record of a bank, credit card or cash section
+type Transaction struct {
+	Account  string // name from the !Account block before the section, or empty
+	Date     time.Time
+	Amount   int // in cents, negative when money left the account
+	Payee    string
+	Memo     string
+	Category string // without its class; a transfer's is [Account]
+	Cleared  string // C field: empty, * or c for cleared, X or R for reconciled
+	Splits   []Split
+}
+
+// section types whose records are transactions
+var transactionTypes = map[string]bool{
+	"bank":  true,
+	"ccard": true,
+	"cash":  true,
+	"oth a": true,
+	"oth l": true,
+}
+
+// section types whose records are skipped
+var skippedTypes = map[string]bool{
+	"cat":       true,
+	"class":     true,
+	"memorized": true,
+	"prices":    true,
+}
+
+// read every transaction in a QIF file
+func ReadFile(filepath string) ([]Transaction, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile() - %w", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// read every transaction of a QIF file's bank, credit card and cash sections
+func Parse(r io.Reader) ([]Transaction, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	const (
+		skipping = iota
+		accounts
+		transactions
+	)
+	section := skipping
+	account, name := "", ""
+	var output []Transaction
+	var t Transaction
+	var fields int
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			header := strings.ToLower(strings.TrimSpace(line[1:]))
+			switch {
+			case header == "account":
+				section = accounts
+			case strings.HasPrefix(header, "type:"):
+				kind := strings.TrimSpace(header[len("type:"):])
+				if transactionTypes[kind] {
+					section = transactions
+				} else if skippedTypes[kind] {
+					section = skipping
+				} else {
+					return nil, fmt.Errorf("Parse() - line %d: unsupported section %s", n, line)
+				}
+			case strings.HasPrefix(header, "option:"), strings.HasPrefix(header, "clear:"):
+				// switches that only matter to Quicken
+			default:
+				return nil, fmt.Errorf("Parse() - line %d: unsupported section %s", n, line)
+			}
+			t, fields = Transaction{Account: account}, 0
+			continue
+		}
+		code, value := line[0], strings.TrimSpace(line[1:])
+		switch section {
+		case accounts:
+			if code == 'N' {
+				name = value
+			} else if code == '^' {
+				account, name = name, ""
+			}
+			continue
+		case skipping:
+			continue
+		}
+		if code == '^' {
+			if fields > 0 {
+				if t.Date.IsZero() {
+					return nil, fmt.Errorf("Parse() - line %d: transaction without a date", n)
+				}
+				output = append(output, t)
+			}
+			t, fields = Transaction{Account: account}, 0
+			continue
+		}
+		fields++
+		if err := t.set(code, value); err != nil {
+			return nil, fmt.Errorf("Parse() - line %d: %w", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Parse() - %w", err)
+	}
+	if fields > 0 {
+		if t.Date.IsZero() {
+			return nil, fmt.Errorf("Parse() - last transaction has no date")
+		}
+		output = append(output, t)
+	}
+	return output, nil
+}
+
+// set a field of a transaction from one line of its record. Unknown fields,
+// e.g. the N check number or A address, are skipped.
+func (t *Transaction) set(code byte, value string) error {
+	var err error
+	switch code {
+	case 'D':
+		t.Date, err = parseDate(value)
+	case 'T', 'U':
+		t.Amount, err = parseAmount(value)
+	case 'P':
+		t.Payee = value
+	case 'M':
+		t.Memo = value
+	case 'L':
+		t.Category = withoutClass(value)
+	case 'C':
+		t.Cleared = value
+	case 'S':
+		t.Splits = append(t.Splits, Split{Category: withoutClass(value)})
+	case 'E', '$':
+		if len(t.Splits) == 0 {
+			return fmt.Errorf("split %c line before any S line", code)
+		}
+		split := &t.Splits[len(t.Splits)-1]
+		if code == 'E' {
+			split.Memo = value
+		} else {
+			split.Amount, err = parseAmount(value)
+		}
+	}
+	return err
+}
+
+// strip the class from a category, e.g. Food:Groceries/Vacation
+func withoutClass(category string) string {
+	if i := strings.Index(category, "/"); i >= 0 {
+		category = category[:i]
+	}
+	return strings.TrimSpace(category)
+}
+
+// get the account a category transfers to, e.g. Savings for [Savings]
+func transfer(category string) (string, bool) {
+	if strings.HasPrefix(category, "[") && strings.HasSuffix(category, "]") {
+		return category[1 : len(category)-1], true
+	}
+	return "", false
+}
+
+// parse a QIF date, month first unless the month cannot be one, with a two
+// digit year in the 2000s after an apostrophe, e.g. 1/5'21, 01/05/2021,
+// 1/5/99 or 2021-01-05
+func parseDate(s string) (time.Time, error) {
+	s = strings.Replace(s, " ", "", -1)
+	if d, err := utils.ParseDate(s); err == nil {
+		return d, nil
+	}
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '\'' || r == '-' || r == '.'
+	})
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("unreadable date %q", s)
+	}
+	var numbers [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unreadable date %q", s)
+		}
+		numbers[i] = v
+	}
+	month, day, year := numbers[0], numbers[1], numbers[2]
+	if month > 12 && day <= 12 {
+		month, day = day, month
+	}
+	if year < 100 {
+		if strings.Contains(s, "'") || year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+	d := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if d.Month() != time.Month(month) || d.Day() != day {
+		return time.Time{}, fmt.Errorf("no such date %q", s)
+	}
+	return d, nil
+}
+
+// parse an amount into cents, e.g. -1,234.5 or $12.00
+func parseAmount(s string) (int, error) {
+	return usd.ParseCents(strings.Replace(s, "$", "", -1))
+}
@@ -0,0 +1,177 @@
+package qifreader_test
+
+import (
+	"database/sql"
+	"ledger/pkg/budget"
+	"ledger/pkg/ledger"
+	"ledger/pkg/qifreader"
+	"ledger/pkg/testutils"
+	"strings"
+	"testing"
+)
+
+// a checking account export with a split and a transfer to savings, followed
+// by the savings side of the same transfer
+const qif = `!Option:AutoSwitch
+!Account
+NChecking
+TBank
+^
+!Clear:AutoSwitch
+!Type:Bank
+D4/1'21
+T-1,200.00
+PLandlord
+MApril rent
+LHousing:Rent/Home
+CX
+^
+D04/03/2021
+T-75.50
+PCorner shop
+LFood:Groceries
+SFood:Groceries
+EVegetables
+$-50.50
+SHousehold
+$-25.00
+C*
+^
+D4/5'21
+T-300.00
+PTransfer
+L[Savings]
+^
+!Account
+NSavings
+TBank
+^
+!Type:Bank
+D4/5'21
+T300.00
+PTransfer
+L[Checking]
+^
+`
+
+func TestParse(t *testing.T) {
+	got, err := qifreader.Parse(strings.NewReader(qif))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []qifreader.Transaction{
+		{Account: "Checking", Date: testutils.Date(4, 1), Amount: -120000, Payee: "Landlord", Memo: "April rent", Category: "Housing:Rent", Cleared: "X"},
+		{Account: "Checking", Date: testutils.Date(4, 3), Amount: -7550, Payee: "Corner shop", Category: "Food:Groceries", Cleared: "*", Splits: []qifreader.Split{
+			{Category: "Food:Groceries", Memo: "Vegetables", Amount: -5050},
+			{Category: "Household", Amount: -2500},
+		}},
+		{Account: "Checking", Date: testutils.Date(4, 5), Amount: -30000, Payee: "Transfer", Category: "[Savings]"},
+		{Account: "Savings", Date: testutils.Date(4, 5), Amount: 30000, Payee: "Transfer", Category: "[Checking]"},
+	}
+	testutils.AssertEqual(t, want, got)
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"investments":   "!Type:Invst\nD4/1'21\n^\n",
+		"no date":       "!Type:Bank\nT-1.00\n^\n",
+		"bad date":      "!Type:Bank\nD2/30'21\n^\n",
+		"bad amount":    "!Type:Bank\nD4/1'21\nTten\n^\n",
+		"split first":   "!Type:Bank\nD4/1'21\n$-1.00\n^\n",
+		"unknown block": "!Unknown\n",
+	} {
+		if _, err := qifreader.Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestToLedger(t *testing.T) {
+	transactions, err := qifreader.Parse(strings.NewReader(qif))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := qifreader.ParseMapping("", "Checking = assets:checking, Savings = assets:savings\nFood:Groceries = expenses:groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := qifreader.ToLedger(transactions, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the savings side of the transfer is left out, while the unmapped
+	// categories are lowercased
+	want := []ledger.Entry{
+		{Source: "assets:checking", Destination: "housing:rent", EntryDate: testutils.Date(4, 1), Amount: 120000, Status: ledger.Reconciled, Payee: "Landlord", Memo: "April rent"},
+		{Source: "assets:checking", Destination: "expenses:groceries", EntryDate: testutils.Date(4, 3), Amount: 5050, Status: ledger.Cleared, Payee: "Corner shop", Memo: "Vegetables", TransactionID: 1},
+		{Source: "assets:checking", Destination: "household", EntryDate: testutils.Date(4, 3), Amount: 2500, Status: ledger.Cleared, Payee: "Corner shop", Memo: "Vegetables", TransactionID: 1},
+		{Source: "assets:checking", Destination: "assets:savings", EntryDate: testutils.Date(4, 5), Amount: 30000, Status: ledger.Pending, Payee: "Transfer"},
+	}
+	testutils.AssertEqual(t, qifreader.Batch{Entries: want}, got)
+
+	// splits must add up to the transaction
+	transactions[1].Splits[1].Amount = -2000
+	if _, err := qifreader.ToLedger(transactions, m); err == nil {
+		t.Error("want an error for splits that do not add up")
+	}
+	// transactions outside a named account need a bucket
+	if _, err := qifreader.ToLedger([]qifreader.Transaction{{Date: testutils.Date(4, 1), Amount: -100}}, m); err == nil {
+		t.Error("want an error without a bucket")
+	}
+	if _, err := qifreader.ParseMapping("", "Checking assets:checking"); err == nil {
+		t.Error("want an error for a pair without =")
+	}
+}
+
+func TestToBudget(t *testing.T) {
+	transactions, err := qifreader.Parse(strings.NewReader(qif))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := qifreader.ParseMapping("", "Food:Groceries = groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []budget.Entry{
+		{EntryDate: testutils.Date(4, 1), Amount: 120000, Category: "housing:rent", Description: "Landlord - April rent"},
+		{EntryDate: testutils.Date(4, 3), Amount: 5050, Category: "groceries", Description: "Corner shop - Vegetables"},
+		{EntryDate: testutils.Date(4, 3), Amount: 2500, Category: "household", Description: "Corner shop"},
+	}
+	testutils.AssertEqual(t, qifreader.Batch{Budget: want}, qifreader.ToBudget(transactions, m))
+}
+
+func TestCommit(t *testing.T) {
+	transactions, err := qifreader.Parse(strings.NewReader(qif))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := qifreader.ParseMapping("", "Checking = assets:checking, Savings = assets:savings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := qifreader.ToLedger(transactions, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := testutils.Db(t)
+	testutils.Tx(t, db, func(tx *sql.Tx) error {
+		return qifreader.Commit(tx, b)
+	})
+	var entries []ledger.Entry
+	testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+		entries, err = ledger.GetEntries(tx)
+		return err
+	})
+	testutils.AssertEqual(t, 4, len(entries))
+	// both halves of the split share a transaction id in the database
+	split := map[int]int{}
+	for _, e := range entries {
+		if e.TransactionID != 0 {
+			split[e.TransactionID]++
+		}
+	}
+	testutils.AssertEqual(t, 1, len(split))
+	for _, n := range split {
+		testutils.AssertEqual(t, 2, n)
+	}
+}
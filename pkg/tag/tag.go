@@ -0,0 +1,93 @@
+package tag
+
+import (
+	"database/sql"
+	"fmt"
+	"ledger/pkg/budget"
+	"ledger/pkg/ledger"
+	"ledger/pkg/usd"
+	"ledger/pkg/utils"
+	"time"
+)
+
+// Report is what a single tag cost across ledger buckets and budget categories
+type Report struct {
+	Tag         string
+	Start       time.Time
+	End         time.Time
+	Buckets     map[string]int
+	Categories  map[string]usd.USD
+	BudgetTotal usd.USD
+}
+
+// summarize every bucket and category touched by entries carrying tag, from
+// start through end. A zero start or end defaults to the earliest or latest
+// tagged entry.
+func Summarize(tx *sql.Tx, tag string, start, end time.Time) (Report, error) {
+	first, last, err := dateRange(tx, tag)
+	if err != nil {
+		return Report{}, fmt.Errorf("tag.Summarize() - %w", err)
+	}
+	if start.IsZero() {
+		start = first
+	}
+	if end.IsZero() {
+		end = last
+	}
+	buckets, err := ledger.GetBuckets(tx, tag)
+	if err != nil {
+		return Report{}, fmt.Errorf("tag.Summarize() - calling ledger.GetBuckets() (%w)", err)
+	}
+	bucketSummary, err := ledger.SummarizeBalance(tx, buckets, start, end, tag)
+	if err != nil {
+		return Report{}, fmt.Errorf("tag.Summarize() - calling ledger.SummarizeBalance() (%w)", err)
+	}
+	categories, err := budget.GetCategories(tx, tag)
+	if err != nil {
+		return Report{}, fmt.Errorf("tag.Summarize() - calling budget.GetCategories() (%w)", err)
+	}
+	categorySummary, err := budget.SummarizeCategories(tx, categories, start, end, tag)
+	if err != nil {
+		return Report{}, fmt.Errorf("tag.Summarize() - calling budget.SummarizeCategories() (%w)", err)
+	}
+	var total usd.USD
+	for _, v := range categorySummary {
+		total += v
+	}
+	return Report{
+		Tag:         tag,
+		Start:       start,
+		End:         end,
+		Buckets:     bucketSummary,
+		Categories:  categorySummary,
+		BudgetTotal: total,
+	}, nil
+}
+
+// get the dates of the earliest and latest entries carrying tag, in either
+// the ledger or the budget
+func dateRange(tx *sql.Tx, tag string) (time.Time, time.Time, error) {
+	q := `SELECT COALESCE(min(d), ''), COALESCE(max(d), '') FROM (
+		SELECT date(happened_at) AS d FROM entries
+		WHERE id IN (SELECT entry_id FROM tags WHERE name = $1)
+		UNION ALL
+		SELECT date(happened_at) AS d FROM budget_entries
+		WHERE id IN (SELECT budget_entry_id FROM tags WHERE name = $1)
+	);`
+	var first, last string
+	if err := tx.QueryRow(q, tag).Scan(&first, &last); err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("querying tagged dates: %w", err)
+	}
+	if first == "" {
+		return utils.BigBang, utils.BigBang, nil
+	}
+	start, err := utils.ParseDate(first)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err := utils.ParseDate(last)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, end, nil
+}
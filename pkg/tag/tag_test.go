@@ -0,0 +1,68 @@
+package tag_test
+
+import (
+	"database/sql"
+	"ledger/pkg/budget"
+	"ledger/pkg/ledger"
+	"ledger/pkg/tag"
+	"ledger/pkg/testutils"
+	"ledger/pkg/usd"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	db := testutils.Db(t)
+	janOne := testutils.JanOne
+	janTwo := testutils.JanTwo
+	t.Run("tagged entries across the ledger and budget",
+		func(t *testing.T) {
+			testutils.Tx(t, db, func(tx *sql.Tx) error {
+				ledgerEntries := []ledger.Entry{
+					{Source: "savings", Destination: "checking", EntryDate: janOne, Amount: 500, Tags: []string{"wedding"}},
+					{Source: "checking", Destination: "venue", EntryDate: janTwo, Amount: 400, Tags: []string{"wedding", "reimbursable"}},
+					{Source: "savings", Destination: "checking", EntryDate: janTwo, Amount: 900},
+				}
+				for _, e := range ledgerEntries {
+					if err := ledger.InsertEntry(tx, e); err != nil {
+						return err
+					}
+				}
+				budgetEntries := []budget.Entry{
+					{EntryDate: janOne, Amount: 150, Category: "flowers", Description: "bouquet", Tags: []string{"wedding"}},
+					{EntryDate: janTwo, Amount: 50, Category: "groceries", Description: "cake", Tags: []string{"wedding"}},
+				}
+				for _, e := range budgetEntries {
+					if err := budget.InsertEntry(tx, e); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			want := tag.Report{
+				Tag:         "wedding",
+				Start:       janOne,
+				End:         janTwo,
+				Buckets:     map[string]int{"checking": 100, "savings": -500, "venue": 400},
+				Categories:  map[string]usd.USD{"flowers": 150, "groceries": 50},
+				BudgetTotal: 200,
+			}
+			var got tag.Report
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = tag.Summarize(tx, "wedding", time.Time{}, time.Time{})
+				return err
+			})
+			testutils.AssertEqual(t, want, got)
+		})
+	t.Run("unknown tag",
+		func(t *testing.T) {
+			var got tag.Report
+			testutils.Tx(t, db, func(tx *sql.Tx) (err error) {
+				got, err = tag.Summarize(tx, "vacation-2026", janOne, janTwo)
+				return err
+			})
+			testutils.AssertEqual(t, map[string]int{}, got.Buckets)
+			testutils.AssertEqual(t, map[string]usd.USD{}, got.Categories)
+			testutils.AssertEqual(t, usd.USD(0), got.BudgetTotal)
+		})
+}
@@ -3,6 +3,7 @@ package testutils
 import (
 	"database/sql"
 	"io/ioutil"
+	"ledger/pkg/migrations"
 	"reflect"
 	"testing"
 	"time"
@@ -16,24 +17,32 @@ var JanOne = time.Date(2021, 01, 01, 0, 0, 0, 0, time.UTC)
 
 var JanTwo = time.Date(2021, 01, 02, 0, 0, 0, 0, time.UTC)
 
-func Db(t *testing.T) *sql.DB {
+// get a day of 2021, the year of JanOne
+func Date(month time.Month, day int) time.Time {
+	return time.Date(2021, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func Db(t testing.TB) *sql.DB {
 	t.Helper()
 
 	db, err := sql.Open("sqlite3", ":memory:")
 	if err != nil {
 		t.Fatalf("opening database: %v", err)
 	}
-	schema, err := ioutil.ReadFile("../../pkg/testutils/testdata/schema.sql")
+	if _, err := migrations.Run(db); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	seed, err := ioutil.ReadFile("../../pkg/testutils/testdata/seed.sql")
 	if err != nil {
-		t.Fatalf("opening schema file: %v", err)
+		t.Fatalf("opening seed file: %v", err)
 	}
-	if _, err := db.Exec(string(schema)); err != nil {
-		t.Fatalf("loading schema: %v", err)
+	if _, err := db.Exec(string(seed)); err != nil {
+		t.Fatalf("loading seed: %v", err)
 	}
 	return db
 }
 
-func Tx(t *testing.T, db *sql.DB, work func(tx *sql.Tx) error) {
+func Tx(t testing.TB, db *sql.DB, work func(tx *sql.Tx) error) {
 	t.Helper()
 	tx, err := db.Begin()
 	if err != nil {
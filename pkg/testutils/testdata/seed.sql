@@ -1,18 +1,3 @@
-CREATE TABLE entries
-(   source TEXT,
-    destination TEXT,
-    happened_at TEXT,
-    amount TEXT
-);
-
-CREATE TABLE budget_entries
-(
-    happened_at TEXT,
-    amount INT,
-    category TEXT,
-    description TEXT
-);
-
 INSERT INTO budget_entries
     (happened_at, amount, category, description)
 VALUES
@@ -17,8 +17,29 @@ func (d *USD) String() string {
 	if d == nil {
 		return fmt.Sprint(nil)
 	}
-	// format &USD(12304) as $123.04
-	return fmt.Sprintf("$%d.%.2d", *d/100, *d%100)
+	// format &USD(12304) as $123.04 and &USD(-150) as -$1.50
+	sign := ""
+	v := *d
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s$%d.%.2d", sign, v/100, v%100)
+}
+
+// Format formats an amount counted in hundredths of a currency, as $123.04
+// for US dollars or an empty currency and as 123.04 EUR for any other
+func Format(amount int, currency string) string {
+	if currency == "" || currency == "USD" {
+		d := USD(amount)
+		return d.String()
+	}
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%.2d %s", sign, amount/100, amount%100, currency)
 }
 
 func StringToUsd(s string) (USD, error) {
@@ -33,3 +54,41 @@ func StringToUsd(s string) (USD, error) {
 		return USD(-1), fmt.Errorf("Could not parse %s, must enter dollar amount with decimal and cents", s)
 	}
 }
+
+// ParseCents parses a decimal amount into hundredths, e.g. -1,234.5 into
+// -123450. Commas are taken to group thousands, and digits past the cents
+// must be zeros.
+func ParseCents(s string) (int, error) {
+	number := strings.Replace(strings.TrimSpace(s), ",", "", -1)
+	sign := 1
+	if strings.HasPrefix(number, "-") {
+		sign = -1
+		number = number[1:]
+	} else if strings.HasPrefix(number, "+") {
+		number = number[1:]
+	}
+	whole, fraction := number, ""
+	if i := strings.Index(number, "."); i >= 0 {
+		whole, fraction = number[:i], number[i+1:]
+	}
+	if len(fraction) > 2 {
+		if strings.Trim(fraction[2:], "0") != "" {
+			return 0, fmt.Errorf("ParseCents() - amount %q has fractions of a cent", s)
+		}
+		fraction = fraction[:2]
+	}
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("ParseCents() - amount %q has no digits", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	cents := 0
+	for _, r := range whole + (fraction + "00")[:2] {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("ParseCents() - amount %q is not a number", s)
+		}
+		cents = cents*10 + int(r-'0')
+	}
+	return sign * cents, nil
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// split a comma-separated list of tags, dropping blanks
+func ParseTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// split the output of group_concat(name, ',') into a sorted list of tags
+func SplitTags(s string) []string {
+	tags := ParseTags(s)
+	sort.Strings(tags)
+	return tags
+}
+
+// tag a row of entries or budget_entries; linkColumn is the tags column
+// that references it, e.g. "entry_id" or "budget_entry_id"
+func InsertTags(tx *sql.Tx, linkColumn string, id int64, tags []string) error {
+	q := fmt.Sprintf(`INSERT INTO tags (name, %s) VALUES ($1, $2);`, linkColumn)
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" || strings.Contains(t, ",") {
+			return fmt.Errorf("InsertTags() - invalid tag %q", t)
+		}
+		if _, err := tx.Exec(q, t, id); err != nil {
+			return fmt.Errorf("InsertTags() - inserting tag: %w", err)
+		}
+	}
+	return nil
+}
+
+// remove all tags from a row of entries or budget_entries
+func DeleteTags(tx *sql.Tx, linkColumn string, id int64) error {
+	q := fmt.Sprintf(`DELETE FROM tags WHERE %s = $1;`, linkColumn)
+	if _, err := tx.Exec(q, id); err != nil {
+		return fmt.Errorf("DeleteTags() - deleting tags: %w", err)
+	}
+	return nil
+}
+
+// TagsColumn selects the comma-separated tags of each row of table, for use
+// with SplitTags
+func TagsColumn(table, linkColumn string) string {
+	return fmt.Sprintf(`COALESCE((SELECT group_concat(name, ',') FROM tags WHERE tags.%s = %s.id), '')`, linkColumn, table)
+}
+
+// TagFilter returns a SQL condition limiting idColumn to rows carrying any of
+// tags, along with its arguments. Placeholders are numbered from first, so
+// the condition must come after every other placeholder in the query. With
+// no tags the condition is empty.
+func TagFilter(idColumn, linkColumn string, tags []string, first int) (string, []interface{}) {
+	if len(tags) == 0 {
+		return "", nil
+	}
+	var placeholders []string
+	var args []interface{}
+	for i, t := range tags {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", first+i))
+		args = append(args, t)
+	}
+	cond := fmt.Sprintf(` AND %s IN (SELECT %s FROM tags WHERE name IN (%s))`,
+		idColumn, linkColumn, strings.Join(placeholders, ", "))
+	return cond, args
+}
+
+// get every tag in use, sorted
+func GetTags(tx *sql.Tx) ([]string, error) {
+	q := `SELECT DISTINCT name FROM tags ORDER BY name;`
+	rows, err := tx.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []string{}
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
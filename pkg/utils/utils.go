@@ -3,6 +3,7 @@ package utils
 import (
 	"database/sql"
 	"fmt"
+	"ledger/pkg/audit"
 	"net/http"
 	"time"
 )
@@ -19,6 +20,12 @@ func Tx(db *sql.DB, r *http.Request, work func(tx *sql.Tx) error) {
 		fmt.Printf("Could not call BeginTx() (%v)", err)
 		return
 	}
+	// record changes as made through the web by whoever sent r
+	if err := audit.SetActor(tx, audit.FromRequest(r, "web")); err != nil {
+		fmt.Printf("Could not call audit.SetActor() (%v)", err)
+		tx.Rollback()
+		return
+	}
 
 	workErr := work(tx)
 	if workErr != nil {
@@ -43,6 +50,11 @@ func ConvertToDate(t time.Time) time.Time {
 	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
 }
 
+// format the calendar day of t as stored in date columns, YYYY-MM-DD
+func FormatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
 // parse a date
 func ParseDate(s string) (time.Time, error) {
 	d, err := time.Parse("2006-01-02", s)